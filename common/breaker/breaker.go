@@ -0,0 +1,289 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 默认值
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 10 * time.Second
+	defaultHalfOpenMaxCalls = 1
+)
+
+var (
+	// ErrOpen 熔断器处于打开状态（或半开状态的探测名额已满），调用被直接拒绝
+	ErrOpen = errors.New("circuit breaker is open")
+
+	// ErrInvalidConfig 配置错误
+	ErrInvalidConfig = errors.New("invalid circuit breaker config")
+)
+
+// State 熔断器状态
+type State int
+
+const (
+	StateClosed   State = iota // 关闭：正常调用，统计连续失败次数
+	StateOpen                  // 打开：直接拒绝调用，OpenTimeout后进入半开
+	StateHalfOpen              // 半开：放行少量探测调用，成功则关闭，失败则重新打开
+)
+
+// String 状态名称，用于日志
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Config 熔断器配置
+type Config struct {
+	// 是否启用熔断器
+	Enabled bool `yaml:"enabled"`
+
+	// 连续失败多少次后打开熔断器，默认5
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	// 调用成功但耗时超过该值时也计为失败（Redis变慢时尽早熔断），0表示不按耗时判断
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+
+	// 打开后多久进入半开状态，默认10s
+	OpenTimeout time.Duration `yaml:"open_timeout"`
+
+	// 半开状态同时允许的探测调用数，默认1
+	HalfOpenMaxCalls int `yaml:"half_open_max_calls"`
+}
+
+// GetFailureThreshold 获取触发熔断的连续失败次数（有默认值）
+func (c *Config) GetFailureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return defaultFailureThreshold
+	}
+	return c.FailureThreshold
+}
+
+// GetOpenTimeout 获取打开状态的持续时间（有默认值）
+func (c *Config) GetOpenTimeout() time.Duration {
+	if c.OpenTimeout <= 0 {
+		return defaultOpenTimeout
+	}
+	return c.OpenTimeout
+}
+
+// GetHalfOpenMaxCalls 获取半开状态的探测调用数（有默认值）
+func (c *Config) GetHalfOpenMaxCalls() int {
+	if c.HalfOpenMaxCalls <= 0 {
+		return defaultHalfOpenMaxCalls
+	}
+	return c.HalfOpenMaxCalls
+}
+
+// Validate 验证配置是否合法
+func (c *Config) Validate() error {
+	if c.FailureThreshold < 0 || c.SlowThreshold < 0 || c.OpenTimeout < 0 || c.HalfOpenMaxCalls < 0 {
+		return fmt.Errorf("%w: negative value", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Breaker 熔断器
+// 连续失败（包括超时、超过SlowThreshold的慢调用）达到阈值后打开，打开期间调用方直接走降级逻辑，
+// 避免每个请求都等到Redis超时；OpenTimeout后放行少量探测调用，成功则恢复
+//
+// nil或未启用的Breaker总是放行
+//
+// 使用方式:
+//
+//	done, err := b.Allow()
+//	if err != nil {
+//		return fallback()
+//	}
+//	err = callRedis()
+//	done(err)
+type Breaker struct {
+	mu       sync.Mutex
+	config   Config
+	state    State
+	failures int       // 关闭状态下的连续失败次数
+	openedAt time.Time // 最近一次打开的时间
+	probes   int       // 半开状态下进行中的探测调用数
+	gen      uint64    // 状态每变化一次加1，用于忽略状态变化前发起的调用结果
+
+	now           func() time.Time
+	onStateChange func(from, to State)
+}
+
+// Option 熔断器选项
+type Option func(*Breaker)
+
+// WithClock 使用自定义时钟（用于测试）
+func WithClock(now func() time.Time) Option {
+	return func(b *Breaker) {
+		b.now = now
+	}
+}
+
+// WithStateChange 设置状态变化的回调（如记录日志、指标），回调在持有锁时调用，不能再调用Breaker的方法
+func WithStateChange(fn func(from, to State)) Option {
+	return func(b *Breaker) {
+		b.onStateChange = fn
+	}
+}
+
+// New 创建熔断器
+func New(config Config, opts ...Option) *Breaker {
+	b := &Breaker{
+		config: config,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// SetConfig 替换配置（支持热加载），禁用时重置为关闭状态
+func (b *Breaker) SetConfig(config Config) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.config = config
+	if !config.Enabled {
+		b.setState(StateClosed)
+	}
+}
+
+// Allow 申请一次调用
+// 返回ErrOpen时不应发起调用；否则调用完成后必须调用done报告结果（err为nil表示成功）
+func (b *Breaker) Allow() (done func(err error), err error) {
+	if b == nil {
+		return func(error) {}, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.config.Enabled {
+		return func(error) {}, nil
+	}
+
+	now := b.now()
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.config.GetOpenTimeout() {
+		b.setState(StateHalfOpen)
+	}
+
+	switch b.state {
+	case StateOpen:
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.probes >= b.config.GetHalfOpenMaxCalls() {
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+
+	gen := b.gen
+	return func(err error) {
+		b.done(gen, now, err)
+	}, nil
+}
+
+// done 记录一次调用的结果
+func (b *Breaker) done(gen uint64, start time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 调用期间状态已经变化（如其它调用触发了熔断），结果不再有意义
+	if gen != b.gen {
+		return
+	}
+
+	failed := err != nil
+	if slow := b.config.SlowThreshold; slow > 0 && b.now().Sub(start) > slow {
+		failed = true
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.GetFailureThreshold() {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		b.probes--
+		if failed {
+			b.setState(StateOpen)
+		} else {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+// setState 切换状态，调用方需持有锁
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.gen++
+	b.failures = 0
+	b.probes = 0
+	if state == StateOpen {
+		b.openedAt = b.now()
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(from, state)
+	}
+}
+
+// State 获取当前状态（打开状态超过OpenTimeout时返回半开）
+func (b *Breaker) State() State {
+	if b == nil {
+		return StateClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.config.GetOpenTimeout() {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Open 是否处于打开状态，用于跳过可有可无的调用（如记录日志性质的写入），不占用探测名额
+func (b *Breaker) Open() bool {
+	return b.State() == StateOpen
+}
+
+// RetryAfter 距离进入半开状态的时间，不处于打开状态时返回0
+func (b *Breaker) RetryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != StateOpen {
+		return 0
+	}
+	if d := b.config.GetOpenTimeout() - b.now().Sub(b.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
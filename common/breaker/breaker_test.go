@@ -0,0 +1,195 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+var errRedis = errors.New("redis down")
+
+// fakeClock 测试用的时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// call 通过熔断器执行一次调用，返回是否被熔断器拒绝
+func call(b *Breaker, err error) bool {
+	done, openErr := b.Allow()
+	if openErr != nil {
+		return true
+	}
+	done(err)
+	return false
+}
+
+// TestBreaker_StateTransitions 测试关闭、打开、半开之间的切换
+func TestBreaker_StateTransitions(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var changes []string
+	b := New(Config{Enabled: true, FailureThreshold: 3, OpenTimeout: 10 * time.Second},
+		WithClock(clock.Now),
+		WithStateChange(func(from, to State) {
+			changes = append(changes, from.String()+"->"+to.String())
+		}))
+
+	// 成功调用会清零连续失败次数
+	call(b, errRedis)
+	call(b, errRedis)
+	call(b, nil)
+	call(b, errRedis)
+	call(b, errRedis)
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed after non-consecutive failures, got %s", b.State())
+	}
+
+	// 连续3次失败后打开
+	call(b, errRedis)
+	if b.State() != StateOpen {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+	if !call(b, nil) {
+		t.Errorf("expected call to be rejected while open")
+	}
+	if got := b.RetryAfter(); cast.ToInt64(got) != int64(10*time.Second) {
+		t.Errorf("expected RetryAfter 10s, got %v", got)
+	}
+
+	// OpenTimeout后进入半开，探测失败重新打开
+	clock.Advance(10 * time.Second)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expected half open, got %s", b.State())
+	}
+	call(b, errRedis)
+	if b.State() != StateOpen {
+		t.Fatalf("expected open after failed probe, got %s", b.State())
+	}
+
+	// 探测成功后关闭
+	clock.Advance(10 * time.Second)
+	call(b, nil)
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed after successful probe, got %s", b.State())
+	}
+
+	want := []string{"closed->open", "open->half_open", "half_open->open", "open->half_open", "half_open->closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("expected state changes %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("expected state change %s, got %s", want[i], changes[i])
+		}
+	}
+}
+
+// TestBreaker_HalfOpenMaxCalls 测试半开状态只放行有限的探测调用
+func TestBreaker_HalfOpenMaxCalls(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := New(Config{Enabled: true, FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenMaxCalls: 2}, WithClock(clock.Now))
+
+	call(b, errRedis)
+	clock.Advance(time.Second)
+
+	done1, err1 := b.Allow()
+	done2, err2 := b.Allow()
+	_, err3 := b.Allow()
+	if err1 != nil || err2 != nil {
+		t.Fatalf("expected 2 probes, got %v %v", err1, err2)
+	}
+	if !errors.Is(err3, ErrOpen) {
+		t.Errorf("expected third probe to be rejected, got %v", err3)
+	}
+
+	done1(nil)
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+	// 状态变化前发起的调用结果被忽略
+	done2(errRedis)
+	if b.State() != StateClosed {
+		t.Errorf("expected stale probe result to be ignored, got %s", b.State())
+	}
+}
+
+// TestBreaker_SlowThreshold 测试慢调用计为失败
+func TestBreaker_SlowThreshold(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := New(Config{Enabled: true, FailureThreshold: 2, SlowThreshold: 100 * time.Millisecond}, WithClock(clock.Now))
+
+	for i := 0; i < 2; i++ {
+		done, err := b.Allow()
+		if err != nil {
+			t.Fatalf("expected call to be allowed, got %v", err)
+		}
+		clock.Advance(200 * time.Millisecond)
+		done(nil)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("expected slow calls to open the breaker, got %s", b.State())
+	}
+}
+
+// TestBreaker_Disabled 测试未启用和nil的熔断器总是放行
+func TestBreaker_Disabled(t *testing.T) {
+	t.Parallel()
+
+	var nilBreaker *Breaker
+	b := New(Config{FailureThreshold: 1})
+	for _, breaker := range []*Breaker{nilBreaker, b} {
+		for i := 0; i < 3; i++ {
+			if call(breaker, errRedis) {
+				t.Fatalf("expected disabled breaker to allow")
+			}
+		}
+		if breaker.Open() {
+			t.Errorf("expected disabled breaker to stay closed")
+		}
+	}
+
+	// 启用后熔断，禁用时恢复
+	b.SetConfig(Config{Enabled: true, FailureThreshold: 1})
+	call(b, errRedis)
+	if !b.Open() {
+		t.Fatalf("expected enabled breaker to open")
+	}
+	b.SetConfig(Config{})
+	if b.Open() || call(b, nil) {
+		t.Errorf("expected breaker to be reset when disabled")
+	}
+}
+
+// TestConfig_Validate 测试配置校验和默认值
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	config := Config{}
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if config.GetFailureThreshold() != defaultFailureThreshold || config.GetOpenTimeout() != defaultOpenTimeout ||
+		config.GetHalfOpenMaxCalls() != defaultHalfOpenMaxCalls {
+		t.Errorf("expected defaults, got %+v", config)
+	}
+
+	config.OpenTimeout = -time.Second
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package clientip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// 支持的客户端IP请求头（对应Config.Header）
+const (
+	HeaderXForwardedFor = "X-Forwarded-For" // 默认
+	HeaderForwarded     = "Forwarded"       // RFC 7239
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ErrInvalidConfig 配置错误
+var ErrInvalidConfig = errors.New("invalid client ip config")
+
+// Config 客户端IP解析配置
+type Config struct {
+	// 可信代理列表，支持单个IP或CIDR，如 "10.0.0.0/8", "127.0.0.1", "::1"
+	// 只有直连地址（RemoteAddr）是可信代理时才会读取请求头；为空则完全忽略请求头
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// 可信代理写入客户端IP的请求头：X-Forwarded-For（默认）、Forwarded、X-Real-IP
+	// 必须与代理的实际行为一致，否则客户端可以通过自己发送的请求头伪造IP
+	Header string `yaml:"header"`
+
+	// IPv6前缀长度，大于0时把IPv6地址归并到该前缀（如64），
+	// 避免同一用户通过轮换/64网段内的地址绕过限流；0表示不归并
+	IPv6PrefixLength int `yaml:"ipv6_prefix_length"`
+}
+
+// GetHeader 获取客户端IP请求头，如果未配置则返回X-Forwarded-For
+func (c *Config) GetHeader() string {
+	if c.Header == "" {
+		return HeaderXForwardedFor
+	}
+	for _, header := range []string{HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP} {
+		if strings.EqualFold(c.Header, header) {
+			return header
+		}
+	}
+	return c.Header
+}
+
+// Validate 验证配置是否合法
+func (c *Config) Validate() error {
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parsePrefix(proxy); err != nil {
+			return fmt.Errorf("%w: trusted proxy %q: %v", ErrInvalidConfig, proxy, err)
+		}
+	}
+	switch c.GetHeader() {
+	case HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP:
+	default:
+		return fmt.Errorf("%w: unsupported header %q", ErrInvalidConfig, c.Header)
+	}
+	if c.IPv6PrefixLength < 0 || c.IPv6PrefixLength > 128 {
+		return fmt.Errorf("%w: ipv6_prefix_length %d out of range", ErrInvalidConfig, c.IPv6PrefixLength)
+	}
+	return nil
+}
+
+// Resolver 客户端IP解析器
+//
+// 解析规则:
+//   - 直连地址不是可信代理：直接使用直连地址，忽略所有请求头
+//   - 直连地址是可信代理：从右向左遍历请求头中的地址链，跳过可信代理，
+//     第一个不可信的地址即为客户端IP（左侧的地址可能是客户端伪造的，不予采信）
+//   - 地址链全部可信：使用最左侧的地址
+type Resolver struct {
+	trusted    []netip.Prefix
+	header     string
+	ipv6Prefix int
+}
+
+// NewResolver 创建客户端IP解析器
+// 不合法的可信代理会被忽略（请先通过Config.Validate校验），
+// 忽略只会让更少的地址被信任，不会导致IP被伪造
+func NewResolver(config *Config) *Resolver {
+	if config == nil {
+		config = &Config{}
+	}
+
+	r := &Resolver{
+		header:     config.GetHeader(),
+		ipv6Prefix: config.IPv6PrefixLength,
+	}
+	for _, proxy := range config.TrustedProxies {
+		if prefix, err := parsePrefix(proxy); err == nil {
+			r.trusted = append(r.trusted, prefix)
+		}
+	}
+	return r
+}
+
+// ClientIP 获取请求的客户端IP（已规范化，IPv6按配置归并为前缀）
+func (r *Resolver) ClientIP(req *http.Request) string {
+	remote, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		// 无法解析的直连地址（如Unix Socket）原样返回
+		return strings.TrimSpace(req.RemoteAddr)
+	}
+
+	client := remote
+	if r.isTrusted(remote) {
+		client = r.walkChain(r.forwardedChain(req), remote)
+	}
+
+	return r.format(client)
+}
+
+// IsTrusted 判断地址是否是可信代理
+func (r *Resolver) IsTrusted(ip string) bool {
+	addr, ok := parseAddr(ip)
+	return ok && r.isTrusted(addr)
+}
+
+// forwardedChain 从配置的请求头中读取地址链（从客户端到最近的代理）
+func (r *Resolver) forwardedChain(req *http.Request) []string {
+	values := req.Header.Values(r.header)
+	if len(values) == 0 {
+		return nil
+	}
+
+	switch r.header {
+	case HeaderForwarded:
+		return parseForwarded(values)
+	case HeaderXRealIP:
+		// X-Real-IP只有一个值，以最后一个为准
+		return []string{values[len(values)-1]}
+	default:
+		var chain []string
+		for _, value := range values {
+			for _, part := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(part))
+			}
+		}
+		return chain
+	}
+}
+
+// walkChain 从右向左遍历地址链，返回第一个不可信的地址
+// 遇到无法解析的地址时停止，返回最后一个成功解析的地址
+func (r *Resolver) walkChain(chain []string, remote netip.Addr) netip.Addr {
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		if !ok {
+			return client
+		}
+		client = addr
+		if !r.isTrusted(addr) {
+			return client
+		}
+	}
+	return client
+}
+
+// isTrusted 判断地址是否在可信代理列表中
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// format 输出规范化的地址，IPv6按配置归并为前缀
+func (r *Resolver) format(addr netip.Addr) string {
+	if addr.Is6() && r.ipv6Prefix > 0 && r.ipv6Prefix < 128 {
+		prefix, err := addr.Prefix(r.ipv6Prefix)
+		if err == nil {
+			return prefix.String()
+		}
+	}
+	return addr.String()
+}
+
+// PrefixList IP/CIDR列表，用于黑白名单等按网段匹配的场景
+type PrefixList []netip.Prefix
+
+// ParsePrefixList 解析IP/CIDR列表，格式同Config.TrustedProxies
+func ParsePrefixList(entries []string) (PrefixList, error) {
+	list := make(PrefixList, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidConfig, entry, err)
+		}
+		list = append(list, prefix)
+	}
+	return list, nil
+}
+
+// Contains 判断IP是否在列表中
+// ip也可以是ClientIP按ipv6_prefix_length归并后的前缀，此时按前缀的网络地址匹配
+func (l PrefixList) Contains(ip string) bool {
+	if len(l) == 0 {
+		return false
+	}
+
+	addr, ok := parseAddr(ip)
+	if !ok {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(ip))
+		if err != nil {
+			return false
+		}
+		addr = prefix.Addr().Unmap()
+	}
+
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded 解析RFC 7239 Forwarded请求头中的for参数
+// 例如: for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			forValue := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(strings.TrimSpace(name), "for") {
+					forValue = strings.Trim(strings.TrimSpace(val), `"`)
+				}
+			}
+			// 没有for参数的元素也要占位，避免错位地信任左侧的地址
+			chain = append(chain, forValue)
+		}
+	}
+	return chain
+}
+
+// parseAddr 解析IP地址，兼容带端口、带方括号、带zone的写法
+// IPv4映射的IPv6地址（::ffff:1.2.3.4）会转换为IPv4
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		// 带端口: 1.2.3.4:80 或 [::1]:80
+		host, _, splitErr := net.SplitHostPort(s)
+		if splitErr != nil {
+			// 不带端口的方括号写法: [::1]
+			host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		}
+		addr, err = netip.ParseAddr(host)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+	}
+
+	return addr.WithZone("").Unmap(), true
+}
+
+// parsePrefix 解析可信代理配置，支持单个IP或CIDR
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
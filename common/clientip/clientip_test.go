@@ -0,0 +1,293 @@
+package clientip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/cast"
+)
+
+// newRequest 创建测试请求
+func newRequest(remoteAddr string, headers map[string][]string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for k, values := range headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	return req
+}
+
+// TestResolver_ClientIP 测试客户端IP解析
+func TestResolver_ClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "127.0.0.1", "2001:db8:ffff::/48"}
+
+	tests := []struct {
+		name       string
+		config     Config
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "no trusted proxies ignores headers",
+			config:     Config{},
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted remote ignores headers",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy uses forwarded client",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "spoofed leftmost entry is ignored",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.9, 10.0.0.2"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "multiple header lines are joined",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6", "198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "all entries trusted uses leftmost",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.1.1.1, 10.0.0.2"}},
+			want:       "10.1.1.1",
+		},
+		{
+			name:       "invalid entry stops the walk",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.9, garbage, 10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "trusted proxy without header",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "x-forwarded-for entry with port",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.9:443"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "rfc 7239 forwarded header",
+			config:     Config{TrustedProxies: trusted, Header: HeaderForwarded},
+			remoteAddr: "10.0.0.1:5000",
+			headers: map[string][]string{"Forwarded": {
+				`for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2;by=10.0.0.1`,
+			}},
+			want: "2001:db8:cafe::17",
+		},
+		{
+			name:       "forwarded header ignored when x-forwarded-for configured",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.9"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "forwarded element without for stops the walk",
+			config:     Config{TrustedProxies: trusted, Header: HeaderForwarded},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.9, proto=http"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "x-real-ip header",
+			config:     Config{TrustedProxies: trusted, Header: "x-real-ip"},
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Real-Ip": {"198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "ipv4 mapped ipv6 is normalised",
+			config:     Config{},
+			remoteAddr: "[::ffff:198.51.100.9]:5000",
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "ipv6 is canonicalised",
+			config:     Config{},
+			remoteAddr: "[2001:DB8:0:0:0:0:0:1]:5000",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "ipv6 zone is stripped",
+			config:     Config{},
+			remoteAddr: "[fe80::1%eth0]:5000",
+			want:       "fe80::1",
+		},
+		{
+			name:       "ipv6 prefix bucketing",
+			config:     Config{IPv6PrefixLength: 64},
+			remoteAddr: "[2001:db8:1:2:aaaa:bbbb:cccc:dddd]:5000",
+			want:       "2001:db8:1:2::/64",
+		},
+		{
+			name:       "ipv6 prefix bucketing does not affect ipv4",
+			config:     Config{IPv6PrefixLength: 64},
+			remoteAddr: "198.51.100.9:5000",
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "trusted ipv6 proxy",
+			config:     Config{TrustedProxies: trusted},
+			remoteAddr: "[2001:db8:ffff::1]:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "unparseable remote addr is returned as is",
+			config:     Config{},
+			remoteAddr: "@",
+			want:       "@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := NewResolver(&tt.config)
+			got := resolver.ClientIP(newRequest(tt.remoteAddr, tt.headers))
+			if cast.ToString(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestConfig_Validate 测试配置校验
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name:   "empty config",
+			config: Config{},
+		},
+		{
+			name: "valid config",
+			config: Config{
+				TrustedProxies:   []string{"10.0.0.0/8", "::1", "::ffff:10.0.0.0/104"},
+				Header:           HeaderForwarded,
+				IPv6PrefixLength: 64,
+			},
+		},
+		{
+			name:   "header is case insensitive",
+			config: Config{Header: "x-real-ip"},
+		},
+		{
+			name:    "invalid trusted proxy",
+			config:  Config{TrustedProxies: []string{"10.0.0.0/33"}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported header",
+			config:  Config{Header: "X-Client-IP"},
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 prefix out of range",
+			config:  Config{IPv6PrefixLength: 129},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidConfig) {
+					t.Errorf("expected ErrInvalidConfig, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestResolver_IsTrusted 测试可信代理判断
+func TestResolver_IsTrusted(t *testing.T) {
+	resolver := NewResolver(&Config{TrustedProxies: []string{"10.0.0.0/8", "::ffff:192.168.0.0/112", "bad"}})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.0.1", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"bad", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := resolver.IsTrusted(tt.ip); cast.ToBool(got) != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestPrefixList_Contains 测试IP/CIDR列表匹配
+func TestPrefixList_Contains(t *testing.T) {
+	list, err := ParsePrefixList([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"2001:db8:1::1", true},
+		{"2001:db8:1::/64", true},
+		{"2001:db9::1", false},
+		{"user:42", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := list.Contains(tt.ip); cast.ToBool(got) != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := ParsePrefixList([]string{"10.0.0.0/33"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -3,9 +3,12 @@ package redis
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sync"
 
 	"github.com/redis/go-redis/v9"
+
+	"working-project/common/logging"
 )
 
 // Manager Redis连接池管理器
@@ -13,6 +16,17 @@ import (
 type Manager struct {
 	mu      sync.RWMutex
 	clients map[string]*redis.Client
+	logger  logging.Logger // 结构化日志，为nil则使用slog.Default()
+}
+
+// Option Manager选项
+type Option func(*Manager)
+
+// WithLogger 设置结构化日志，记录连接的注册结果
+func WithLogger(logger logging.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
 }
 
 var (
@@ -31,15 +45,22 @@ func GetGlobalManager() *Manager {
 }
 
 // NewManager 创建新的Manager实例（用于测试或特殊场景）
-func NewManager() *Manager {
-	return &Manager{
+func NewManager(opts ...Option) *Manager {
+	m := &Manager{
 		clients: make(map[string]*redis.Client),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 // Register 注册一个命名的Redis客户端
 func (m *Manager) Register(name string, cfg *Config) error {
+	logger := logging.OrDefault(m.logger)
+
 	if err := cfg.Validate(); err != nil {
+		logger.ErrorContext(context.Background(), "redis: invalid config", logging.Err(err), slog.String("name", name))
 		return fmt.Errorf("invalid redis config for '%s': %w", name, err)
 	}
 
@@ -53,6 +74,8 @@ func (m *Manager) Register(name string, cfg *Config) error {
 		DialTimeout:  cfg.DialTimeout,
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
+		// 按context的deadline中断命令，限流检查的check_timeout等超时设置才能生效
+		ContextTimeoutEnabled: true,
 	})
 
 	// 测试连接
@@ -61,6 +84,8 @@ func (m *Manager) Register(name string, cfg *Config) error {
 
 	if err := client.Ping(ctx).Err(); err != nil {
 		_ = client.Close()
+		logger.ErrorContext(context.Background(), "redis: connect failed", logging.Err(err),
+			slog.String("name", name), slog.String("addr", cfg.GetAddress()), slog.Int("db", cfg.DB))
 		return fmt.Errorf("failed to connect to redis '%s': %w", name, err)
 	}
 
@@ -73,6 +98,8 @@ func (m *Manager) Register(name string, cfg *Config) error {
 	}
 
 	m.clients[name] = client
+	logger.InfoContext(context.Background(), "redis: client registered",
+		slog.String("name", name), slog.String("addr", cfg.GetAddress()), slog.Int("db", cfg.DB))
 	return nil
 }
 
@@ -3,7 +3,10 @@ package kms
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
+
+	"working-project/common/logging"
 )
 
 // KMSProvider KMS提供商接口，支持不同云厂商的KMS服务
@@ -17,19 +20,34 @@ type KMSProvider interface {
 // Manager KMS管理器
 type Manager struct {
 	provider KMSProvider
-	prefix   string // 配置文件中KMS密文的前缀，如 "kms://"
+	prefix   string         // 配置文件中KMS密文的前缀，如 "kms://"
+	logger   logging.Logger // 结构化日志，为nil则使用slog.Default()
+}
+
+// Option KMS管理器选项
+type Option func(*Manager)
+
+// WithLogger 设置结构化日志，记录解密失败等事件
+func WithLogger(logger logging.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
 }
 
 // NewManager 创建KMS管理器
 // prefix: 配置文件中标识KMS密文的前缀，建议使用 "kms://" 或 "encrypted://"
-func NewManager(provider KMSProvider, prefix string) *Manager {
+func NewManager(provider KMSProvider, prefix string, opts ...Option) *Manager {
 	if prefix == "" {
 		prefix = "kms://"
 	}
-	return &Manager{
+	m := &Manager{
 		provider: provider,
 		prefix:   prefix,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 // DecryptIfNeeded 判断值是否需要解密，如果需要则解密
@@ -48,6 +66,10 @@ func (m *Manager) DecryptIfNeeded(ctx context.Context, value string) (string, er
 
 	plaintext, err := m.provider.Decrypt(ctx, ciphertext)
 	if err != nil {
+		// 不记录密文本身，只记录长度便于排查截断等问题
+		logging.OrDefault(m.logger).ErrorContext(ctx, "kms: decrypt failed",
+			logging.Err(err), slog.String("prefix", m.prefix), slog.Int("ciphertext_len", len(ciphertext)),
+			logging.RequestID(ctx))
 		return "", fmt.Errorf("failed to decrypt: %w", err)
 	}
 
@@ -1,11 +1,17 @@
 package kms
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"strings"
 	"testing"
 
 	"github.com/spf13/cast"
+
+	"working-project/common/logging"
 )
 
 // TestMockProvider_EncryptDecrypt 测试Mock加密解密
@@ -202,3 +208,37 @@ func TestNewManager_DefaultPrefix(t *testing.T) {
 		t.Errorf("expected encrypted value to contain 'kms://', got %s", encrypted)
 	}
 }
+
+// failingProvider 解密总是失败的KMS提供商
+type failingProvider struct {
+	MockProvider
+}
+
+func (p *failingProvider) Decrypt(ctx context.Context, ciphertext string) (string, error) {
+	return "", errors.New("key disabled")
+}
+
+// TestManager_DecryptIfNeeded_LogsFailure 测试解密失败时记录日志且不输出密文
+func TestManager_DecryptIfNeeded_LogsFailure(t *testing.T) {
+	var logs bytes.Buffer
+	manager := NewManager(&failingProvider{}, "kms://", WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	if _, err := manager.DecryptIfNeeded(ctx, "kms://s3cr3t-ciphertext"); err == nil {
+		t.Fatalf("expected decrypt error")
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected 1 JSON log entry, got %q", logs.String())
+	}
+	if cast.ToString(entry["level"]) != "ERROR" || cast.ToString(entry["request_id"]) != "req-1" {
+		t.Errorf("expected error log with request ID, got %v", entry)
+	}
+	if !strings.Contains(cast.ToString(entry["error"]), "key disabled") {
+		t.Errorf("expected provider error in log, got %v", entry["error"])
+	}
+	if strings.Contains(logs.String(), "s3cr3t") {
+		t.Errorf("expected ciphertext not to be logged, got %s", logs.String())
+	}
+}
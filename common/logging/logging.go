@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// Logger 结构化日志接口，*slog.Logger实现了该接口
+// common下的各个组件通过WithLogger选项接收Logger，未设置时使用slog.Default()
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+// 日志字段名
+const (
+	FieldRule      = "rule"       // 限流规则路径
+	FieldKeyHash   = "key_hash"   // 限流key/访客标识的哈希，不输出原始值（可能是IP、Token）
+	FieldPath      = "path"       // 请求路径
+	FieldRequestID = "request_id" // 请求ID
+	FieldError     = "error"      // 错误信息
+)
+
+// RequestIDHeader 读取请求ID的请求头
+const RequestIDHeader = "X-Request-ID"
+
+// keyHashLength key哈希输出的十六进制字符数
+const keyHashLength = 16
+
+// OrDefault 返回logger，为nil时返回slog.Default()
+// 在输出日志时调用，保证slog.SetDefault在组件创建之后调用也能生效
+func OrDefault(logger Logger) Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// Discard 丢弃所有日志的Logger
+func Discard() Logger {
+	return slog.New(discardHandler{})
+}
+
+// discardHandler 丢弃所有日志的slog.Handler
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// HashKey 计算key的哈希（SHA-256前8字节的十六进制），用于在日志中关联同一个key又不泄露原始值
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:keyHashLength]
+}
+
+// Rule 限流规则字段
+func Rule(path string) slog.Attr {
+	return slog.String(FieldRule, path)
+}
+
+// KeyHash key哈希字段
+func KeyHash(key string) slog.Attr {
+	return slog.String(FieldKeyHash, HashKey(key))
+}
+
+// Path 请求路径字段
+func Path(path string) slog.Attr {
+	return slog.String(FieldPath, path)
+}
+
+// Err 错误字段
+func Err(err error) slog.Attr {
+	return slog.Any(FieldError, err)
+}
+
+// requestIDKey 请求ID在context中的key
+type requestIDKey struct{}
+
+// WithRequestID 把请求ID放入context，id为空时返回原context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext 获取context中的请求ID，没有时返回空字符串
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestContext 获取请求的context，其中没有请求ID时取X-Request-ID请求头
+// 中间件把它传给下游组件，下游输出日志时即可带上请求ID
+func RequestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if RequestIDFromContext(ctx) != "" {
+		return ctx
+	}
+	return WithRequestID(ctx, r.Header.Get(RequestIDHeader))
+}
+
+// RequestID 请求ID字段，取自context（见WithRequestID、RequestContext）
+func RequestID(ctx context.Context) slog.Attr {
+	return slog.String(FieldRequestID, RequestIDFromContext(ctx))
+}
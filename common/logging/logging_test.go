@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/cast"
+)
+
+// TestHashKey 测试key哈希稳定且不包含原始值
+func TestHashKey(t *testing.T) {
+	t.Parallel()
+
+	hash := HashKey("192.0.2.1")
+	if len(hash) != keyHashLength {
+		t.Errorf("expected hash length %d, got %d", keyHashLength, len(hash))
+	}
+	if cast.ToString(HashKey("192.0.2.1")) != hash {
+		t.Errorf("expected stable hash, got %s and %s", hash, HashKey("192.0.2.1"))
+	}
+	if HashKey("192.0.2.2") == hash {
+		t.Errorf("expected different keys to have different hashes")
+	}
+}
+
+// TestRequestContext 测试从请求头和context获取请求ID
+func TestRequestContext(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if got := RequestIDFromContext(RequestContext(req)); got != "" {
+		t.Errorf("expected empty request ID, got %s", got)
+	}
+
+	req.Header.Set(RequestIDHeader, "from-header")
+	if got := RequestIDFromContext(RequestContext(req)); cast.ToString(got) != "from-header" {
+		t.Errorf("expected from-header, got %s", got)
+	}
+
+	// context中已有的请求ID优先
+	req = req.WithContext(WithRequestID(context.Background(), "from-context"))
+	ctx := RequestContext(req)
+	if got := RequestIDFromContext(ctx); cast.ToString(got) != "from-context" {
+		t.Errorf("expected from-context, got %s", got)
+	}
+	if attr := RequestID(ctx); attr.Key != FieldRequestID || attr.Value.String() != "from-context" {
+		t.Errorf("expected request_id=from-context, got %v", attr)
+	}
+}
+
+// TestOrDefault 测试未设置Logger时使用slog.Default()
+func TestOrDefault(t *testing.T) {
+	t.Parallel()
+
+	if OrDefault(nil) != Logger(slog.Default()) {
+		t.Errorf("expected slog.Default() for nil logger")
+	}
+	discard := Discard()
+	if OrDefault(discard) != discard {
+		t.Errorf("expected the given logger")
+	}
+	// 丢弃的日志不应panic
+	discard.ErrorContext(context.Background(), "ignored", Err(nil))
+}
@@ -0,0 +1,221 @@
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// adaptiveMaxSamples 每个周期最多保留的延迟样本数，超过后循环覆盖，避免高流量时占用过多内存
+const adaptiveMaxSamples = 4096
+
+// adaptiveMinGradient gradient算法单个周期最多收缩到原来的一半，避免一次延迟毛刺把限额打到地板
+const adaptiveMinGradient = 0.5
+
+// AdaptiveStatus 自适应规则当前的生效限额
+type AdaptiveStatus struct {
+	Tier           string        // 规则所属的套餐，全局规则为空
+	Path           string        // 规则路径
+	Multiplier     float64       // 当前倍数
+	LimitPerSecond int           // 当前生效的每秒限额
+	LimitPerMinute int           // 当前生效的每分钟限额
+	P99            time.Duration // 最近一个周期的p99延迟
+	ErrorRate      float64       // 最近一个周期的5xx比例
+	UpdatedAt      time.Time     // 最近一次调整的时间，零值表示还没有调整过
+}
+
+// adaptiveController 单个规则的自适应状态
+type adaptiveController struct {
+	mu         sync.Mutex
+	tier       string
+	rule       RuleConfig // 配置的规则（未放大），热加载时更新
+	config     AdaptiveConfig
+	multiplier float64
+
+	// 当前周期的观测数据
+	windowStart time.Time
+	latencies   []time.Duration
+	requests    int
+	errors      int
+
+	// 最近一个周期的结果
+	p99       time.Duration
+	errorRate float64
+	updatedAt time.Time
+}
+
+// newAdaptiveController 创建自适应状态，初始倍数为1（限制在上下限之间）
+func newAdaptiveController(tier string, rule *RuleConfig, now time.Time) *adaptiveController {
+	c := &adaptiveController{tier: tier, multiplier: 1, windowStart: now}
+	c.update(rule)
+	return c
+}
+
+// update 使用最新的规则配置（热加载后配置可能变化）
+func (c *adaptiveController) update(rule *RuleConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rule = *rule
+	if c.config != *rule.Adaptive {
+		c.config = *rule.Adaptive
+		c.multiplier = c.clamp(c.multiplier)
+	}
+}
+
+// current 获取当前倍数
+func (c *adaptiveController) current() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.multiplier
+}
+
+// observe 记录一次请求的延迟和状态码，周期结束且样本足够时调整倍数
+func (c *adaptiveController) observe(latency time.Duration, statusCode int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.latencies) < adaptiveMaxSamples {
+		c.latencies = append(c.latencies, latency)
+	} else {
+		c.latencies[c.requests%adaptiveMaxSamples] = latency
+	}
+	c.requests++
+	if statusCode >= 500 {
+		c.errors++
+	}
+
+	if now.Sub(c.windowStart) < c.config.GetInterval() || c.requests < c.config.GetMinSamples() {
+		return
+	}
+	c.adjust(now)
+}
+
+// adjust 根据当前周期的观测结果调整倍数，并开始新的周期
+func (c *adaptiveController) adjust(now time.Time) {
+	c.p99 = percentile(c.latencies, 0.99)
+	c.errorRate = float64(c.errors) / float64(c.requests)
+
+	target := c.config.TargetP99
+	slow := target > 0 && c.p99 > target
+	failing := c.errorRate > c.config.GetMaxErrorRate()
+
+	multiplier := c.multiplier
+	switch {
+	case failing || (slow && c.config.GetAlgorithm() == AdaptiveAIMD):
+		multiplier *= c.config.GetDecreaseFactor()
+	case slow:
+		// gradient: 按目标延迟与实际延迟的比例收缩
+		multiplier *= math.Max(adaptiveMinGradient, float64(target)/float64(c.p99))
+	default:
+		multiplier += c.config.GetIncreaseStep()
+	}
+	c.multiplier = c.clamp(multiplier)
+	c.updatedAt = now
+
+	c.windowStart = now
+	c.latencies = c.latencies[:0]
+	c.requests = 0
+	c.errors = 0
+}
+
+// clamp 把倍数限制在[min_multiplier, max_multiplier]之间
+func (c *adaptiveController) clamp(multiplier float64) float64 {
+	return math.Min(c.config.GetMaxMultiplier(), math.Max(c.config.GetMinMultiplier(), multiplier))
+}
+
+// status 获取当前的生效限额
+func (c *adaptiveController) status() AdaptiveStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	effective := c.rule.scaled(c.multiplier)
+	return AdaptiveStatus{
+		Tier:           c.tier,
+		Path:           c.rule.Path,
+		Multiplier:     c.multiplier,
+		LimitPerSecond: effective.LimitPerSecond,
+		LimitPerMinute: effective.LimitPerMinute,
+		P99:            c.p99,
+		ErrorRate:      c.errorRate,
+		UpdatedAt:      c.updatedAt,
+	}
+}
+
+// percentile 计算延迟的分位数，样本为空时返回0
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// adaptiveRegistry 各自适应规则的状态，按 套餐+规则路径+条件标识 区分
+// 热加载后同一规则继续使用原有状态
+type adaptiveRegistry struct {
+	mu          sync.Mutex
+	controllers map[string]*adaptiveController
+}
+
+// get 获取规则的自适应状态，不存在时创建
+func (r *adaptiveRegistry) get(tier string, rule *RuleConfig) *adaptiveController {
+	id := ruleID(tier, rule)
+
+	r.mu.Lock()
+	c, ok := r.controllers[id]
+	if !ok {
+		if r.controllers == nil {
+			r.controllers = make(map[string]*adaptiveController)
+		}
+		c = newAdaptiveController(tier, rule, time.Now())
+		r.controllers[id] = c
+	}
+	r.mu.Unlock()
+
+	if ok {
+		c.update(rule)
+	}
+	return c
+}
+
+// AdaptiveStatus 获取所有自适应规则当前的生效限额（只包含已经收到过请求的规则）
+// 按套餐、规则路径排序
+func (l *Limiter) AdaptiveStatus() []AdaptiveStatus {
+	l.adaptive.mu.Lock()
+	controllers := make([]*adaptiveController, 0, len(l.adaptive.controllers))
+	for _, c := range l.adaptive.controllers {
+		controllers = append(controllers, c)
+	}
+	l.adaptive.mu.Unlock()
+
+	statuses := make([]AdaptiveStatus, 0, len(controllers))
+	for _, c := range controllers {
+		statuses = append(statuses, c.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Tier != statuses[j].Tier {
+			return statuses[i].Tier < statuses[j].Tier
+		}
+		return statuses[i].Path < statuses[j].Path
+	})
+	return statuses
+}
+
+// Observe 记录请求的处理结果，用于自适应限流
+// 中间件在c.Next()返回后自动调用；不经过中间件时，请求处理完成后调用
+// 匹配的规则没有配置adaptive时什么都不做
+func (r *Result) Observe(latency time.Duration, statusCode int) {
+	if r == nil || r.adaptive == nil {
+		return
+	}
+	r.adaptive.observe(latency, statusCode, time.Now())
+}
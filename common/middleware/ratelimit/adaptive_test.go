@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+)
+
+// observeN 在同一时间点记录n次请求
+func observeN(c *adaptiveController, n int, latency time.Duration, statusCode int, now time.Time) {
+	for i := 0; i < n; i++ {
+		c.observe(latency, statusCode, now)
+	}
+}
+
+// TestAdaptiveController 测试按延迟和错误率调整倍数
+func TestAdaptiveController(t *testing.T) {
+	rule := &RuleConfig{
+		Path:           "/api/*",
+		LimitPerSecond: 100,
+		Adaptive: &AdaptiveConfig{
+			TargetP99:     100 * time.Millisecond,
+			MinMultiplier: 0.5,
+			Interval:      time.Second,
+			MinSamples:    10,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		latency   time.Duration
+		status    int
+		rounds    int
+		want      float64
+	}{
+		{name: "healthy stays at ceiling", latency: 10 * time.Millisecond, status: http.StatusOK, rounds: 1, want: 1},
+		{name: "slow decreases", latency: 200 * time.Millisecond, status: http.StatusOK, rounds: 1, want: 0.7},
+		{name: "errors decrease", latency: 10 * time.Millisecond, status: http.StatusInternalServerError, rounds: 1, want: 0.7},
+		{name: "floor", latency: 200 * time.Millisecond, status: http.StatusOK, rounds: 5, want: 0.5},
+		{name: "gradient", algorithm: AdaptiveGradient, latency: 125 * time.Millisecond, status: http.StatusOK, rounds: 1, want: 0.8},
+		{name: "gradient is bounded", algorithm: AdaptiveGradient, latency: time.Second, status: http.StatusOK, rounds: 1, want: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := *rule
+			config := *rule.Adaptive
+			config.Algorithm = tt.algorithm
+			r.Adaptive = &config
+
+			now := time.Now()
+			c := newAdaptiveController("", &r, now)
+			for i := 1; i <= tt.rounds; i++ {
+				observeN(c, 10, tt.latency, tt.status, now.Add(time.Duration(i)*time.Second))
+			}
+			if got := c.current(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("expected multiplier %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestAdaptiveController_Recover 测试恢复正常后逐步放大，并且样本不足时不调整
+func TestAdaptiveController_Recover(t *testing.T) {
+	rule := &RuleConfig{
+		Path:           "/api/*",
+		LimitPerSecond: 100,
+		Adaptive:       &AdaptiveConfig{MinMultiplier: 0.2, Interval: time.Second, MinSamples: 10},
+	}
+	now := time.Now()
+	c := newAdaptiveController("", rule, now)
+
+	observeN(c, 10, time.Millisecond, http.StatusServiceUnavailable, now.Add(time.Second))
+	observeN(c, 10, time.Millisecond, http.StatusServiceUnavailable, now.Add(2*time.Second))
+	if got := c.current(); math.Abs(got-0.49) > 1e-9 {
+		t.Fatalf("expected multiplier 0.49, got %v", got)
+	}
+
+	// 样本不足
+	observeN(c, 5, time.Millisecond, http.StatusOK, now.Add(3*time.Second))
+	if got := c.current(); math.Abs(got-0.49) > 1e-9 {
+		t.Errorf("expected multiplier unchanged, got %v", got)
+	}
+
+	// 累积够样本后加性增长
+	observeN(c, 5, time.Millisecond, http.StatusOK, now.Add(4*time.Second))
+	if got := c.current(); math.Abs(got-0.54) > 1e-9 {
+		t.Errorf("expected multiplier 0.54, got %v", got)
+	}
+
+	status := c.status()
+	if cast.ToInt(status.LimitPerSecond) != 54 {
+		t.Errorf("expected effective limit 54, got %d", status.LimitPerSecond)
+	}
+}
+
+// TestLimiter_Adaptive 测试自适应倍数作用于限流检查，并可以查询生效限额
+func TestLimiter_Adaptive(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{
+				Path:           "/api/*",
+				LimitPerMinute: 10,
+				BurstSize:      10,
+				Adaptive:       &AdaptiveConfig{MinMultiplier: 0.3, Interval: time.Nanosecond, MinSamples: 1},
+			},
+		},
+	}
+	limiter := NewLimiter(nil, config)
+	ctx := context.Background()
+
+	// 一次失败的请求触发收缩
+	result, err := limiter.Check(ctx, &Request{Key: "probe", Path: "/api/users"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	result.Observe(time.Millisecond, http.StatusInternalServerError)
+
+	statuses := limiter.AdaptiveStatus()
+	if len(statuses) != 1 {
+		t.Fatalf("expected 1 adaptive status, got %d", len(statuses))
+	}
+	if cast.ToString(statuses[0].Path) != "/api/*" || cast.ToInt(statuses[0].LimitPerMinute) != 7 {
+		t.Errorf("expected /api/* limited to 7, got %s %d", statuses[0].Path, statuses[0].LimitPerMinute)
+	}
+
+	if got := countAllowed(t, limiter, &Request{Key: "ip", Path: "/api/users"}, 20); got != 7 {
+		t.Errorf("expected 7 allowed requests, got %d", got)
+	}
+	result, err = limiter.Check(ctx, &Request{Key: "other", Path: "/api/users"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToInt(result.Limit) != 7 {
+		t.Errorf("expected effective limit 7 in result, got %d", result.Limit)
+	}
+}
+
+// TestMiddleware_Adaptive 测试中间件统计后端响应
+func TestMiddleware_Adaptive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{
+				Path:           "/api/*",
+				LimitPerSecond: 100,
+				Adaptive:       &AdaptiveConfig{Interval: time.Nanosecond, MinSamples: 1},
+			},
+		},
+	}
+	limiter := NewLimiter(nil, config)
+
+	r := gin.New()
+	r.Use(Middleware(limiter))
+	r.GET("/api/fail", func(c *gin.Context) {
+		c.Status(http.StatusBadGateway)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/fail", nil))
+
+	statuses := limiter.AdaptiveStatus()
+	if len(statuses) != 1 || math.Abs(statuses[0].Multiplier-0.7) > 1e-9 {
+		t.Errorf("expected multiplier 0.7 after 5xx, got %+v", statuses)
+	}
+}
+
+// TestAdaptiveConfig_Validate 测试自适应配置校验
+func TestAdaptiveConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AdaptiveConfig
+		wantErr bool
+	}{
+		{name: "defaults", config: AdaptiveConfig{}},
+		{name: "gradient", config: AdaptiveConfig{Algorithm: AdaptiveGradient, TargetP99: time.Second}},
+		{name: "unknown algorithm", config: AdaptiveConfig{Algorithm: "vegas"}, wantErr: true},
+		{name: "floor above ceiling", config: AdaptiveConfig{MinMultiplier: 2, MaxMultiplier: 1.5}, wantErr: true},
+		{name: "error rate above 1", config: AdaptiveConfig{MaxErrorRate: 2}, wantErr: true},
+		{name: "decrease factor 1", config: AdaptiveConfig{DecreaseFactor: 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &RuleConfig{Path: "/api/*", LimitPerSecond: 1, Adaptive: &tt.config}
+			err := rule.Validate()
+			if tt.wantErr && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// algorithm 限流算法接口
+// 每种算法都由一段Redis Lua脚本实现，保证"读取-判断-写入"在Redis中原子执行
+type algorithm interface {
+	// allow 检查key在一个period内是否还有cost份配额，有则原子地消费cost份
+	// 参数:
+	//   - rdb: Redis客户端
+	//   - key: Redis key（算法可在其后追加自己的后缀）
+	//   - limit: 每个period允许的请求数
+	//   - burst: 突发容量（仅令牌桶、GCRA使用）
+	//   - cost: 本次请求消耗的配额，>=1
+	//   - period: 时间周期
+	//   - now: 当前时间
+	//
+	// 返回: allowed(是否允许), remaining(剩余配额), resetTime(配额恢复时间), error
+	allow(
+		ctx context.Context,
+		rdb redis.Scripter,
+		key string,
+		limit int,
+		burst int,
+		cost int,
+		period time.Duration,
+		now time.Time,
+	) (bool, int, time.Time, error)
+}
+
+// algorithms 已注册的限流算法
+var algorithms = map[string]algorithm{
+	AlgorithmTokenBucket:          tokenBucket{},
+	AlgorithmSlidingWindowLog:     slidingWindowLog{},
+	AlgorithmSlidingWindowCounter: slidingWindowCounter{},
+	AlgorithmFixedWindow:          fixedWindow{},
+	AlgorithmGCRA:                 gcra{},
+}
+
+// getAlgorithm 根据名称获取限流算法
+func getAlgorithm(name string) (algorithm, error) {
+	algo, ok := algorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidConfig, name)
+	}
+	return algo, nil
+}
+
+// runScript 执行限流Lua脚本并解析结果
+// 所有脚本统一返回: {是否允许(1/0), 剩余配额, 距离配额恢复的时间}
+func runScript(
+	ctx context.Context,
+	rdb redis.Scripter,
+	script *redis.Script,
+	keys []string,
+	args ...interface{},
+) (bool, int, int64, error) {
+	result, err := script.Run(ctx, rdb, keys, args...).Result()
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+
+	resultSlice, ok := result.([]interface{})
+	if !ok || len(resultSlice) != 3 {
+		return false, 0, 0, fmt.Errorf("invalid lua script result")
+	}
+
+	allowed, ok1 := resultSlice[0].(int64)
+	remaining, ok2 := resultSlice[1].(int64)
+	resetAfter, ok3 := resultSlice[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return false, 0, 0, fmt.Errorf("invalid lua script result")
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return allowed == 1, int(remaining), resetAfter, nil
+}
@@ -0,0 +1,360 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// TestAlgorithms_AllowUpToLimit 测试所有算法在同一时刻最多放行limit个请求
+func TestAlgorithms_AllowUpToLimit(t *testing.T) {
+	t.Parallel()
+
+	for name, algo := range algorithms {
+		name, algo := name, algo
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rdb := setupTestRedis(t)
+			ctx := context.Background()
+			now := time.Now()
+
+			// 前3次请求：应该允许，剩余配额依次递减
+			for i := 0; i < 3; i++ {
+				allowed, remaining, _, err := algo.allow(ctx, rdb, "test:key", 3, 3, 1, time.Second, now)
+				if err != nil {
+					t.Fatalf("request %d: expected no error, got %v", i+1, err)
+				}
+				if !cast.ToBool(allowed) {
+					t.Errorf("request %d: expected allowed to be true, got false", i+1)
+				}
+				if cast.ToInt(remaining) != 2-i {
+					t.Errorf("request %d: expected remaining to be %d, got %d", i+1, 2-i, remaining)
+				}
+			}
+
+			// 第4次请求：应该被限流
+			allowed, remaining, resetTime, err := algo.allow(ctx, rdb, "test:key", 3, 3, 1, time.Second, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if cast.ToBool(allowed) {
+				t.Errorf("expected allowed to be false, got true")
+			}
+			if cast.ToInt(remaining) != 0 {
+				t.Errorf("expected remaining to be 0, got %d", remaining)
+			}
+			if !resetTime.After(now) {
+				t.Errorf("expected resetTime to be after now")
+			}
+
+			// 其他key不受影响
+			allowed, _, _, err = algo.allow(ctx, rdb, "test:other", 3, 3, 1, time.Second, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !cast.ToBool(allowed) {
+				t.Errorf("expected other key to be allowed")
+			}
+
+			// 两个周期之后配额完全恢复
+			allowed, _, _, err = algo.allow(ctx, rdb, "test:key", 3, 3, 1, time.Second, now.Add(2*time.Second))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !cast.ToBool(allowed) {
+				t.Errorf("expected allowed to be true after two periods, got false")
+			}
+		})
+	}
+}
+
+// TestSlidingWindowLog_ExactWindow 测试滑动窗口日志精确地按请求时间滑动
+func TestSlidingWindowLog_ExactWindow(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	ctx := context.Background()
+	algo := slidingWindowLog{}
+	start := time.UnixMilli(1700000000000)
+
+	// t=0ms 和 t=400ms 各一次请求
+	for _, offset := range []time.Duration{0, 400 * time.Millisecond} {
+		allowed, _, _, err := algo.allow(ctx, rdb, "login", 2, 0, 1, time.Second, start.Add(offset))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !cast.ToBool(allowed) {
+			t.Errorf("request at %v should be allowed", offset)
+		}
+	}
+
+	// t=999ms：窗口内仍有2次请求，应该被限流
+	allowed, _, resetTime, err := algo.allow(ctx, rdb, "login", 2, 0, 1, time.Second, start.Add(999*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false at 999ms, got true")
+	}
+	if !resetTime.Equal(start.Add(time.Second)) {
+		t.Errorf("expected resetTime %v, got %v", start.Add(time.Second), resetTime)
+	}
+
+	// t=1001ms：t=0的请求已滑出窗口，应该允许
+	allowed, _, _, err = algo.allow(ctx, rdb, "login", 2, 0, 1, time.Second, start.Add(1001*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true at 1001ms, got false")
+	}
+
+	// t=1200ms：t=400和t=1001的请求仍在窗口内，应该被限流
+	allowed, _, _, err = algo.allow(ctx, rdb, "login", 2, 0, 1, time.Second, start.Add(1200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false at 1200ms, got true")
+	}
+}
+
+// TestSlidingWindowCounter_WeightsPreviousWindow 测试滑动窗口计数按比例计入上一个窗口
+func TestSlidingWindowCounter_WeightsPreviousWindow(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	ctx := context.Background()
+	algo := slidingWindowCounter{}
+	start := time.UnixMilli(1700000000000) // 正好是窗口起点
+
+	// 上一个窗口用满4次
+	for i := 0; i < 4; i++ {
+		if allowed, _, _, _ := algo.allow(ctx, rdb, "api", 4, 0, 1, time.Second, start); !allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+
+	// 下一个窗口过去25%：估算值 = 4*0.75 = 3，只剩1次配额
+	next := start.Add(1250 * time.Millisecond)
+	allowed, remaining, _, err := algo.allow(ctx, rdb, "api", 4, 0, 1, time.Second, next)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true, got false")
+	}
+	if cast.ToInt(remaining) != 0 {
+		t.Errorf("expected remaining to be 0, got %d", remaining)
+	}
+
+	allowed, _, _, err = algo.allow(ctx, rdb, "api", 4, 0, 1, time.Second, next)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false, got true")
+	}
+}
+
+// TestFixedWindow_ResetsAtBoundary 测试固定窗口在窗口边界处重置
+func TestFixedWindow_ResetsAtBoundary(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	ctx := context.Background()
+	algo := fixedWindow{}
+	start := time.UnixMilli(1700000000000)
+
+	// 窗口末尾用满2次
+	end := start.Add(900 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if allowed, _, _, _ := algo.allow(ctx, rdb, "api", 2, 0, 1, time.Second, end); !allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	allowed, _, resetTime, err := algo.allow(ctx, rdb, "api", 2, 0, 1, time.Second, end)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false, got true")
+	}
+	if !resetTime.Equal(start.Add(time.Second)) {
+		t.Errorf("expected resetTime %v, got %v", start.Add(time.Second), resetTime)
+	}
+
+	// 进入下一个窗口，立即恢复
+	allowed, _, _, err = algo.allow(ctx, rdb, "api", 2, 0, 1, time.Second, start.Add(time.Second))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true in next window, got false")
+	}
+}
+
+// TestGCRA_SpacesRequests 测试GCRA在突发容量用完后按固定间隔放行
+func TestGCRA_SpacesRequests(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	ctx := context.Background()
+	algo := gcra{}
+	start := time.UnixMilli(1700000000000)
+
+	// limit=2/s, burst=1：请求间隔500ms
+	allowed, _, _, err := algo.allow(ctx, rdb, "api", 2, 1, 1, time.Second, start)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected first request to be allowed")
+	}
+
+	allowed, _, _, err = algo.allow(ctx, rdb, "api", 2, 1, 1, time.Second, start.Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected request at 100ms to be rejected")
+	}
+
+	allowed, _, _, err = algo.allow(ctx, rdb, "api", 2, 1, 1, time.Second, start.Add(500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected request at 500ms to be allowed")
+	}
+}
+
+// TestLimiter_Allow_Algorithm 测试按规则选择限流算法
+func TestLimiter_Allow_Algorithm(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled: true,
+		Rules: []RuleConfig{
+			{
+				Path:           "/api/login",
+				LimitPerMinute: 2,
+				Algorithm:      AlgorithmSlidingWindowLog,
+			},
+			{
+				Path:           "/api/*",
+				LimitPerSecond: 5,
+				BurstSize:      5,
+			},
+		},
+	}
+
+	limiter := NewLimiter(rdb, config)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		allowed, _, _, err := limiter.Allow(ctx, "192.168.1.1", "/api/login")
+		if err != nil {
+			t.Fatalf("request %d: expected no error, got %v", i+1, err)
+		}
+		if !cast.ToBool(allowed) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+
+	// 滑动窗口日志没有突发容量，第3次请求即被限流
+	allowed, _, _, err := limiter.Allow(ctx, "192.168.1.1", "/api/login")
+	if !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected ErrRateLimitExceeded, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false, got true")
+	}
+
+	// 令牌桶规则不受影响
+	allowed, _, _, err = limiter.Allow(ctx, "192.168.1.1", "/api/users")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true, got false")
+	}
+}
+
+// TestLimiter_Allow_UnknownAlgorithm 测试未知算法返回配置错误
+func TestLimiter_Allow_UnknownAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled: true,
+		Rules: []RuleConfig{
+			{
+				Path:           "/api/*",
+				LimitPerSecond: 1,
+				Algorithm:      "leaky_bucket",
+			},
+		},
+	}
+
+	limiter := NewLimiter(rdb, config)
+
+	_, _, _, err := limiter.Allow(context.Background(), "192.168.1.1", "/api/test")
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+// TestAlgorithms_Cost 测试所有算法按cost原子地消耗配额，配额不足时不做部分扣减
+func TestAlgorithms_Cost(t *testing.T) {
+	t.Parallel()
+
+	for name, algo := range algorithms {
+		name, algo := name, algo
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rdb := setupTestRedis(t)
+			ctx := context.Background()
+			now := time.Now()
+
+			// 消耗3份：剩余2份
+			allowed, remaining, _, err := algo.allow(ctx, rdb, "test:key", 5, 5, 3, time.Minute, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !cast.ToBool(allowed) {
+				t.Errorf("expected cost 3 to be allowed")
+			}
+			if cast.ToInt(remaining) != 2 {
+				t.Errorf("expected remaining to be 2, got %d", remaining)
+			}
+
+			// 再消耗3份：配额不足，拒绝
+			allowed, _, _, err = algo.allow(ctx, rdb, "test:key", 5, 5, 3, time.Minute, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if cast.ToBool(allowed) {
+				t.Errorf("expected cost 3 to be rejected with 2 remaining")
+			}
+
+			// 被拒绝的请求没有扣减配额，剩余的2份仍然可用
+			allowed, remaining, _, err = algo.allow(ctx, rdb, "test:key", 5, 5, 2, time.Minute, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !cast.ToBool(allowed) {
+				t.Errorf("expected cost 2 to be allowed")
+			}
+			if cast.ToInt(remaining) != 0 {
+				t.Errorf("expected remaining to be 0, got %d", remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"working-project/common/logging"
+)
+
+// concurrencyAcquireScript 获取并发槽位的Lua脚本
+// 每个槽位是有序集合中的一个成员，score为租约到期时间；先清理过期的槽位再判断是否还有空位
+// 当前时间使用Redis的TIME，各实例的时钟偏差不会导致一个实例清理掉其它实例仍有效的槽位
+// KEYS[1]: 并发槽位的有序集合key
+// ARGV[1]: 槽位ID（每次请求唯一）
+// ARGV[2]: 最大并发数(limit)
+// ARGV[3]: 租约时间(毫秒)
+var concurrencyAcquireScript = redis.NewScript(`
+	local key = KEYS[1]
+	local member = ARGV[1]
+	local limit = tonumber(ARGV[2])
+	local timeout = tonumber(ARGV[3])
+	redis.replicate_commands() -- Redis 5之前在TIME之后写入需要按命令复制
+	local time = redis.call('TIME')
+	local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+	local count = redis.call('ZCARD', key)
+
+	if count >= limit then
+		return {0, 0, 0}
+	end
+
+	redis.call('ZADD', key, now + timeout, member)
+	redis.call('PEXPIRE', key, timeout)
+	return {1, limit - count - 1, 0}
+`)
+
+// concurrencyRenewScript 续约并发槽位的Lua脚本，槽位已过期被清理时返回0
+// 与获取槽位相同，当前时间使用Redis的TIME
+// KEYS[1]: 并发槽位的有序集合key
+// ARGV[1]: 槽位ID
+// ARGV[2]: 租约时间(毫秒)
+var concurrencyRenewScript = redis.NewScript(`
+	local key = KEYS[1]
+	local member = ARGV[1]
+	local timeout = tonumber(ARGV[2])
+	redis.replicate_commands() -- Redis 5之前在TIME之后写入需要按命令复制
+	local time = redis.call('TIME')
+	local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+	if not redis.call('ZSCORE', key, member) then
+		return 0
+	end
+
+	redis.call('ZADD', key, now + timeout, member)
+	redis.call('PEXPIRE', key, timeout)
+	return 1
+`)
+
+// concurrencyStore 并发槽位存储
+type concurrencyStore interface {
+	// acquire 获取一个槽位，返回: acquired(是否获取成功), remaining(剩余槽位), error
+	acquire(ctx context.Context, key, member string, limit int, timeout time.Duration, now time.Time) (bool, int, error)
+
+	// renew 延长槽位的租约
+	renew(ctx context.Context, key, member string, timeout time.Duration, now time.Time) error
+
+	// release 释放槽位
+	release(ctx context.Context, key, member string) error
+}
+
+// redisConcurrencyStore 基于Redis有序集合的分布式并发槽位
+// 实例崩溃时不会释放槽位，由租约到期后下一次acquire清理；租约按Redis的时间计算，忽略参数now
+type redisConcurrencyStore struct {
+	redis *redis.Client
+}
+
+func (s *redisConcurrencyStore) acquire(
+	ctx context.Context,
+	key, member string,
+	limit int,
+	timeout time.Duration,
+	now time.Time,
+) (bool, int, error) {
+	acquired, remaining, _, err := runScript(
+		ctx,
+		s.redis,
+		concurrencyAcquireScript,
+		[]string{key},
+		member,
+		limit,
+		timeout.Milliseconds(),
+	)
+	return acquired, remaining, err
+}
+
+func (s *redisConcurrencyStore) renew(ctx context.Context, key, member string, timeout time.Duration, now time.Time) error {
+	err := concurrencyRenewScript.Run(ctx, s.redis, []string{key}, member, timeout.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+func (s *redisConcurrencyStore) release(ctx context.Context, key, member string) error {
+	if err := s.redis.ZRem(ctx, key, member).Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+// localConcurrencyStore 进程内并发槽位，只限制当前实例的并发数
+// 进程退出时槽位随之消失，因此不需要租约
+type localConcurrencyStore struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newLocalConcurrencyStore() *localConcurrencyStore {
+	return &localConcurrencyStore{counts: make(map[string]int)}
+}
+
+func (s *localConcurrencyStore) acquire(
+	ctx context.Context,
+	key, member string,
+	limit int,
+	timeout time.Duration,
+	now time.Time,
+) (bool, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := s.counts[key]
+	if count >= limit {
+		return false, 0, nil
+	}
+	s.counts[key] = count + 1
+	return true, limit - count - 1, nil
+}
+
+func (s *localConcurrencyStore) renew(ctx context.Context, key, member string, timeout time.Duration, now time.Time) error {
+	return nil
+}
+
+func (s *localConcurrencyStore) release(ctx context.Context, key, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counts[key] <= 1 {
+		delete(s.counts, key)
+		return nil
+	}
+	s.counts[key]--
+	return nil
+}
+
+// minRenewInterval 并发槽位续约的最小间隔
+const minRenewInterval = 100 * time.Millisecond
+
+// Lease 持有的并发槽位
+// 持有期间在后台按租约时间的1/3自动续约，处理完成后必须调用Release
+type Lease struct {
+	Limit     int         // 最大并发数
+	Remaining int         // 获取槽位后剩余的槽位数
+	Rule      *RuleConfig // 匹配到的规则
+
+	// ShadowRejected 规则处于shadow模式且没有空闲槽位，此时没有持有槽位但不返回错误
+	ShadowRejected bool
+
+	store   concurrencyStore // 为nil表示没有获取到槽位
+	key     string
+	member  string
+	timeout time.Duration
+	logger  logging.Logger
+
+	// guard 使用Redis存储时通过限流器的熔断器释放槽位，进程内槽位为nil
+	guard          func(ctx context.Context, fn func() error) error
+	releaseTimeout time.Duration // 中间件释放槽位的超时
+
+	once sync.Once
+	stop chan struct{}
+}
+
+// Release 释放槽位，可以重复调用；lease为nil或没有获取到槽位时什么都不做
+func (l *Lease) Release(ctx context.Context) error {
+	if l == nil || l.store == nil {
+		return nil
+	}
+
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		release := func() error {
+			return l.store.release(ctx, l.key, l.member)
+		}
+		if l.guard != nil {
+			err = l.guard(ctx, release)
+			return
+		}
+		err = release()
+	})
+	return err
+}
+
+// releaseWithTimeout 在请求处理完成后释放槽位
+// 请求的context可能已经取消，所以使用新的context，并按check_timeout（未配置时为租约时间的1/3）限制耗时，
+// Redis无响应时不阻塞请求返回，槽位最迟在租约到期后被回收
+func (l *Lease) releaseWithTimeout() error {
+	if l == nil || l.store == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.releaseTimeout)
+	defer cancel()
+	return l.Release(ctx)
+}
+
+// renewInterval 续约间隔，为租约时间的1/3，最小minRenewInterval
+func (l *Lease) renewInterval() time.Duration {
+	if interval := l.timeout / 3; interval > minRenewInterval {
+		return interval
+	}
+	return minRenewInterval
+}
+
+// keepAlive 定期续约，直到Release
+// 续约失败时不中断请求，槽位最迟在租约到期后被其它请求回收
+func (l *Lease) keepAlive() {
+	interval := l.renewInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := l.store.renew(ctx, l.key, l.member, l.timeout, now); err != nil {
+				l.logger.WarnContext(ctx, "ratelimit: renew concurrency lease failed",
+					logging.Err(err), logging.Rule(l.Rule.Path), logging.KeyHash(l.key))
+			}
+			cancel()
+		}
+	}
+}
+
+// Acquire 为请求获取一个并发槽位，规则匹配方式与Check相同
+// 返回:
+//   - 匹配的规则没有配置max_concurrent（或限流器未启用、IP在白名单中）时返回nil, nil
+//   - 黑名单和封禁由Check（限流中间件）执行，这里不检查
+//   - 没有空闲槽位时同时返回lease（Limit有效，未持有槽位）和ErrConcurrencyLimitExceeded
+//     shadow模式的规则不返回错误，lease.ShadowRejected为true
+//   - 获取成功时返回持有槽位的lease，请求处理完成后调用lease.Release释放
+func (l *Limiter) Acquire(ctx context.Context, req *Request) (*Lease, error) {
+	config, rules := l.snapshot()
+	if !config.Enabled || rules.allow.Contains(req.clientIP()) {
+		return nil, nil
+	}
+
+	ctx, cancel := withCheckTimeout(ctx, config)
+	defer cancel()
+
+	tier := req.Tier
+	override := l.lookupOverride(ctx, config, req.Key)
+	if override != nil && override.Tier != "" {
+		tier = override.Tier
+	}
+
+	rule, ruleTier := rules.match(config, req, tier)
+	if rule == nil || rule.MaxConcurrent <= 0 {
+		return nil, nil
+	}
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	// global范围的槽位由所有客户端共享，不按单个key放大
+	if override != nil && override.Multiplier > 0 && rule.GetScope() != ScopeGlobal {
+		rule = rule.scaled(override.Multiplier)
+	}
+
+	lease := &Lease{
+		Limit:   rule.MaxConcurrent,
+		Rule:    rule,
+		key:     "ratelimit:conc:" + counterSubject(rule, ruleTier, req),
+		member:  newLeaseID(),
+		timeout: rule.GetLeaseTimeout(),
+		logger:  l.log(),
+	}
+
+	store, acquired, remaining, err := l.acquireWithStore(ctx, lease, time.Now())
+	if err != nil {
+		// Redis不可用且没有降级存储，failure_policy为closed的规则按没有空闲槽位处理
+		if errors.Is(err, ErrRedisUnavailable) && rule.failClosed() {
+			return lease, fmt.Errorf("%w: %w", ErrConcurrencyLimitExceeded, err)
+		}
+		return nil, err
+	}
+	if !acquired {
+		// shadow模式只记录，返回未持有槽位的lease
+		if rule.GetMode() == ModeShadow {
+			lease.ShadowRejected = true
+			l.recordShadow(ctx, config, ruleTier, rule, req.Key)
+			return lease, nil
+		}
+		return lease, ErrConcurrencyLimitExceeded
+	}
+
+	lease.Remaining = remaining
+	lease.store = store
+	lease.stop = make(chan struct{})
+	lease.releaseTimeout = config.CheckTimeout
+	if lease.releaseTimeout <= 0 {
+		lease.releaseTimeout = lease.renewInterval()
+	}
+	if _, ok := store.(*redisConcurrencyStore); ok {
+		lease.guard = l.guardRedis
+		go lease.keepAlive()
+	}
+	return lease, nil
+}
+
+// acquireWithStore 获取槽位，Redis不可用且配置了local_fallback时改用进程内槽位
+// 返回实际使用的存储，释放时需要使用同一个存储
+func (l *Limiter) acquireWithStore(ctx context.Context, lease *Lease, now time.Time) (concurrencyStore, bool, int, error) {
+	l.mu.RLock()
+	store, fallback := l.concurrency, l.concurrencyFallback
+	l.mu.RUnlock()
+	if store == nil {
+		store = l.defaultConcurrencyStore()
+	}
+
+	var acquired bool
+	var remaining int
+	err := l.guardRedis(ctx, func() error {
+		var err error
+		start := time.Now()
+		acquired, remaining, err = store.acquire(ctx, lease.key, lease.member, lease.Limit, lease.timeout, now)
+		if _, ok := store.(*redisConcurrencyStore); ok {
+			l.metrics.observeScript("concurrency_acquire", time.Since(start))
+		}
+		return err
+	})
+	if err != nil && errors.Is(err, ErrRedisUnavailable) && fallback != nil {
+		store = fallback
+		acquired, remaining, err = store.acquire(ctx, lease.key, lease.member, lease.Limit, lease.timeout, now)
+	}
+	return store, acquired, remaining, err
+}
+
+// defaultConcurrencyStore 未通过NewLimiter创建时按需创建进程内槽位
+func (l *Limiter) defaultConcurrencyStore() concurrencyStore {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.concurrency == nil {
+		l.concurrency = newLocalConcurrencyStore()
+	}
+	return l.concurrency
+}
+
+// newLeaseID 生成唯一的槽位ID
+func newLeaseID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
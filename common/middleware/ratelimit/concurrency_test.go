@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+
+	"working-project/common/logging"
+)
+
+// concurrencyTestConfig 并发限制测试使用的配置
+func concurrencyTestConfig(backend string) *Config {
+	return &Config{
+		Enabled: true,
+		Backend: backend,
+		Rules: []RuleConfig{
+			{Path: "/report/*", MaxConcurrent: 2, Scope: ScopePerRule},
+			{Path: "/api/*", LimitPerSecond: 10},
+		},
+	}
+}
+
+// TestLimiter_Acquire 测试获取和释放并发槽位
+func TestLimiter_Acquire(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []string{BackendRedis, BackendLocal} {
+		backend := backend
+		t.Run(backend, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := NewLimiter(setupTestRedis(t), concurrencyTestConfig(backend))
+			ctx := context.Background()
+			req := &Request{Key: "ip", Path: "/report/daily"}
+
+			first, err := limiter.Acquire(ctx, req)
+			if err != nil || first == nil {
+				t.Fatalf("expected lease, got %v %v", first, err)
+			}
+			if cast.ToInt(first.Remaining) != 1 {
+				t.Errorf("expected remaining 1, got %d", first.Remaining)
+			}
+
+			// per_rule范围：同一规则的其它路径共享槽位
+			second, err := limiter.Acquire(ctx, &Request{Key: "ip", Path: "/report/monthly"})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			rejected, err := limiter.Acquire(ctx, req)
+			if !errors.Is(err, ErrConcurrencyLimitExceeded) {
+				t.Fatalf("expected ErrConcurrencyLimitExceeded, got %v", err)
+			}
+			if cast.ToInt(rejected.Limit) != 2 {
+				t.Errorf("expected limit 2, got %d", rejected.Limit)
+			}
+
+			// 其它key不受影响
+			other, err := limiter.Acquire(ctx, &Request{Key: "other", Path: "/report/daily"})
+			if err != nil {
+				t.Fatalf("expected other key to acquire, got %v", err)
+			}
+			_ = other.Release(ctx)
+
+			// 释放后可以重新获取，重复释放不会多释放槽位
+			if err := first.Release(ctx); err != nil {
+				t.Fatalf("expected no error on Release, got %v", err)
+			}
+			_ = first.Release(ctx)
+			third, err := limiter.Acquire(ctx, req)
+			if err != nil {
+				t.Fatalf("expected lease after release, got %v", err)
+			}
+			if _, err := limiter.Acquire(ctx, req); !errors.Is(err, ErrConcurrencyLimitExceeded) {
+				t.Errorf("expected ErrConcurrencyLimitExceeded, got %v", err)
+			}
+
+			_ = second.Release(ctx)
+			_ = third.Release(ctx)
+		})
+	}
+}
+
+// TestLimiter_Acquire_NoLimit 测试没有配置max_concurrent时不获取槽位
+func TestLimiter_Acquire_NoLimit(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(nil, concurrencyTestConfig(BackendLocal))
+	for _, path := range []string{"/api/users", "/public"} {
+		lease, err := limiter.Acquire(context.Background(), &Request{Key: "ip", Path: path})
+		if err != nil || lease != nil {
+			t.Errorf("expected nil lease for %s, got %v %v", path, lease, err)
+		}
+		// nil lease可以直接Release
+		if err := lease.Release(context.Background()); err != nil {
+			t.Errorf("expected no error on nil lease, got %v", err)
+		}
+	}
+}
+
+// TestRedisConcurrencyStore_LeaseExpired 测试租约到期后槽位自动释放（模拟实例崩溃）
+// 租约按Redis的时间计算，通过miniredis.SetTime推进时间
+func TestRedisConcurrencyStore_LeaseExpired(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("expected no error on miniredis.Run, got %v", err)
+	}
+	t.Cleanup(mr.Close)
+	store := &redisConcurrencyStore{redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	ctx := context.Background()
+	now := time.Now()
+	timeout := 10 * time.Second
+
+	mr.SetTime(now)
+	if ok, _, err := store.acquire(ctx, "conc", "a", 1, timeout, now); err != nil || !ok {
+		t.Fatalf("expected acquired, got %v %v", ok, err)
+	}
+	mr.SetTime(now.Add(time.Second))
+	if ok, _, _ := store.acquire(ctx, "conc", "b", 1, timeout, now); ok {
+		t.Errorf("expected slot to be held")
+	}
+
+	// 续约后租约从续约时间重新计算
+	mr.SetTime(now.Add(5 * time.Second))
+	if err := store.renew(ctx, "conc", "a", timeout, now); err != nil {
+		t.Fatalf("expected no error on renew, got %v", err)
+	}
+	mr.SetTime(now.Add(11 * time.Second))
+	if ok, _, _ := store.acquire(ctx, "conc", "b", 1, timeout, now); ok {
+		t.Errorf("expected renewed slot to be held")
+	}
+
+	// 本地时钟快了一小时的实例不会清理其它实例仍有效的槽位
+	if ok, _, _ := store.acquire(ctx, "conc", "c", 1, timeout, now.Add(time.Hour)); ok {
+		t.Errorf("expected clock skew not to reclaim a live slot")
+	}
+
+	// 不再续约，租约到期后被回收
+	mr.SetTime(now.Add(16 * time.Second))
+	if ok, _, err := store.acquire(ctx, "conc", "b", 1, timeout, now); err != nil || !ok {
+		t.Errorf("expected expired slot to be reclaimed, got %v %v", ok, err)
+	}
+}
+
+// TestConcurrencyMiddleware 测试并发限制中间件
+func TestConcurrencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := concurrencyTestConfig(BackendLocal)
+	config.Rules[0].MaxConcurrent = 1
+	limiter := NewLimiter(nil, config)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	r := gin.New()
+	r.Use(ConcurrencyMiddleware(limiter))
+	r.GET("/report/slow", func(c *gin.Context) {
+		close(started)
+		<-finish
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/report/fast", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	var slow *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		slow = serve("/report/slow")
+	}()
+	<-started
+
+	// 慢请求处理中，槽位被占用
+	w := serve("/report/fast")
+	if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", w.Code)
+	}
+	if cast.ToString(w.Header().Get("Retry-After")) != "1" {
+		t.Errorf("expected Retry-After 1, got %s", w.Header().Get("Retry-After"))
+	}
+	if cast.ToString(w.Header().Get("X-ConcurrencyLimit-Limit")) != "1" {
+		t.Errorf("expected X-ConcurrencyLimit-Limit 1, got %s", w.Header().Get("X-ConcurrencyLimit-Limit"))
+	}
+
+	close(finish)
+	wg.Wait()
+	if cast.ToInt(slow.Code) != http.StatusOK {
+		t.Errorf("expected slow request status 200, got %d", slow.Code)
+	}
+
+	// c.Next()返回后槽位已释放
+	if w := serve("/report/fast"); cast.ToInt(w.Code) != http.StatusOK {
+		t.Errorf("expected status 200 after release, got %d", w.Code)
+	}
+}
+
+// TestRuleConfig_Validate_LeaseTimeout 测试过短的lease_timeout（如不带单位的整数）无法通过校验
+func TestRuleConfig_Validate_LeaseTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		wantErr bool
+	}{
+		{0, false},
+		{2, true}, // lease_timeout: 2 按2纳秒解析
+		{500 * time.Millisecond, true},
+		{time.Second, false},
+		{-time.Second, true},
+	}
+	for _, tt := range tests {
+		rule := RuleConfig{Path: "/report/*", MaxConcurrent: 1, LeaseTimeout: tt.timeout}
+		if err := rule.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("lease_timeout %v: expected error=%v, got %v", tt.timeout, tt.wantErr, err)
+		}
+	}
+
+	// 续约间隔有下限，不会因为租约过短而为0
+	lease := &Lease{timeout: 2}
+	if got := lease.renewInterval(); got != minRenewInterval {
+		t.Errorf("expected renew interval %v, got %v", minRenewInterval, got)
+	}
+}
+
+// TestLease_ReleaseTimeout 测试Redis无响应时释放槽位按超时返回，并经过熔断器
+func TestLease_ReleaseTimeout(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(nil, &Config{Enabled: true}, WithLogger(logging.Discard()))
+	lease := &Lease{
+		Rule:           &RuleConfig{Path: "/report/*", MaxConcurrent: 1},
+		store:          &redisConcurrencyStore{redis: setupBlackholeRedis(t)},
+		key:            "ratelimit:conc:ip:rule:/report/*",
+		member:         "a",
+		timeout:        time.Minute,
+		guard:          limiter.guardRedis,
+		releaseTimeout: 50 * time.Millisecond,
+		stop:           make(chan struct{}),
+	}
+
+	start := time.Now()
+	err := lease.releaseWithTimeout()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected release to give up after its timeout, took %v", elapsed)
+	}
+	if !errors.Is(err, ErrCheckTimeout) {
+		t.Errorf("expected ErrCheckTimeout, got %v", err)
+	}
+}
@@ -1,11 +1,194 @@
 package ratelimit
 
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"working-project/common/breaker"
+	"working-project/common/clientip"
+)
+
+// 限流算法名称（对应RuleConfig.Algorithm）
+const (
+	AlgorithmTokenBucket          = "token_bucket"           // 令牌桶（默认），允许突发流量
+	AlgorithmSlidingWindowLog     = "sliding_window_log"     // 滑动窗口日志，精确但占用内存较多
+	AlgorithmSlidingWindowCounter = "sliding_window_counter" // 滑动窗口计数，近似精确且内存占用小
+	AlgorithmFixedWindow          = "fixed_window"           // 固定窗口，最简单，窗口边界处可能出现2倍流量
+	AlgorithmGCRA                 = "gcra"                   // 通用信元速率算法，平滑且只需存储一个时间戳
+)
+
+// 限流计数范围（对应RuleConfig.Scope）
+const (
+	ScopePerPath          = "per_path"           // 每个客户端的每个请求路径单独计数（默认）
+	ScopePerRule          = "per_rule"           // 每个客户端在规则匹配的所有路径上共享配额
+	ScopePerRouteTemplate = "per_route_template" // 每个客户端的每个路由模板单独计数，如 /users/:id
+	ScopeGlobal           = "global"             // 所有客户端在规则匹配的所有路径上共享配额
+)
+
+// 规则的执行模式（对应RuleConfig.Mode）
+const (
+	ModeEnforce = "enforce" // 超限时拒绝请求（默认）
+	ModeShadow  = "shadow"  // 正常计数，超限时只记录不拒绝，用于上线新规则前评估影响
+)
+
+// Redis不可用时的处理策略（对应RuleConfig.FailurePolicy）
+const (
+	FailureOpen   = "open"   // 放行请求（默认），中间件记录日志和ratelimit_fail_open_total
+	FailureClosed = "closed" // 拒绝请求（返回ErrFailClosed，中间件返回429），用于登录、短信等宁可拒绝也不能放开的接口
+)
+
+// 存储后端名称（对应Config.Backend）
+const (
+	BackendRedis = "redis" // Redis分布式限流（默认）
+	BackendLocal = "local" // 进程内限流，仅适用于单实例部署
+)
+
 // RuleConfig 单个路径的限流规则配置
 type RuleConfig struct {
-	Path           string `yaml:"path"`             // 路径，支持通配符，如 "/api/*", "/admin/user/*"
+	Path           string `yaml:"path"`             // 路径，支持通配符，如 "/api/*", "/admin/**", "/users/:id"
 	LimitPerSecond int    `yaml:"limit_per_second"` // 每秒最大请求数，0表示不限制
 	LimitPerMinute int    `yaml:"limit_per_minute"` // 每分钟最大请求数，0表示不限制
 	BurstSize      int    `yaml:"burst_size"`       // 突发流量大小（令牌桶容量），默认为limit的2倍
+	Algorithm      string `yaml:"algorithm"`        // 限流算法，默认token_bucket
+	Scope          string `yaml:"scope"`            // 计数范围，默认per_path
+	Cost           int    `yaml:"cost"`             // 每次请求消耗的配额，默认1
+
+	// 以下为可选的匹配条件，全部满足时规则才匹配
+	Methods []string          `yaml:"methods"` // HTTP方法，如 ["POST"]，为空匹配所有方法
+	Host    string            `yaml:"host"`    // 域名，支持 "*.example.com"，为空匹配所有域名
+	Headers map[string]string `yaml:"headers"` // 请求头，值为空表示只要求存在该请求头
+	Query   map[string]string `yaml:"query"`   // 查询参数，值为空表示只要求存在该参数
+
+	// 优先级，多个规则同时匹配时数值大的优先；相同时更具体的规则优先
+	Priority int `yaml:"priority"`
+
+	// 最大并发请求数（同时处理中的请求），0表示不限制；只对ConcurrencyMiddleware生效
+	// 并发槽位的计数范围同scope
+	MaxConcurrent int `yaml:"max_concurrent"`
+
+	// 并发槽位的租约时间，默认60秒，最小1秒；持有槽位的实例崩溃后最多该时间后自动释放
+	// 请求处理期间会自动续约，不需要大于请求的最长处理时间
+	LeaseTimeout time.Duration `yaml:"lease_timeout"`
+
+	// 自适应限流，根据后端p99延迟和5xx比例自动收缩/放大限额，为空表示使用固定限额
+	Adaptive *AdaptiveConfig `yaml:"adaptive,omitempty"`
+
+	// 执行模式：enforce（默认）或 shadow；shadow模式的超限记录见Limiter.ShadowSummary
+	Mode string `yaml:"mode"`
+
+	// Redis不可用（包括熔断器打开）且没有降级存储时的处理策略：open（默认）或 closed
+	// shadow模式的规则总是放行
+	FailurePolicy string `yaml:"failure_policy"`
+}
+
+// 自适应限流的调整算法（对应AdaptiveConfig.Algorithm）
+const (
+	AdaptiveAIMD     = "aimd"     // 加性增、乘性减（默认）：过载时按decrease_factor收缩，正常时每次增加increase_step
+	AdaptiveGradient = "gradient" // 梯度：按target_p99/p99的比例收缩，类似Netflix concurrency-limits的Gradient算法
+)
+
+// AdaptiveConfig 自适应限流配置
+// 生效限额 = 配置的限额 × 倍数，倍数在[min_multiplier, max_multiplier]之间按观测结果调整
+// 延迟和错误率由中间件在c.Next()返回后统计，只统计当前实例，各实例独立调整
+type AdaptiveConfig struct {
+	Algorithm     string        `yaml:"algorithm"`      // 调整算法：aimd（默认）或 gradient
+	TargetP99     time.Duration `yaml:"target_p99"`     // p99延迟的目标值，超过即视为过载，0表示不按延迟调整
+	MaxErrorRate  float64       `yaml:"max_error_rate"` // 5xx比例的上限，超过即视为过载，默认0.05
+	MinMultiplier float64       `yaml:"min_multiplier"` // 倍数下限（限额的地板），默认0.1
+	MaxMultiplier float64       `yaml:"max_multiplier"` // 倍数上限（限额的天花板），默认1
+	Interval      time.Duration `yaml:"interval"`       // 调整周期，默认5秒
+	MinSamples    int           `yaml:"min_samples"`    // 每个周期至少需要的请求数，不足时继续累积，默认20
+
+	IncreaseStep   float64 `yaml:"increase_step"`   // 正常时每个周期增加的倍数，默认0.05
+	DecreaseFactor float64 `yaml:"decrease_factor"` // aimd过载时倍数乘以该值，默认0.7
+}
+
+// GetAlgorithm 获取调整算法，如果未配置则返回aimd
+func (a *AdaptiveConfig) GetAlgorithm() string {
+	if a.Algorithm == "" {
+		return AdaptiveAIMD
+	}
+	return a.Algorithm
+}
+
+// GetMaxErrorRate 获取5xx比例上限，如果未配置则返回0.05
+func (a *AdaptiveConfig) GetMaxErrorRate() float64 {
+	if a.MaxErrorRate <= 0 {
+		return 0.05
+	}
+	return a.MaxErrorRate
+}
+
+// GetMinMultiplier 获取倍数下限，如果未配置则返回0.1
+func (a *AdaptiveConfig) GetMinMultiplier() float64 {
+	if a.MinMultiplier <= 0 {
+		return 0.1
+	}
+	return a.MinMultiplier
+}
+
+// GetMaxMultiplier 获取倍数上限，如果未配置则返回1
+func (a *AdaptiveConfig) GetMaxMultiplier() float64 {
+	if a.MaxMultiplier <= 0 {
+		return 1
+	}
+	return a.MaxMultiplier
+}
+
+// GetInterval 获取调整周期，如果未配置则返回5秒
+func (a *AdaptiveConfig) GetInterval() time.Duration {
+	if a.Interval <= 0 {
+		return 5 * time.Second
+	}
+	return a.Interval
+}
+
+// GetMinSamples 获取每个周期至少需要的请求数，如果未配置则返回20
+func (a *AdaptiveConfig) GetMinSamples() int {
+	if a.MinSamples <= 0 {
+		return 20
+	}
+	return a.MinSamples
+}
+
+// GetIncreaseStep 获取每个周期增加的倍数，如果未配置则返回0.05
+func (a *AdaptiveConfig) GetIncreaseStep() float64 {
+	if a.IncreaseStep <= 0 {
+		return 0.05
+	}
+	return a.IncreaseStep
+}
+
+// GetDecreaseFactor 获取aimd过载时的收缩系数，如果未配置则返回0.7
+func (a *AdaptiveConfig) GetDecreaseFactor() float64 {
+	if a.DecreaseFactor <= 0 {
+		return 0.7
+	}
+	return a.DecreaseFactor
+}
+
+// Validate 验证自适应限流配置是否合法
+func (a *AdaptiveConfig) Validate() error {
+	switch a.GetAlgorithm() {
+	case AdaptiveAIMD, AdaptiveGradient:
+	default:
+		return fmt.Errorf("%w: unknown adaptive algorithm %q", ErrInvalidConfig, a.Algorithm)
+	}
+	if a.TargetP99 < 0 || a.Interval < 0 || a.MinSamples < 0 {
+		return fmt.Errorf("%w: negative adaptive setting", ErrInvalidConfig)
+	}
+	if a.MaxErrorRate < 0 || a.MaxErrorRate > 1 {
+		return fmt.Errorf("%w: max_error_rate must be between 0 and 1", ErrInvalidConfig)
+	}
+	if a.MinMultiplier < 0 || a.MaxMultiplier < 0 || a.GetMinMultiplier() > a.GetMaxMultiplier() {
+		return fmt.Errorf("%w: invalid adaptive multiplier range [%v, %v]", ErrInvalidConfig, a.GetMinMultiplier(), a.GetMaxMultiplier())
+	}
+	if a.IncreaseStep < 0 || a.DecreaseFactor < 0 || a.GetDecreaseFactor() >= 1 {
+		return fmt.Errorf("%w: decrease_factor must be between 0 and 1", ErrInvalidConfig)
+	}
+	return nil
 }
 
 // Config 限流器配置
@@ -21,6 +204,163 @@ type Config struct {
 
 	// 是否启用限流，false则限流器不生效
 	Enabled bool `yaml:"enabled"`
+
+	// 存储后端：redis（默认）或 local
+	Backend string `yaml:"backend"`
+
+	// Redis不可用时是否降级为进程内限流
+	// false: 放行请求（默认）；true: 按实例限流，避免Redis故障时完全失去保护
+	LocalFallback bool `yaml:"local_fallback"`
+
+	// 客户端IP解析配置（可信代理等），默认忽略X-Forwarded-For等请求头
+	ClientIP clientip.Config `yaml:"client_ip"`
+
+	// 套餐（如free、pro、enterprise）各自的规则集，key为套餐名
+	// 请求的套餐由中间件的TierFunc解析；未知套餐或未解析出套餐时只使用上面的rules
+	Tiers map[string]TierConfig `yaml:"tiers"`
+
+	// 是否启用Redis中按限流key存储的配额覆盖（见Limiter.SetOverride）
+	EnableOverrides bool `yaml:"enable_overrides"`
+
+	// 配额覆盖在本地的缓存时间，默认10秒；修改覆盖后其它实例最多延迟该时间生效
+	OverrideCacheTTL time.Duration `yaml:"override_cache_ttl"`
+
+	// shadow模式超限记录的保留时间，默认24小时（从最后一次记录开始计算）
+	ShadowTTL time.Duration `yaml:"shadow_ttl"`
+
+	// IP白名单（IP或CIDR），命中的请求不限流，如内部健康检查、合作方
+	// 按客户端IP匹配（见client_ip），与KeyFunc无关
+	Allowlist []string `yaml:"allowlist"`
+
+	// IP黑名单（IP或CIDR），命中的请求直接拒绝（中间件返回403）；同时命中白名单时白名单优先
+	Denylist []string `yaml:"denylist"`
+
+	// 封禁（penalty box）：短时间内被拒绝多次的限流key封禁一段时间
+	PenaltyBox PenaltyBoxConfig `yaml:"penalty_box"`
+
+	// 单次限流检查（包括其中的所有Redis调用）的超时时间，避免限流器耗尽请求的deadline
+	// 超时按Redis不可用处理（降级存储或规则的failure_policy），单独计入指标；0表示不设超时
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+
+	// Redis熔断器：连续失败或变慢时打开，打开期间不再访问Redis，直接降级（local_fallback）或按规则的failure_policy处理
+	CircuitBreaker breaker.Config `yaml:"circuit_breaker"`
+}
+
+// PenaltyBoxConfig 封禁配置
+// 封禁记录存储在Redis中，所有实例共同生效；进程内限流时只在当前实例生效
+type PenaltyBoxConfig struct {
+	// 是否启用封禁检查（包括通过Limiter.Ban手动封禁）
+	Enabled bool `yaml:"enabled"`
+
+	// window内被拒绝threshold次后自动封禁，0表示只支持手动封禁
+	Threshold int `yaml:"threshold"`
+
+	// 统计被拒绝次数的时间窗口，默认1分钟
+	Window time.Duration `yaml:"window"`
+
+	// 自动封禁的时长，默认10分钟
+	BanDuration time.Duration `yaml:"ban_duration"`
+}
+
+// GetWindow 获取统计被拒绝次数的时间窗口，如果未配置则返回1分钟
+func (p *PenaltyBoxConfig) GetWindow() time.Duration {
+	if p.Window <= 0 {
+		return time.Minute
+	}
+	return p.Window
+}
+
+// GetBanDuration 获取自动封禁的时长，如果未配置则返回10分钟
+func (p *PenaltyBoxConfig) GetBanDuration() time.Duration {
+	if p.BanDuration <= 0 {
+		return 10 * time.Minute
+	}
+	return p.BanDuration
+}
+
+// TierConfig 单个套餐的限流规则
+// 套餐的规则优先于Config.Rules匹配；都不匹配时依次使用套餐和全局的default_rule
+type TierConfig struct {
+	Rules       []RuleConfig `yaml:"rules"`
+	DefaultRule *RuleConfig  `yaml:"default_rule,omitempty"`
+}
+
+// GetOverrideCacheTTL 获取配额覆盖的本地缓存时间，如果未配置则返回10秒
+func (c *Config) GetOverrideCacheTTL() time.Duration {
+	if c.OverrideCacheTTL <= 0 {
+		return 10 * time.Second
+	}
+	return c.OverrideCacheTTL
+}
+
+// GetShadowTTL 获取shadow模式超限记录的保留时间，如果未配置则返回24小时
+func (c *Config) GetShadowTTL() time.Duration {
+	if c.ShadowTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return c.ShadowTTL
+}
+
+// GetBackend 获取存储后端，如果未配置则返回redis
+func (c *Config) GetBackend() string {
+	if c.Backend == "" {
+		return BackendRedis
+	}
+	return c.Backend
+}
+
+// Validate 验证限流配置是否合法
+// 热加载时用于保证错误的配置不会替换正在使用的配置
+func (c *Config) Validate() error {
+	for i := range c.Rules {
+		if err := c.Rules[i].Validate(); err != nil {
+			return fmt.Errorf("rule[%d] %q: %w", i, c.Rules[i].Path, err)
+		}
+	}
+	if c.DefaultRule != nil {
+		if err := c.DefaultRule.Validate(); err != nil {
+			return fmt.Errorf("default_rule: %w", err)
+		}
+	}
+	for name, tier := range c.Tiers {
+		if name == "" {
+			return fmt.Errorf("%w: empty tier name", ErrInvalidConfig)
+		}
+		for i := range tier.Rules {
+			if err := tier.Rules[i].Validate(); err != nil {
+				return fmt.Errorf("tier %q rule[%d] %q: %w", name, i, tier.Rules[i].Path, err)
+			}
+		}
+		if tier.DefaultRule != nil {
+			if err := tier.DefaultRule.Validate(); err != nil {
+				return fmt.Errorf("tier %q default_rule: %w", name, err)
+			}
+		}
+	}
+	switch c.GetBackend() {
+	case BackendRedis, BackendLocal:
+	default:
+		return fmt.Errorf("%w: unknown backend %q", ErrInvalidConfig, c.Backend)
+	}
+	if err := c.ClientIP.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if _, err := clientip.ParsePrefixList(c.Allowlist); err != nil {
+		return fmt.Errorf("%w: allowlist: %v", ErrInvalidConfig, err)
+	}
+	if _, err := clientip.ParsePrefixList(c.Denylist); err != nil {
+		return fmt.Errorf("%w: denylist: %v", ErrInvalidConfig, err)
+	}
+	if c.PenaltyBox.Threshold < 0 || c.PenaltyBox.Window < 0 || c.PenaltyBox.BanDuration < 0 {
+		return fmt.Errorf("%w: negative penalty_box setting", ErrInvalidConfig)
+	}
+	if err := c.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("%w: circuit_breaker: %v", ErrInvalidConfig, err)
+	}
+	if c.CheckTimeout < 0 {
+		return fmt.Errorf("%w: negative check_timeout", ErrInvalidConfig)
+	}
+	return nil
 }
 
 // GetBurstSize 获取突发流量大小，如果未配置则返回limit的2倍
@@ -35,16 +375,180 @@ func (r *RuleConfig) GetBurstSize(limit int) int {
 	return 0
 }
 
+// GetAlgorithm 获取限流算法，如果未配置则返回令牌桶
+func (r *RuleConfig) GetAlgorithm() string {
+	if r.Algorithm == "" {
+		return AlgorithmTokenBucket
+	}
+	return r.Algorithm
+}
+
+// GetCost 获取每次请求消耗的配额，如果未配置则返回1
+func (r *RuleConfig) GetCost() int {
+	if r.Cost <= 0 {
+		return 1
+	}
+	return r.Cost
+}
+
+// capacity 窗口一次最多能消耗的配额：令牌桶、GCRA为突发容量，窗口类算法为limit
+func (r *RuleConfig) capacity(w window) int {
+	switch r.GetAlgorithm() {
+	case AlgorithmTokenBucket, AlgorithmGCRA:
+		return r.GetBurstSize(w.limit)
+	default:
+		return w.limit
+	}
+}
+
+// GetScope 获取计数范围，如果未配置则返回per_path
+func (r *RuleConfig) GetScope() string {
+	if r.Scope == "" {
+		return ScopePerPath
+	}
+	return r.Scope
+}
+
+// GetMode 获取执行模式，如果未配置则返回enforce
+func (r *RuleConfig) GetMode() string {
+	if r.Mode == "" {
+		return ModeEnforce
+	}
+	return r.Mode
+}
+
+// GetFailurePolicy 获取Redis不可用时的处理策略，如果未配置则返回open
+func (r *RuleConfig) GetFailurePolicy() string {
+	if r.FailurePolicy == "" {
+		return FailureOpen
+	}
+	return r.FailurePolicy
+}
+
+// failClosed Redis不可用时是否拒绝请求
+func (r *RuleConfig) failClosed() bool {
+	return r.GetFailurePolicy() == FailureClosed && r.GetMode() != ModeShadow
+}
+
+// minLeaseTimeout 并发槽位租约时间的最小值
+const minLeaseTimeout = time.Second
+
+// GetLeaseTimeout 获取并发槽位的租约时间，如果未配置则返回60秒
+func (r *RuleConfig) GetLeaseTimeout() time.Duration {
+	if r.LeaseTimeout <= 0 {
+		return 60 * time.Second
+	}
+	return r.LeaseTimeout
+}
+
+// window 规则的一个限流时间窗口
+type window struct {
+	name   string // Redis key中的窗口标识
+	limit  int
+	period time.Duration
+}
+
+// windows 获取规则配置的所有限流窗口（每秒在前，每分钟在后）
+func (r *RuleConfig) windows() []window {
+	windows := make([]window, 0, 2)
+	if r.LimitPerSecond > 0 {
+		windows = append(windows, window{name: "sec", limit: r.LimitPerSecond, period: time.Second})
+	}
+	if r.LimitPerMinute > 0 {
+		windows = append(windows, window{name: "min", limit: r.LimitPerMinute, period: time.Minute})
+	}
+	return windows
+}
+
+// Policy 获取规则的限额描述，格式同IETF RateLimit-Policy头
+// 例如：每秒10次、每分钟100次 => "10;w=1, 100;w=60"
+func (r *RuleConfig) Policy() string {
+	parts := make([]string, 0, 2)
+	for _, w := range r.windows() {
+		parts = append(parts, fmt.Sprintf("%d;w=%d", w.limit, int(w.period.Seconds())))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // Validate 验证规则配置是否合法
 func (r *RuleConfig) Validate() error {
 	if r.Path == "" {
 		return ErrInvalidConfig
 	}
-	if r.LimitPerSecond < 0 || r.LimitPerMinute < 0 {
+	if r.LimitPerSecond < 0 || r.LimitPerMinute < 0 || r.MaxConcurrent < 0 {
 		return ErrInvalidConfig
 	}
-	if r.LimitPerSecond == 0 && r.LimitPerMinute == 0 {
+	// 只限制并发的规则可以不配置速率限额
+	if r.LimitPerSecond == 0 && r.LimitPerMinute == 0 && r.MaxConcurrent == 0 {
 		return ErrInvalidConfig
 	}
+	if r.LeaseTimeout < 0 {
+		return fmt.Errorf("%w: negative lease_timeout", ErrInvalidConfig)
+	}
+	// 过短的租约来不及续约（YAML中不带单位的整数按纳秒解析，如lease_timeout: 2）
+	if r.LeaseTimeout > 0 && r.LeaseTimeout < minLeaseTimeout {
+		return fmt.Errorf("%w: lease_timeout %v is less than %v", ErrInvalidConfig, r.LeaseTimeout, minLeaseTimeout)
+	}
+	if _, ok := algorithms[r.GetAlgorithm()]; !ok {
+		return ErrInvalidConfig
+	}
+	if r.Cost < 0 {
+		return fmt.Errorf("%w: negative cost", ErrInvalidConfig)
+	}
+	// cost超过容量的请求永远不会被允许
+	for _, w := range r.windows() {
+		if r.GetCost() > r.capacity(w) {
+			return fmt.Errorf("%w: cost %d exceeds capacity %d of %s window", ErrInvalidConfig, r.GetCost(), r.capacity(w), w.name)
+		}
+	}
+	switch r.GetScope() {
+	case ScopePerPath, ScopePerRule, ScopePerRouteTemplate, ScopeGlobal:
+	default:
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidConfig, r.Scope)
+	}
+	// 多个 ** 时每个都要尝试所有后缀，匹配耗时随请求路径深度多项式增长，所以每个规则最多一个
+	catchAlls := 0
+	for _, seg := range splitPath(r.Path) {
+		switch segmentKind(seg) {
+		case segmentCatchAll:
+			if catchAlls++; catchAlls > 1 {
+				return fmt.Errorf("%w: path %q has more than one **", ErrInvalidConfig, r.Path)
+			}
+		case segmentPattern:
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("%w: bad path pattern %q", ErrInvalidConfig, seg)
+			}
+		}
+	}
+	for _, method := range r.Methods {
+		if strings.TrimSpace(method) == "" {
+			return fmt.Errorf("%w: empty method", ErrInvalidConfig)
+		}
+	}
+	for name := range r.Headers {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%w: empty header name", ErrInvalidConfig)
+		}
+	}
+	for name := range r.Query {
+		if name == "" {
+			return fmt.Errorf("%w: empty query name", ErrInvalidConfig)
+		}
+	}
+	switch r.GetMode() {
+	case ModeEnforce, ModeShadow:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidConfig, r.Mode)
+	}
+	switch r.GetFailurePolicy() {
+	case FailureOpen, FailureClosed:
+	default:
+		return fmt.Errorf("%w: unknown failure_policy %q", ErrInvalidConfig, r.FailurePolicy)
+	}
+	if r.Adaptive != nil {
+		if err := r.Adaptive.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
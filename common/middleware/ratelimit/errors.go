@@ -1,14 +1,41 @@
 package ratelimit
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"working-project/common/breaker"
+)
 
 var (
 	// ErrRateLimitExceeded 限流错误
 	ErrRateLimitExceeded = errors.New("rate limit exceeded")
 
+	// ErrConcurrencyLimitExceeded 并发数超限错误
+	ErrConcurrencyLimitExceeded = errors.New("concurrency limit exceeded")
+
+	// ErrBanned 限流key被封禁（见Config.PenaltyBox）
+	// 包装了ErrRateLimitExceeded，按限流处理的调用方不需要额外判断
+	ErrBanned = fmt.Errorf("%w: key banned", ErrRateLimitExceeded)
+
+	// ErrFailClosed Redis不可用且规则的failure_policy为closed，请求被拒绝
+	// 包装了ErrRateLimitExceeded，中间件按限流返回429
+	ErrFailClosed = fmt.Errorf("%w: store unavailable", ErrRateLimitExceeded)
+
+	// ErrDenied 客户端IP在黑名单中
+	ErrDenied = errors.New("request denied")
+
 	// ErrInvalidConfig 配置错误
 	ErrInvalidConfig = errors.New("invalid rate limit config")
 
 	// ErrRedisUnavailable Redis不可用
 	ErrRedisUnavailable = errors.New("redis unavailable")
+
+	// ErrCheckTimeout 限流检查超过了check_timeout
+	// 包装了ErrRedisUnavailable，按Redis不可用处理（降级、fail open/closed）
+	ErrCheckTimeout = fmt.Errorf("%w: check timeout", ErrRedisUnavailable)
+
+	// ErrCircuitOpen 熔断器打开，没有访问Redis
+	// 同时包装了ErrRedisUnavailable和breaker.ErrOpen，按Redis不可用处理（降级、fail open/closed）
+	ErrCircuitOpen = fmt.Errorf("%w: %w", ErrRedisUnavailable, breaker.ErrOpen)
 )
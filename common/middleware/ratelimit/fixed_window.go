@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fixedWindowScript 固定窗口Lua脚本
+// KEYS[1]: 当前窗口的计数key
+// ARGV[1]: 窗口内最大请求数(limit)
+// ARGV[2]: 当前窗口剩余时间(毫秒)
+// ARGV[3]: 本次消耗的配额(cost)
+var fixedWindowScript = redis.NewScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local reset_after = tonumber(ARGV[2])
+	local cost = tonumber(ARGV[3])
+
+	local count = tonumber(redis.call('GET', key) or '0')
+
+	local allowed = 0
+	if count + cost <= limit then
+		count = redis.call('INCRBY', key, cost)
+		if count == cost then
+			redis.call('PEXPIRE', key, reset_after)
+		end
+		allowed = 1
+	end
+
+	return {allowed, limit - count, reset_after}
+`)
+
+// fixedWindow 固定窗口算法
+// 按period对齐切分时间窗口，每个窗口一个计数器
+// 实现最简单，但窗口边界前后可能在短时间内通过2倍limit的请求
+type fixedWindow struct{}
+
+func (fixedWindow) allow(
+	ctx context.Context,
+	rdb redis.Scripter,
+	key string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
+) (bool, int, time.Time, error) {
+	window := period.Milliseconds()
+	nowMs := now.UnixMilli()
+	index := nowMs / window
+
+	allowed, remaining, resetAfter, err := runScript(
+		ctx,
+		rdb,
+		fixedWindowScript,
+		[]string{fmt.Sprintf("%s:fw:%d", key, index)},
+		limit,
+		window-nowMs%window,
+		cost,
+	)
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	return allowed, remaining, now.Add(time.Duration(resetAfter) * time.Millisecond), nil
+}
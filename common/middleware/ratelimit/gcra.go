@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraScript GCRA(Generic Cell Rate Algorithm) Lua脚本
+// 只存储"理论到达时间"(TAT)一个值
+// KEYS[1]: Redis key
+// ARGV[1]: 请求间隔(毫秒) = period / limit
+// ARGV[2]: 突发容量(burst)
+// ARGV[3]: 当前时间戳(毫秒)
+// ARGV[4]: 本次消耗的配额(cost)，TAT前进cost个间隔
+var gcraScript = redis.NewScript(`
+	local key = KEYS[1]
+	local interval = tonumber(ARGV[1])
+	local burst = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+
+	-- 容忍度：允许TAT超前当前时间的最大值
+	local tolerance = interval * burst
+
+	local tat = tonumber(redis.call('GET', key))
+	if tat == nil or tat < now then
+		tat = now
+	end
+
+	-- 以相对当前时间的偏移量计算，避免大时间戳带来的浮点误差（比较时容忍0.001毫秒）
+	local ahead = tat - now + interval * cost
+	if ahead > tolerance + 0.001 then
+		-- 拒绝：不更新TAT
+		return {0, 0, math.ceil(tat - now)}
+	end
+
+	redis.call('SET', key, now + ahead, 'PX', math.ceil(ahead))
+
+	return {1, math.floor((tolerance - ahead) / interval + 0.001), math.ceil(ahead)}
+`)
+
+// gcra 通用信元速率算法
+// 效果等价于令牌桶，但只需存储一个时间戳，请求被均匀地平滑到period内
+type gcra struct{}
+
+func (gcra) allow(
+	ctx context.Context,
+	rdb redis.Scripter,
+	key string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
+) (bool, int, time.Time, error) {
+	if burst <= 0 {
+		burst = 1
+	}
+	interval := float64(period.Microseconds()) / 1000 / float64(limit)
+
+	allowed, remaining, resetAfter, err := runScript(
+		ctx,
+		rdb,
+		gcraScript,
+		[]string{key + ":gcra"},
+		interval,
+		burst,
+		now.UnixMilli(),
+		cost,
+	)
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	return allowed, remaining, now.Add(time.Duration(resetAfter) * time.Millisecond), nil
+}
@@ -2,39 +2,132 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"path/filepath"
-	"strings"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"working-project/common/breaker"
+	"working-project/common/clientip"
 	infraredis "working-project/common/infrastructure/redis"
+	"working-project/common/logging"
 )
 
 // Limiter 分布式限流器
 type Limiter struct {
-	redis  *redis.Client
-	config *Config
+	redis    *redis.Client
+	mu       sync.RWMutex // 保护config、rules、clientIP（支持热加载）和按需创建的concurrency、shadow、bans
+	config   *Config
+	rules    *ruleSet // 由config编译的规则前缀树
+	clientIP *clientip.Resolver
+	store    Store            // 限流状态存储
+	fallback Store            // Redis不可用时的降级存储，为nil则按规则的failure_policy处理
+	breaker  *breaker.Breaker // Redis熔断器，为nil则不熔断
+
+	concurrency         concurrencyStore // 并发槽位存储
+	concurrencyFallback concurrencyStore // Redis不可用时的并发槽位降级存储
+	shadow              shadowRecorder   // shadow模式的超限记录
+	bans                banStore         // 封禁记录
+
+	overrides overrideCache    // 配额覆盖的本地缓存
+	adaptive  adaptiveRegistry // 自适应规则的状态
+	metrics   *Metrics         // Prometheus指标，为nil则不记录
+	logger    logging.Logger   // 结构化日志，为nil则使用slog.Default()
+
+	tracerProvider trace.TracerProvider // OpenTelemetry，为nil则使用全局provider
+
+	preloader *scriptPreloader // Lua脚本预加载，Close时释放
+}
+
+// Option 限流器选项
+type Option func(*Limiter)
+
+// WithStore 使用自定义存储后端
+func WithStore(store Store) Option {
+	return func(l *Limiter) {
+		l.store = store
+	}
+}
+
+// WithFallbackStore 设置Redis不可用时的降级存储
+func WithFallbackStore(store Store) Option {
+	return func(l *Limiter) {
+		l.fallback = store
+	}
+}
+
+// WithLogger 设置结构化日志，记录拒绝、放行的错误、封禁等事件
+func WithLogger(logger logging.Logger) Option {
+	return func(l *Limiter) {
+		l.logger = logger
+	}
 }
 
 // NewLimiter 创建限流器
-func NewLimiter(redisClient *redis.Client, config *Config) *Limiter {
-	return &Limiter{
-		redis:  redisClient,
-		config: config,
+// 默认存储后端：redisClient不为nil时使用Redis，否则使用进程内存储
+// 如果config.LocalFallback为true，Redis不可用时降级为进程内限流
+func NewLimiter(redisClient *redis.Client, config *Config, opts ...Option) *Limiter {
+	l := &Limiter{
+		redis:    redisClient,
+		config:   config,
+		rules:    newRuleSet(config),
+		clientIP: clientip.NewResolver(&config.ClientIP),
+	}
+
+	if redisClient != nil && config.GetBackend() != BackendLocal {
+		l.store = NewRedisStore(redisClient)
+		l.concurrency = &redisConcurrencyStore{redis: redisClient}
+		l.shadow = &redisShadowRecorder{redis: redisClient}
+		l.bans = &redisBanStore{redis: redisClient}
+		if config.LocalFallback {
+			l.fallback = NewLocalStore(0)
+			l.concurrencyFallback = newLocalConcurrencyStore()
+		}
+	} else {
+		l.store = NewLocalStore(0)
+		l.concurrency = newLocalConcurrencyStore()
+		l.shadow = newLocalShadowRecorder()
+		l.bans = newLocalBanStore()
+	}
+
+	for _, opt := range opts {
+		opt(l)
 	}
+
+	// 在存储实际使用的客户端上预加载Lua脚本，客户端重连后也会重新加载
+	if store, ok := l.store.(*RedisStore); ok {
+		l.preloader = preloadScripts(store.Client(), l.logger)
+	}
+
+	l.breaker = breaker.New(config.CircuitBreaker, breaker.WithStateChange(func(from, to breaker.State) {
+		l.log().WarnContext(context.Background(), "ratelimit: circuit breaker state changed",
+			slog.String("from", from.String()), slog.String("to", to.String()))
+	}))
+
+	return l
 }
 
 // NewLimiterFromManager 从Redis Manager创建限流器（推荐使用）
 // 参数：
 //   - manager: Redis管理器
 //   - config: 限流配置（config.RedisName指定要使用的Redis连接）
-func NewLimiterFromManager(manager *infraredis.Manager, config *Config) (*Limiter, error) {
+func NewLimiterFromManager(manager *infraredis.Manager, config *Config, opts ...Option) (*Limiter, error) {
 	if config == nil {
 		return nil, ErrInvalidConfig
 	}
 
+	// 进程内限流不需要Redis连接
+	if config.GetBackend() == BackendLocal {
+		return NewLimiter(nil, config, opts...), nil
+	}
+
 	// 确定Redis连接名称
 	redisName := config.RedisName
 	if redisName == "" {
@@ -47,7 +140,127 @@ func NewLimiterFromManager(manager *infraredis.Manager, config *Config) (*Limite
 		return nil, fmt.Errorf("failed to get redis client '%s': %w", redisName, err)
 	}
 
-	return NewLimiter(redisClient, config), nil
+	return NewLimiter(redisClient, config, opts...), nil
+}
+
+// UpdateConfig 热更新限流规则
+// 新配置校验失败时返回错误，继续使用旧配置
+// 注意：
+//   - 调用后不要再修改config，Limiter会直接持有该指针
+//   - redis_name、backend、local_fallback只在创建时生效，修改需要重启
+func (l *Limiter) UpdateConfig(config *Config) error {
+	if config == nil {
+		return ErrInvalidConfig
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	rules := newRuleSet(config)
+
+	l.mu.Lock()
+	l.config = config
+	l.rules = rules
+	l.clientIP = clientip.NewResolver(&config.ClientIP)
+	l.mu.Unlock()
+
+	l.breaker.SetConfig(config.CircuitBreaker)
+	return nil
+}
+
+// getConfig 获取当前生效的配置
+func (l *Limiter) getConfig() *Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.config
+}
+
+// snapshot 获取当前生效的配置及编译后的规则
+func (l *Limiter) snapshot() (*Config, *ruleSet) {
+	l.mu.RLock()
+	config, rules := l.config, l.rules
+	l.mu.RUnlock()
+
+	// 未通过NewLimiter创建时按需编译
+	if rules == nil {
+		rules = newRuleSet(config)
+	}
+	return config, rules
+}
+
+// ClientIP 按配置的可信代理解析请求的客户端IP
+// 自定义KeyFunc时可以用它作为未登录用户的回退Key
+func (l *Limiter) ClientIP(req *http.Request) string {
+	l.mu.RLock()
+	resolver := l.clientIP
+	l.mu.RUnlock()
+
+	if resolver == nil {
+		resolver = clientip.NewResolver(nil)
+	}
+	return resolver.ClientIP(req)
+}
+
+// log 获取结构化日志，未设置时使用slog.Default()
+func (l *Limiter) log() logging.Logger {
+	return logging.OrDefault(l.logger)
+}
+
+// Request 限流检查的请求信息
+type Request struct {
+	Key  string // 限流key，通常是IP地址
+	Path string // 请求路径
+
+	// 本次请求消耗的配额，<=0时使用规则的cost（默认1）
+	Cost int
+
+	// 套餐名（见Config.Tiers），为空或未知套餐时使用全局规则
+	Tier string
+
+	// 客户端IP，用于匹配IP黑白名单；为空时把Key当作IP
+	IP string
+
+	// 路由模板，如 gin 的 c.FullPath()（/users/:id），用于per_route_template计数范围
+	// 为空时按请求路径计数
+	Route string
+
+	// 以下字段用于匹配规则的附加条件（methods、host、headers、query），可以为空
+	Method string      // HTTP方法
+	Host   string      // 请求域名，可以带端口
+	Header http.Header // 请求头
+	Query  url.Values  // 查询参数
+}
+
+// Result 限流检查结果
+type Result struct {
+	Allowed   bool          // 是否允许通过
+	Limit     int           // 生效窗口的限额，0表示未限流
+	Remaining int           // 生效窗口的剩余配额，-1表示未限流
+	ResetTime time.Time     // 生效窗口的配额恢复时间
+	Window    time.Duration // 生效窗口的周期
+	Policy    string        // 规则的全部限额，格式同RateLimit-Policy头，如 "10;w=1, 100;w=60"
+	Rule      *RuleConfig   // 匹配到的规则（按覆盖、自适应倍数放大后），nil表示未限流
+
+	// ShadowRejected 规则处于shadow模式且本应被拒绝，此时Allowed仍为true
+	ShadowRejected bool
+
+	// Banned 限流key处于封禁中，ResetTime为封禁到期时间
+	Banned bool
+
+	adaptive *adaptiveController // 自适应规则的状态，用于Observe
+}
+
+// RetryAfter 距离配额恢复的秒数（向上取整），用于Retry-After等响应头
+func (r *Result) RetryAfter(now time.Time) int {
+	if r.ResetTime.IsZero() || !r.ResetTime.After(now) {
+		return 0
+	}
+	d := r.ResetTime.Sub(now)
+	seconds := int(d / time.Second)
+	if d%time.Second != 0 {
+		seconds++
+	}
+	return seconds
 }
 
 // Allow 检查请求是否允许通过
@@ -55,187 +268,301 @@ func NewLimiterFromManager(manager *infraredis.Manager, config *Config) (*Limite
 // path: 请求路径
 // 返回: allowed(是否允许), remaining(剩余配额), resetTime(配额重置时间), error
 func (l *Limiter) Allow(ctx context.Context, key, path string) (bool, int, time.Time, error) {
-	if !l.config.Enabled {
-		return true, -1, time.Time{}, nil
+	return l.AllowN(ctx, key, path, 0)
+}
+
+// AllowN 检查请求是否允许通过，并消耗n份配额（如导出、批量查询等开销较大的请求）
+// n<=0时使用规则配置的cost（默认1）
+// 返回值同Allow
+func (l *Limiter) AllowN(ctx context.Context, key, path string, n int) (bool, int, time.Time, error) {
+	result, err := l.Check(ctx, &Request{Key: key, Path: path, Cost: n})
+	if result == nil {
+		return false, 0, time.Time{}, err
+	}
+	return result.Allowed, result.Remaining, result.ResetTime, err
+}
+
+// Check 检查请求是否允许通过，返回完整的限流结果
+// 被限流时同时返回结果和ErrRateLimitExceeded；其他错误时结果为nil
+// shadow模式的规则超限时返回Allowed=true、ShadowRejected=true，不返回错误
+// 客户端IP在黑名单中时返回ErrDenied；限流key被封禁时返回Banned=true和ErrBanned
+func (l *Limiter) Check(ctx context.Context, req *Request) (*Result, error) {
+	ctx, span := l.startSpan(ctx, "ratelimit.Check",
+		attribute.String(attrPath, req.Path), attribute.String(attrTier, req.Tier))
+	result, err := l.check(ctx, req)
+	l.metrics.observeDecision(result, err)
+	endCheckSpan(span, result, err)
+	if err != nil && !errors.Is(err, ErrRateLimitExceeded) && !errors.Is(err, ErrDenied) {
+		return nil, err
+	}
+	return result, err
+}
+
+// check 执行限流检查，见Check
+// 出错时也返回带有匹配规则的结果，用于按规则统计指标
+func (l *Limiter) check(ctx context.Context, req *Request) (*Result, error) {
+	// 整个检查过程使用同一份配置快照，避免热加载时前后不一致
+	config, rules := l.snapshot()
+	if !config.Enabled {
+		return &Result{Allowed: true, Remaining: -1}, nil
+	}
+
+	// check_timeout限制整个检查过程中所有Redis调用的耗时
+	ctx, cancel := withCheckTimeout(ctx, config)
+	defer cancel()
+
+	// IP黑白名单，白名单优先
+	ip := req.clientIP()
+	if rules.allow.Contains(ip) {
+		return &Result{Allowed: true, Remaining: -1}, nil
+	}
+	if rules.deny.Contains(ip) {
+		return &Result{Allowed: false, Remaining: -1}, ErrDenied
+	}
+
+	now := time.Now()
+	if config.PenaltyBox.Enabled {
+		if expireAt, banned := l.checkBan(ctx, req.Key, now); banned {
+			return &Result{Allowed: false, Remaining: 0, ResetTime: expireAt, Banned: true}, ErrBanned
+		}
 	}
 
-	// 查找匹配的规则
-	rule := l.findMatchingRule(path)
+	// Redis中的配额覆盖可以指定套餐、放大限额
+	tier := req.Tier
+	override := l.lookupOverride(ctx, config, req.Key)
+	if override != nil && override.Tier != "" {
+		tier = override.Tier
+	}
+
+	// 查找匹配的规则（包括默认规则）
+	rule, ruleTier := rules.match(config, req, tier)
 	if rule == nil {
 		// 如果没有匹配的规则且没有默认规则，则允许通过
-		if l.config.DefaultRule == nil {
-			return true, -1, time.Time{}, nil
-		}
-		rule = l.config.DefaultRule
+		return &Result{Allowed: true, Remaining: -1}, nil
 	}
 
 	// 验证规则
 	if err := rule.Validate(); err != nil {
-		return false, 0, time.Time{}, err
+		return &Result{Rule: rule}, err
 	}
 
-	// 分别检查每秒和每分钟的限制
-	var allowed = true
-	var remaining = -1
-	var resetTime time.Time
+	// 自适应规则按观测到的延迟和错误率收缩/放大限额
+	var adaptive *adaptiveController
+	if rule.Adaptive != nil {
+		adaptive = l.adaptive.get(ruleTier, rule)
+		rule = rule.scaled(adaptive.current())
+	}
+	// global范围的配额由所有客户端共享，不按单个key放大
+	if override != nil && override.Multiplier > 0 && rule.GetScope() != ScopeGlobal {
+		rule = rule.scaled(override.Multiplier)
+	}
 
-	// 检查每秒限制
-	if rule.LimitPerSecond > 0 {
-		keyPerSec := fmt.Sprintf("ratelimit:sec:%s:%s", key, path)
-		allowedSec, remainingSec, resetSec, err := l.allowWithTokenBucket(
+	algorithm := rule.GetAlgorithm()
+	cost := req.Cost
+	if cost <= 0 {
+		cost = rule.GetCost()
+	}
+	result := &Result{
+		Allowed:   true,
+		Remaining: -1,
+		Policy:    rule.Policy(),
+		Rule:      rule,
+		adaptive:  adaptive,
+	}
+
+	// 依次检查每秒和每分钟的限制，任一窗口超限即拒绝
+	// 返回第一个窗口（优先每秒）的剩余配额
+	subject := counterSubject(rule, ruleTier, req)
+	for i, w := range rule.windows() {
+		key := fmt.Sprintf("ratelimit:%s:%s", w.name, subject)
+		allowed, remaining, resetTime, err := l.allowWithStore(
 			ctx,
-			keyPerSec,
-			rule.LimitPerSecond,
-			rule.GetBurstSize(rule.LimitPerSecond),
-			time.Second,
+			key,
+			algorithm,
+			w.limit,
+			rule.GetBurstSize(w.limit),
+			cost,
+			w.period,
+			now,
 		)
 		if err != nil {
-			return false, 0, time.Time{}, err
+			// Redis不可用且没有降级存储，failure_policy为closed的规则拒绝请求
+			if errors.Is(err, ErrRedisUnavailable) && rule.failClosed() {
+				return l.failClosedResult(result, now), ErrFailClosed
+			}
+			return result, err
 		}
-		if !allowedSec {
-			return false, remainingSec, resetSec, ErrRateLimitExceeded
+		// shadow模式已经被拒绝时，结果保留第一个拒绝的窗口
+		if i == 0 || (!allowed && !result.ShadowRejected) {
+			result.Limit = w.limit
+			result.Remaining = remaining
+			result.ResetTime = resetTime
+			result.Window = w.period
+		}
+		if !allowed {
+			// shadow模式只记录一次，不拒绝，并继续检查其余窗口，使计数与enforce模式一致
+			if rule.GetMode() == ModeShadow {
+				if !result.ShadowRejected {
+					result.ShadowRejected = true
+					l.recordShadow(ctx, config, ruleTier, rule, req.Key)
+				}
+				continue
+			}
+			result.Allowed = false
+			l.penalize(ctx, config, req.Key, now)
+			return result, ErrRateLimitExceeded
 		}
-		remaining = remainingSec
-		resetTime = resetSec
 	}
 
-	// 检查每分钟限制
-	if rule.LimitPerMinute > 0 {
-		keyPerMin := fmt.Sprintf("ratelimit:min:%s:%s", key, path)
-		allowedMin, remainingMin, resetMin, err := l.allowWithTokenBucket(
-			ctx,
-			keyPerMin,
-			rule.LimitPerMinute,
-			rule.GetBurstSize(rule.LimitPerMinute),
-			time.Minute,
-		)
-		if err != nil {
-			return false, 0, time.Time{}, err
-		}
-		if !allowedMin {
-			return false, remainingMin, resetMin, ErrRateLimitExceeded
+	return result, nil
+}
+
+// clientIP 用于匹配黑白名单的客户端IP
+func (r *Request) clientIP() string {
+	if r.IP != "" {
+		return r.IP
+	}
+	return r.Key
+}
+
+// counterSubject 按规则的计数范围生成限流计数key（不含窗口前缀）
+//   - per_path:           <key>:<path>
+//   - per_rule:           <key>:rule:<rule path>
+//   - per_route_template: <key>:route:<route>，没有路由模板时同per_path
+//   - global:             global:rule:<rule path>
+//
+// 套餐规则的key在范围前追加套餐（per_path等为<key>:tier:<tier>:...，global为global:<tier>:rule:...），
+// key切换套餐（包括配额覆盖指定套餐）后按新套餐的限额重新计数，不沿用旧套餐的计数；
+// 配额覆盖的multiplier只放大限额，计数不变
+// 同一路径上按方法、请求头等条件区分的规则各自计数（追加@<条件标识>）
+// tier为规则所属的套餐，全局规则为空字符串
+func counterSubject(rule *RuleConfig, tier string, req *Request) string {
+	suffix := ""
+	if id := rule.conditionsID(); id != "" {
+		suffix = "@" + id
+	}
+
+	if rule.GetScope() == ScopeGlobal {
+		if tier != "" {
+			return "global:" + tier + ":rule:" + rule.Path + suffix
 		}
-		// 如果只配置了每分钟限制，则返回每分钟的剩余配额
-		if rule.LimitPerSecond == 0 {
-			remaining = remainingMin
-			resetTime = resetMin
+		return "global:rule:" + rule.Path + suffix
+	}
+
+	prefix := req.Key + ":"
+	if tier != "" {
+		prefix += "tier:" + tier + ":"
+	}
+	switch rule.GetScope() {
+	case ScopePerRule:
+		return prefix + "rule:" + rule.Path + suffix
+	case ScopePerRouteTemplate:
+		if req.Route != "" {
+			return prefix + "route:" + req.Route + suffix
 		}
 	}
+	return prefix + req.Path + suffix
+}
 
-	return allowed, remaining, resetTime, nil
+// failClosedResult 填充按failure_policy=closed拒绝时的结果
+// 熔断器打开时建议客户端在熔断器进入半开后重试，否则1秒后重试
+func (l *Limiter) failClosedResult(result *Result, now time.Time) *Result {
+	retryAfter := l.breaker.RetryAfter()
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	result.Allowed = false
+	result.Remaining = 0
+	result.ResetTime = now.Add(retryAfter)
+	return result
 }
 
-// allowWithTokenBucket 使用令牌桶算法检查是否允许请求
-// 使用Redis + Lua脚本实现分布式令牌桶
-func (l *Limiter) allowWithTokenBucket(
+// allowWithStore 通过存储后端执行限流检查
+// 主存储返回ErrRedisUnavailable（包括熔断器打开）且配置了降级存储时，改用降级存储
+func (l *Limiter) allowWithStore(
 	ctx context.Context,
 	key string,
-	rate int, // 令牌产生速率（每period产生rate个令牌）
-	burst int, // 桶容量
-	period time.Duration, // 时间周期
+	algorithm string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
 ) (bool, int, time.Time, error) {
-	now := time.Now()
+	ctx, span := l.startAllowSpan(ctx, algorithm, limit, cost, period)
 
-	// Lua脚本：原子性地实现令牌桶算法
-	// KEYS[1]: Redis key
-	// ARGV[1]: 桶容量(burst)
-	// ARGV[2]: 令牌产生速率(rate)
-	// ARGV[3]: 时间周期(秒)
-	// ARGV[4]: 当前时间戳(秒)
-	// ARGV[5]: 过期时间(秒)
-	script := redis.NewScript(`
-		local key = KEYS[1]
-		local burst = tonumber(ARGV[1])
-		local rate = tonumber(ARGV[2])
-		local period = tonumber(ARGV[3])
-		local now = tonumber(ARGV[4])
-		local expire_time = tonumber(ARGV[5])
-
-		-- 获取当前令牌数和上次更新时间
-		local token_info = redis.call('HMGET', key, 'tokens', 'last_time')
-		local tokens = tonumber(token_info[1])
-		local last_time = tonumber(token_info[2])
-
-		-- 如果是首次访问，初始化令牌桶
-		if tokens == nil then
-			tokens = burst
-			last_time = now
-		end
-
-		-- 计算应该补充的令牌数
-		local elapsed = now - last_time
-		local new_tokens = tokens + (elapsed / period) * rate
-
-		-- 令牌数不能超过桶容量
-		if new_tokens > burst then
-			new_tokens = burst
-		end
-
-		-- 尝试消费1个令牌
-		local allowed = 0
-		if new_tokens >= 1 then
-			new_tokens = new_tokens - 1
-			allowed = 1
-		end
-
-		-- 更新Redis中的数据
-		redis.call('HMSET', key, 'tokens', new_tokens, 'last_time', now)
-		redis.call('EXPIRE', key, expire_time)
-
-		-- 返回: 是否允许(1/0), 剩余令牌数, 下次重置时间
-		local reset_after = 0
-		if new_tokens < burst then
-			reset_after = math.ceil((burst - new_tokens) / rate * period)
-		end
-
-		return {allowed, math.floor(new_tokens), reset_after}
-	`)
-
-	// 执行Lua脚本
-	expireTime := int(period.Seconds() * 2) // 过期时间设置为周期的2倍
-	result, err := script.Run(
-		ctx,
-		l.redis,
-		[]string{key},
-		burst,
-		rate,
-		period.Seconds(),
-		now.Unix(),
-		expireTime,
-	).Result()
+	var allowed bool
+	var remaining int
+	var resetTime time.Time
+	err := l.guardRedis(ctx, func() error {
+		var err error
+		start := time.Now()
+		allowed, remaining, resetTime, err = l.store.Allow(ctx, key, algorithm, limit, burst, cost, period, now)
+		if _, ok := l.store.(*RedisStore); ok {
+			l.metrics.observeScript(algorithm, time.Since(start))
+		}
+		return err
+	})
+	fallback := false
+	if err != nil && errors.Is(err, ErrRedisUnavailable) && l.fallback != nil {
+		span.RecordError(err)
+		fallback = true
+		allowed, remaining, resetTime, err = l.fallback.Allow(ctx, key, algorithm, limit, burst, cost, period, now)
+	}
+	endAllowSpan(span, allowed, remaining, fallback, err)
+	return allowed, remaining, resetTime, err
+}
 
+// guardRedis 通过熔断器执行一次存储调用
+// 熔断器打开时不执行fn，直接返回ErrCircuitOpen；只有ErrRedisUnavailable计为失败
+// ctx超时（check_timeout或请求本身的deadline）导致的失败返回ErrCheckTimeout
+func (l *Limiter) guardRedis(ctx context.Context, fn func() error) error {
+	done, err := l.breaker.Allow()
 	if err != nil {
-		return false, 0, time.Time{}, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+		return ErrCircuitOpen
+	}
+	err = fn()
+	if !errors.Is(err, ErrRedisUnavailable) {
+		done(nil)
+		return err
 	}
 
-	// 解析结果
-	resultSlice, ok := result.([]interface{})
-	if !ok || len(resultSlice) != 3 {
-		return false, 0, time.Time{}, fmt.Errorf("invalid lua script result")
+	if deadlineExceeded(ctx) {
+		err = fmt.Errorf("%w: %v", ErrCheckTimeout, err)
 	}
+	l.metrics.observeRedisError(err)
+	done(err)
+	return err
+}
 
-	allowed := resultSlice[0].(int64) == 1
-	remaining := int(resultSlice[1].(int64))
-	resetAfter := int(resultSlice[2].(int64))
-	resetTime := now.Add(time.Duration(resetAfter) * time.Second)
+// deadlineExceeded context是否已到deadline
+// go-redis按deadline设置连接的读写超时，读超时返回时context的定时器可能还没触发，
+// 只看ctx.Err()会把这类超时误判为普通错误
+func deadlineExceeded(ctx context.Context) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
 
-	return allowed, remaining, resetTime, nil
+// withCheckTimeout 按配置的check_timeout为限流检查设置超时，未配置时返回原context
+func withCheckTimeout(ctx context.Context, config *Config) (context.Context, context.CancelFunc) {
+	if config.CheckTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, config.CheckTimeout)
 }
 
-// findMatchingRule 查找匹配的限流规则
-// 支持通配符匹配，如 "/api/*", "/admin/user/*"
+// findMatchingRule 查找匹配的限流规则（只按路径匹配）
+// 支持通配符匹配，如 "/api/*", "/admin/**", "/users/:id"
 func (l *Limiter) findMatchingRule(path string) *RuleConfig {
-	for i := range l.config.Rules {
-		rule := &l.config.Rules[i]
-		if l.pathMatch(rule.Path, path) {
-			return rule
-		}
-	}
-	return nil
+	_, rules := l.snapshot()
+	return rules.base.match(&Request{Path: path})
 }
 
 // pathMatch 路径匹配，支持通配符
-// pattern: 规则路径，支持 * 通配符
+// pattern: 规则路径，支持 *、:name（单个路径段）和 **（零个或多个路径段）
 // path: 实际请求路径
 func (l *Limiter) pathMatch(pattern, path string) bool {
 	// 精确匹配
@@ -243,17 +570,15 @@ func (l *Limiter) pathMatch(pattern, path string) bool {
 		return true
 	}
 
-	// 通配符匹配
-	if strings.Contains(pattern, "*") {
-		matched, _ := filepath.Match(pattern, path)
-		return matched
-	}
-
-	return false
+	return segmentsMatch(splitPath(pattern), splitPath(path))
 }
 
 // Close 关闭限流器，释放Redis连接
 func (l *Limiter) Close() error {
+	if l.preloader != nil {
+		l.preloader.release()
+		l.preloader = nil
+	}
 	if l.redis != nil {
 		return l.redis.Close()
 	}
@@ -3,12 +3,16 @@ package ratelimit
 import (
 	"context"
 	"errors"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cast"
+
+	"working-project/common/breaker"
 )
 
 // setupTestRedis 创建测试用的Redis实例（使用miniredis模拟）
@@ -88,6 +92,15 @@ func TestRuleConfig_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid rule with unknown scope",
+			rule: RuleConfig{
+				Path:           "/api/*",
+				LimitPerSecond: 10,
+				Scope:          "per_user",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -542,3 +555,335 @@ func TestLimiter_Allow_NoMatchingRule(t *testing.T) {
 		}
 	}
 }
+
+// TestLimiter_Check_Scope 测试不同计数范围下配额的共享方式
+func TestLimiter_Check_Scope(t *testing.T) {
+	t.Parallel()
+
+	// 每个请求: key, path, route
+	type request struct{ key, path, route string }
+
+	tests := []struct {
+		name     string
+		scope    string
+		requests []request
+		want     []bool
+	}{
+		{
+			name:  "per path gives each path its own bucket",
+			scope: ScopePerPath,
+			requests: []request{
+				{"ip1", "/api/users/1", "/api/users/:id"},
+				{"ip1", "/api/users/2", "/api/users/:id"},
+				{"ip1", "/api/users/1", "/api/users/:id"},
+			},
+			want: []bool{true, true, false},
+		},
+		{
+			name:  "per rule shares one bucket across paths",
+			scope: ScopePerRule,
+			requests: []request{
+				{"ip1", "/api/users/1", ""},
+				{"ip1", "/api/orders", ""},
+				{"ip2", "/api/orders", ""},
+			},
+			want: []bool{true, false, true},
+		},
+		{
+			name:  "per route template shares bucket for same template",
+			scope: ScopePerRouteTemplate,
+			requests: []request{
+				{"ip1", "/api/users/1", "/api/users/:id"},
+				{"ip1", "/api/users/2", "/api/users/:id"},
+				{"ip1", "/api/orders", "/api/orders"},
+			},
+			want: []bool{true, false, true},
+		},
+		{
+			name:  "per route template falls back to path",
+			scope: ScopePerRouteTemplate,
+			requests: []request{
+				{"ip1", "/api/users/1", ""},
+				{"ip1", "/api/users/2", ""},
+			},
+			want: []bool{true, true},
+		},
+		{
+			name:  "global shares bucket across clients",
+			scope: ScopeGlobal,
+			requests: []request{
+				{"ip1", "/api/users/1", ""},
+				{"ip2", "/api/orders", ""},
+			},
+			want: []bool{true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Enabled: true,
+				Backend: BackendLocal,
+				Rules: []RuleConfig{
+					{Path: "/api/**", LimitPerMinute: 1, BurstSize: 1, Scope: tt.scope},
+				},
+			}
+			limiter := NewLimiter(nil, config)
+
+			for i, r := range tt.requests {
+				result, err := limiter.Check(context.Background(), &Request{Key: r.key, Path: r.path, Route: r.route})
+				if err != nil && !errors.Is(err, ErrRateLimitExceeded) {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if cast.ToBool(result.Allowed) != tt.want[i] {
+					t.Errorf("request %d (%s %s): expected allowed=%v, got %v", i+1, r.key, r.path, tt.want[i], result.Allowed)
+				}
+			}
+		})
+	}
+}
+
+// TestLimiter_AllowN 测试按规则cost和AllowN消耗配额
+func TestLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled: true,
+		Rules: []RuleConfig{
+			{Path: "/api/export", LimitPerMinute: 10, BurstSize: 10, Cost: 5},
+			{Path: "/api/search", LimitPerMinute: 10, BurstSize: 10},
+		},
+	}
+	limiter := NewLimiter(rdb, config)
+	ctx := context.Background()
+
+	// 规则cost=5：两次用完配额
+	for i := 0; i < 2; i++ {
+		if allowed, _, _, err := limiter.Allow(ctx, "ip", "/api/export"); err != nil || !allowed {
+			t.Fatalf("export %d: expected allowed, got %v %v", i+1, allowed, err)
+		}
+	}
+	if _, _, _, err := limiter.Allow(ctx, "ip", "/api/export"); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected third export to be limited, got %v", err)
+	}
+
+	// AllowN覆盖规则的cost
+	allowed, remaining, _, err := limiter.AllowN(ctx, "ip", "/api/search", 7)
+	if err != nil || !cast.ToBool(allowed) {
+		t.Fatalf("expected AllowN(7) to be allowed, got %v %v", allowed, err)
+	}
+	if cast.ToInt(remaining) != 3 {
+		t.Errorf("expected remaining 3, got %d", remaining)
+	}
+	if _, _, _, err := limiter.AllowN(ctx, "ip", "/api/search", 4); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected AllowN(4) to be limited, got %v", err)
+	}
+}
+
+// TestRuleConfig_Validate_Cost 测试cost超过容量的规则配置
+func TestRuleConfig_Validate_Cost(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    RuleConfig
+		wantErr bool
+	}{
+		{
+			name: "cost within burst",
+			rule: RuleConfig{Path: "/api/*", LimitPerSecond: 2, BurstSize: 5, Cost: 5},
+		},
+		{
+			name:    "cost exceeds burst",
+			rule:    RuleConfig{Path: "/api/*", LimitPerSecond: 2, BurstSize: 5, Cost: 6},
+			wantErr: true,
+		},
+		{
+			name:    "cost exceeds window limit",
+			rule:    RuleConfig{Path: "/api/*", LimitPerMinute: 3, Cost: 4, Algorithm: AlgorithmFixedWindow},
+			wantErr: true,
+		},
+		{
+			name:    "negative cost",
+			rule:    RuleConfig{Path: "/api/*", LimitPerSecond: 2, Cost: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if cast.ToBool(err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestLimiter_CircuitBreaker 测试Redis连续失败后熔断，以及failure_policy
+func TestLimiter_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	_ = rdb.Close()
+	config := &Config{
+		Enabled:        true,
+		CircuitBreaker: breaker.Config{Enabled: true, FailureThreshold: 2, OpenTimeout: time.Minute},
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 10, BurstSize: 10},
+			{Path: "/login", LimitPerMinute: 10, BurstSize: 10, FailurePolicy: FailureClosed},
+		},
+	}
+	limiter := NewLimiter(rdb, config)
+	ctx := context.Background()
+
+	// 前两次访问Redis失败
+	for i := 0; i < 2; i++ {
+		_, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/api/users"})
+		if !errors.Is(err, ErrRedisUnavailable) || errors.Is(err, breaker.ErrOpen) {
+			t.Fatalf("expected Redis error, got %v", err)
+		}
+	}
+
+	// 熔断后不再访问Redis
+	_, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/api/users"})
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrRedisUnavailable) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	// failure_policy为closed的规则拒绝请求
+	result, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/login"})
+	if !errors.Is(err, ErrFailClosed) || !errors.Is(err, ErrRateLimitExceeded) {
+		t.Fatalf("expected ErrFailClosed, got %v", err)
+	}
+	if cast.ToBool(result.Allowed) {
+		t.Errorf("expected fail closed result to be rejected")
+	}
+	if retryAfter := result.RetryAfter(time.Now()); retryAfter < 59 || retryAfter > 60 {
+		t.Errorf("expected retry after the breaker open timeout, got %d", retryAfter)
+	}
+
+	// 热加载关闭熔断器后恢复访问Redis
+	config2 := *config
+	config2.CircuitBreaker = breaker.Config{}
+	if err := limiter.UpdateConfig(&config2); err != nil {
+		t.Fatalf("expected no error on UpdateConfig, got %v", err)
+	}
+	if _, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/api/users"}); errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("expected breaker to be reset, got %v", err)
+	}
+}
+
+// TestLimiter_CircuitBreaker_Fallback 测试熔断期间直接使用降级存储
+func TestLimiter_CircuitBreaker_Fallback(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	_ = rdb.Close()
+	config := &Config{
+		Enabled:        true,
+		LocalFallback:  true,
+		CircuitBreaker: breaker.Config{Enabled: true, FailureThreshold: 1, OpenTimeout: time.Minute},
+		Rules: []RuleConfig{
+			{Path: "/login", LimitPerMinute: 3, BurstSize: 3, FailurePolicy: FailureClosed},
+		},
+	}
+	limiter := NewLimiter(rdb, config)
+
+	// 有降级存储时不按failure_policy拒绝，而是按实例限流
+	if got := countAllowed(t, limiter, &Request{Key: "user:1", Path: "/login"}, 5); got != 3 {
+		t.Errorf("expected 3 allowed requests from the fallback store, got %d", got)
+	}
+	if !limiter.breaker.Open() {
+		t.Errorf("expected breaker to be open")
+	}
+}
+
+// TestRuleConfig_Validate_FailurePolicy 测试failure_policy校验
+func TestRuleConfig_Validate_FailurePolicy(t *testing.T) {
+	rule := RuleConfig{Path: "/api", LimitPerMinute: 1, FailurePolicy: "maybe"}
+	if err := rule.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+	rule.FailurePolicy = FailureClosed
+	if err := rule.Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	config := &Config{CircuitBreaker: breaker.Config{FailureThreshold: -1}}
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for negative failure_threshold, got %v", err)
+	}
+}
+
+// setupBlackholeRedis 创建接受连接但从不响应的Redis客户端，用于模拟Redis变慢
+func setupBlackholeRedis(t *testing.T) *redis.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error on net.Listen, got %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:                  listener.Addr().String(),
+		ReadTimeout:           5 * time.Second,
+		ContextTimeoutEnabled: true,
+		MaxRetries:            -1,
+	})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+// TestLimiter_CheckTimeout 测试check_timeout限制限流检查的耗时，超时按failure_policy处理并单独计数
+func TestLimiter_CheckTimeout(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewMetrics(nil)
+	config := &Config{
+		Enabled:      true,
+		CheckTimeout: 50 * time.Millisecond,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 10, BurstSize: 10},
+			{Path: "/login", LimitPerMinute: 10, BurstSize: 10, FailurePolicy: FailureClosed},
+		},
+	}
+	limiter := NewLimiter(setupBlackholeRedis(t), config, WithMetrics(metrics))
+	ctx := context.Background()
+
+	start := time.Now()
+	_, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/api/users"})
+	if !errors.Is(err, ErrCheckTimeout) || !errors.Is(err, ErrRedisUnavailable) {
+		t.Fatalf("expected ErrCheckTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected check to give up after check_timeout, took %v", elapsed)
+	}
+
+	if _, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/login"}); !errors.Is(err, ErrFailClosed) {
+		t.Errorf("expected ErrFailClosed on timeout, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.redisErrors.WithLabelValues(redisErrorTimeout)); cast.ToInt(got) != 2 {
+		t.Errorf("expected 2 timeouts, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.redisErrors.WithLabelValues(redisErrorOther)); cast.ToInt(got) != 0 {
+		t.Errorf("expected no other Redis errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.decisions.WithLabelValues("/api/*", DecisionTimeout)); cast.ToInt(got) != 1 {
+		t.Errorf("expected 1 timeout decision, got %v", got)
+	}
+}
@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLocalShards 默认分片数
+	defaultLocalShards = 64
+
+	// localSweepInterval 每个分片清理过期令牌桶的最小间隔
+	localSweepInterval = time.Minute
+)
+
+// LocalStore 进程内存储
+// 使用分片加锁的令牌桶实现，不依赖Redis，限流状态只在当前实例内有效
+//
+// 适用场景:
+//   - 单实例部署的服务，直接作为存储后端
+//   - Redis故障时的降级后端，把"完全不限流"退化为"按实例限流"
+//
+// 注意:
+//   - 所有算法都按令牌桶执行；窗口类算法（滑动窗口、固定窗口）的突发容量按limit计算，
+//     保证一个周期内通过的请求不超过limit
+type LocalStore struct {
+	shards []*localShard
+}
+
+// localShard 令牌桶分片，每个分片独立加锁以减少锁竞争
+type localShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*localBucket
+	lastSweep time.Time
+}
+
+// localBucket 进程内令牌桶
+type localBucket struct {
+	tokens   float64
+	lastTime time.Time
+	expireAt time.Time
+}
+
+// NewLocalStore 创建进程内存储
+// shards: 分片数，<=0时使用默认值64
+func NewLocalStore(shards int) *LocalStore {
+	if shards <= 0 {
+		shards = defaultLocalShards
+	}
+
+	s := &LocalStore{shards: make([]*localShard, shards)}
+	for i := range s.shards {
+		s.shards[i] = &localShard{buckets: make(map[string]*localBucket)}
+	}
+	return s
+}
+
+// Allow 实现Store接口
+func (s *LocalStore) Allow(
+	ctx context.Context,
+	key string,
+	algorithm string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
+) (bool, int, time.Time, error) {
+	if _, err := getAlgorithm(algorithm); err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	// 窗口类算法没有突发的概念，桶容量即为limit
+	if algorithm != AlgorithmTokenBucket && algorithm != AlgorithmGCRA {
+		burst = limit
+	}
+	if burst <= 0 {
+		burst = limit
+	}
+
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.sweep(now)
+
+	bucket, exists := shard.buckets[key]
+	if !exists {
+		bucket = &localBucket{tokens: float64(burst), lastTime: now}
+		shard.buckets[key] = bucket
+	}
+
+	// 补充令牌，令牌数不能超过桶容量
+	elapsed := now.Sub(bucket.lastTime)
+	if elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() / period.Seconds() * float64(limit)
+		bucket.lastTime = now
+	}
+	if bucket.tokens > float64(burst) {
+		bucket.tokens = float64(burst)
+	}
+
+	// 尝试消费cost个令牌
+	allowed := false
+	if bucket.tokens >= float64(cost) {
+		bucket.tokens -= float64(cost)
+		allowed = true
+	}
+	bucket.expireAt = now.Add(bucketTTL(limit, burst, period))
+
+	// 计算令牌桶补满的时间
+	resetAfter := time.Duration((float64(burst) - bucket.tokens) / float64(limit) * float64(period))
+
+	return allowed, int(math.Floor(bucket.tokens)), now.Add(resetAfter), nil
+}
+
+// bucketTTL 令牌桶空闲多久后可以清理
+// 至少要等到桶从空补满（burst大于limit时需要多个周期），否则被清理后重新创建的桶是满的，
+// 会放过Redis会拒绝的突发
+func bucketTTL(limit, burst int, period time.Duration) time.Duration {
+	ttl := 2 * period
+	if limit > 0 {
+		if refill := time.Duration(math.Ceil(float64(burst)/float64(limit))) * period; refill > ttl {
+			ttl = refill
+		}
+	}
+	return ttl
+}
+
+// getShard 根据key的哈希值选择分片
+func (s *LocalStore) getShard(key string) *localShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// sweep 清理分片中已过期的令牌桶（调用方需持有锁）
+func (sh *localShard) sweep(now time.Time) {
+	if now.Sub(sh.lastSweep) < localSweepInterval {
+		return
+	}
+	sh.lastSweep = now
+
+	for key, bucket := range sh.buckets {
+		if now.After(bucket.expireAt) {
+			delete(sh.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+)
+
+// TestLocalStore_Allow 测试进程内令牌桶
+func TestLocalStore_Allow(t *testing.T) {
+	t.Parallel()
+
+	store := NewLocalStore(4)
+	ctx := context.Background()
+	now := time.Now()
+
+	// 桶容量为2，前2次请求允许
+	for i := 0; i < 2; i++ {
+		allowed, remaining, _, err := store.Allow(ctx, "key", AlgorithmTokenBucket, 2, 2, 1, time.Second, now)
+		if err != nil {
+			t.Fatalf("request %d: expected no error, got %v", i+1, err)
+		}
+		if !cast.ToBool(allowed) {
+			t.Errorf("request %d: expected allowed to be true, got false", i+1)
+		}
+		if cast.ToInt(remaining) != 1-i {
+			t.Errorf("request %d: expected remaining to be %d, got %d", i+1, 1-i, remaining)
+		}
+	}
+
+	// 第3次请求被限流
+	allowed, _, resetTime, err := store.Allow(ctx, "key", AlgorithmTokenBucket, 2, 2, 1, time.Second, now)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false, got true")
+	}
+	if !resetTime.Equal(now.Add(time.Second)) {
+		t.Errorf("expected resetTime %v, got %v", now.Add(time.Second), resetTime)
+	}
+
+	// 500ms后补充1个令牌
+	allowed, _, _, err = store.Allow(ctx, "key", AlgorithmTokenBucket, 2, 2, 1, time.Second, now.Add(500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true after refill, got false")
+	}
+}
+
+// TestLocalStore_WindowAlgorithmIgnoresBurst 测试窗口类算法的桶容量按limit计算
+func TestLocalStore_WindowAlgorithmIgnoresBurst(t *testing.T) {
+	t.Parallel()
+
+	store := NewLocalStore(0)
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, _, _ := store.Allow(ctx, "key", AlgorithmSlidingWindowLog, 3, 6, 1, time.Minute, now)
+		if !cast.ToBool(allowed) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+
+	allowed, _, _, _ := store.Allow(ctx, "key", AlgorithmSlidingWindowLog, 3, 6, 1, time.Minute, now)
+	if cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be false, got true")
+	}
+}
+
+// TestLocalStore_Concurrent 测试并发场景下放行数量不超过桶容量
+func TestLocalStore_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	store := NewLocalStore(0)
+	ctx := context.Background()
+	now := time.Now()
+
+	var allowedCount int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowed, _, _, _ := store.Allow(ctx, "key", AlgorithmTokenBucket, 10, 10, 1, time.Minute, now); allowed {
+				atomic.AddInt64(&allowedCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cast.ToInt64(allowedCount) != 10 {
+		t.Errorf("expected 10 allowed requests, got %d", allowedCount)
+	}
+}
+
+// TestLocalStore_Sweep 测试过期令牌桶被清理
+func TestLocalStore_Sweep(t *testing.T) {
+	t.Parallel()
+
+	store := NewLocalStore(1)
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		_, _, _, _ = store.Allow(ctx, fmt.Sprintf("key:%d", i), AlgorithmTokenBucket, 1, 1, 1, time.Second, now)
+	}
+
+	// 超过清理间隔后再次访问，之前的令牌桶都已过期
+	_, _, _, _ = store.Allow(ctx, "key:new", AlgorithmTokenBucket, 1, 1, 1, time.Second, now.Add(2*localSweepInterval))
+
+	if cast.ToInt(len(store.shards[0].buckets)) != 1 {
+		t.Errorf("expected 1 bucket after sweep, got %d", len(store.shards[0].buckets))
+	}
+}
+
+// TestLocalStore_SweepKeepsBurstBucket 测试burst远大于limit时，未补满的令牌桶不会被提前清理
+func TestLocalStore_SweepKeepsBurstBucket(t *testing.T) {
+	t.Parallel()
+
+	store := NewLocalStore(1)
+	ctx := context.Background()
+	now := time.Now()
+
+	// 每分钟1个令牌，容量100，一次取空
+	allowed, _, _, _ := store.Allow(ctx, "key", AlgorithmTokenBucket, 1, 100, 100, time.Minute, now)
+	if !allowed {
+		t.Fatalf("expected first burst to be allowed")
+	}
+
+	// 3分钟后只补充了3个令牌，清理不能把桶当作过期重新补满
+	later := now.Add(3*time.Minute + localSweepInterval)
+	allowed, _, _, _ = store.Allow(ctx, "key", AlgorithmTokenBucket, 1, 100, 100, time.Minute, later)
+	if allowed {
+		t.Errorf("expected burst to be rejected before the bucket refills")
+	}
+}
+
+// TestLimiter_LocalBackend 测试不使用Redis的单实例限流
+func TestLimiter_LocalBackend(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{
+				Path:           "/api/*",
+				LimitPerSecond: 1,
+				BurstSize:      1,
+			},
+		},
+	}
+
+	limiter := NewLimiter(nil, config)
+	ctx := context.Background()
+
+	allowed, _, _, err := limiter.Allow(ctx, "192.168.1.1", "/api/test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true, got false")
+	}
+
+	_, _, _, err = limiter.Allow(ctx, "192.168.1.1", "/api/test")
+	if !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected ErrRateLimitExceeded, got %v", err)
+	}
+}
+
+// TestLimiter_LocalFallback 测试Redis不可用时降级为进程内限流
+func TestLimiter_LocalFallback(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("expected no error on miniredis.Run, got %v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+	// 模拟Redis故障
+	mr.Close()
+
+	newConfig := func(fallback bool) *Config {
+		return &Config{
+			Enabled:       true,
+			LocalFallback: fallback,
+			Rules: []RuleConfig{
+				{
+					Path:           "/api/*",
+					LimitPerSecond: 1,
+					BurstSize:      1,
+				},
+			},
+		}
+	}
+	ctx := context.Background()
+
+	// 未开启降级：返回ErrRedisUnavailable，由中间件决定放行
+	limiter := NewLimiter(rdb, newConfig(false))
+	_, _, _, err = limiter.Allow(ctx, "192.168.1.1", "/api/test")
+	if !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("expected ErrRedisUnavailable, got %v", err)
+	}
+
+	// 开启降级：按实例限流
+	limiter = NewLimiter(rdb, newConfig(true))
+	allowed, _, _, err := limiter.Allow(ctx, "192.168.1.1", "/api/test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) {
+		t.Errorf("expected allowed to be true, got false")
+	}
+
+	_, _, _, err = limiter.Allow(ctx, "192.168.1.1", "/api/test")
+	if !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected ErrRateLimitExceeded, got %v", err)
+	}
+}
+
+// TestLocalStore_Cost 测试进程内存储按cost消耗令牌
+func TestLocalStore_Cost(t *testing.T) {
+	store := NewLocalStore(1)
+	ctx := context.Background()
+	now := time.Now()
+
+	allowed, remaining, _, err := store.Allow(ctx, "key", AlgorithmTokenBucket, 5, 5, 4, time.Minute, now)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cast.ToBool(allowed) || cast.ToInt(remaining) != 1 {
+		t.Errorf("expected allowed with 1 remaining, got allowed=%v remaining=%d", allowed, remaining)
+	}
+
+	allowed, remaining, _, _ = store.Allow(ctx, "key", AlgorithmTokenBucket, 5, 5, 2, time.Minute, now)
+	if cast.ToBool(allowed) || cast.ToInt(remaining) != 1 {
+		t.Errorf("expected rejected with 1 remaining, got allowed=%v remaining=%d", allowed, remaining)
+	}
+}
@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+)
+
+// matchConditions 检查请求是否满足规则的附加匹配条件（方法、域名、请求头、查询参数）
+// 规则没有配置的条件视为满足
+func (r *RuleConfig) matchConditions(req *Request) bool {
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, method := range r.Methods {
+			if strings.EqualFold(strings.TrimSpace(method), req.Method) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.Host != "" && !hostMatch(r.Host, req.Host) {
+		return false
+	}
+
+	for name, want := range r.Headers {
+		if !valueMatch(req.Header.Values(name), want) {
+			return false
+		}
+	}
+
+	for name, want := range r.Query {
+		if !valueMatch(req.Query[name], want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// valueMatch 请求头/查询参数匹配：want为空时只要求存在，否则要求任一值完全相等
+func valueMatch(values []string, want string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if want == "" {
+		return true
+	}
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatch 域名匹配，忽略大小写和端口
+// pattern以 "*." 开头时匹配所有子域名（不包含根域名本身）
+func hostMatch(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// conditionCount 规则配置的附加匹配条件数量
+func (r *RuleConfig) conditionCount() int {
+	count := len(r.Headers) + len(r.Query)
+	if len(r.Methods) > 0 {
+		count++
+	}
+	if r.Host != "" {
+		count++
+	}
+	return count
+}
+
+// specificity 路径模式的具体程度
+type specificity struct {
+	exact     bool // 不包含任何通配段
+	literals  int  // 字面字符数（不含通配符和参数名）
+	catchAlls int  // ** 的数量
+}
+
+// pathSpecificity 计算路径模式的具体程度
+func pathSpecificity(pattern string) specificity {
+	s := specificity{exact: true, literals: len(pattern)}
+	for _, seg := range splitPath(pattern) {
+		switch segmentKind(seg) {
+		case segmentCatchAll:
+			s.catchAlls++
+			s.literals -= len(seg)
+		case segmentParam:
+			s.literals -= len(seg)
+		case segmentPattern:
+			s.literals -= patternWildcards(seg)
+		default:
+			continue
+		}
+		s.exact = false
+	}
+	return s
+}
+
+// patternWildcards 段内通配中非字面字符的个数：*、? 各计1，[...] 整体计入
+func patternWildcards(seg string) int {
+	n := 0
+	for i := 0; i < len(seg); i++ {
+		switch seg[i] {
+		case '*', '?':
+			n++
+		case '\\':
+			n++ // 转义符本身不是字面字符
+			i++
+		case '[':
+			end := strings.IndexByte(seg[i:], ']')
+			if end < 0 {
+				return n + len(seg) - i
+			}
+			n += end + 1
+			i += end
+		}
+	}
+	return n
+}
+
+// precedes 判断规则a是否优先于规则b（多个规则同时匹配时使用）
+// 依次比较：priority大的优先 > 路径更具体的优先 > 附加条件多的优先
+// 路径具体程度：精确路径 > 通配路径；通配路径中字面字符多的优先，再比较 ** 少的优先
+// 全部相同时返回false，由调用方保留配置中靠前的规则
+// as、bs分别是a、b路径的具体程度（由pathSpecificity计算）
+func precedes(a *RuleConfig, as specificity, b *RuleConfig, bs specificity) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	if as.exact != bs.exact {
+		return as.exact
+	}
+	if as.literals != bs.literals {
+		return as.literals > bs.literals
+	}
+	if as.catchAlls != bs.catchAlls {
+		return as.catchAlls < bs.catchAlls
+	}
+
+	return a.conditionCount() > b.conditionCount()
+}
+
+// conditionsID 附加匹配条件的标识，用于区分同一路径上不同条件的规则的限流计数
+// 没有附加条件时返回空字符串（保持原有的Redis key不变）
+func (r *RuleConfig) conditionsID() string {
+	if r.conditionCount() == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, r.conditionCount())
+	if len(r.Methods) > 0 {
+		methods := make([]string, 0, len(r.Methods))
+		for _, method := range r.Methods {
+			methods = append(methods, strings.ToUpper(strings.TrimSpace(method)))
+		}
+		sort.Strings(methods)
+		parts = append(parts, "method="+strings.Join(methods, ","))
+	}
+	if r.Host != "" {
+		parts = append(parts, "host="+strings.ToLower(r.Host))
+	}
+	parts = append(parts, sortedPairs("header.", r.Headers, strings.ToLower)...)
+	parts = append(parts, sortedPairs("query.", r.Query, nil)...)
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(parts, "&")))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// ruleID 规则的标识（套餐+路径+条件标识），用于按规则保存自适应、shadow等状态
+// 热加载后同一规则的标识不变
+func ruleID(tier string, rule *RuleConfig) string {
+	id := rule.Path
+	if cid := rule.conditionsID(); cid != "" {
+		id += "@" + cid
+	}
+	if tier != "" {
+		id = tier + "|" + id
+	}
+	return id
+}
+
+// sortedPairs 把map按key排序后输出为 prefix+key=value 形式
+func sortedPairs(prefix string, m map[string]string, normalize func(string) string) []string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		if normalize != nil {
+			k = normalize(k)
+		}
+		pairs = append(pairs, prefix+k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
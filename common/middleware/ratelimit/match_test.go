@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/cast"
+)
+
+// TestLimiter_MatchRule_Conditions 测试按方法、域名、请求头、查询参数匹配规则
+func TestLimiter_MatchRule_Conditions(t *testing.T) {
+	config := &Config{
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerSecond: 100},
+			{Path: "/api/login", LimitPerSecond: 10, Methods: []string{"get"}},
+			{Path: "/api/login", LimitPerSecond: 1, Methods: []string{"POST"}},
+			{Path: "/api/*", LimitPerSecond: 50, Host: "*.internal.example.com"},
+			{Path: "/api/*", LimitPerSecond: 20, Headers: map[string]string{"X-Api-Key": ""}},
+			{Path: "/api/search", LimitPerSecond: 5, Query: map[string]string{"export": "true"}},
+		},
+	}
+	rules := newRuleTrie(config.Rules)
+
+	tests := []struct {
+		name        string
+		req         *Request
+		wantRuleIdx int
+	}{
+		{
+			name:        "post login",
+			req:         &Request{Path: "/api/login", Method: http.MethodPost},
+			wantRuleIdx: 2,
+		},
+		{
+			name:        "get login",
+			req:         &Request{Path: "/api/login", Method: http.MethodGet},
+			wantRuleIdx: 1,
+		},
+		{
+			name:        "other method falls back to wildcard",
+			req:         &Request{Path: "/api/login", Method: http.MethodDelete},
+			wantRuleIdx: 0,
+		},
+		{
+			name:        "host wildcard with port",
+			req:         &Request{Path: "/api/users", Host: "svc.Internal.example.com:8080"},
+			wantRuleIdx: 3,
+		},
+		{
+			name:        "host wildcard does not match root domain",
+			req:         &Request{Path: "/api/users", Host: "internal.example.com"},
+			wantRuleIdx: 0,
+		},
+		{
+			name:        "header presence",
+			req:         &Request{Path: "/api/users", Header: http.Header{"X-Api-Key": {"abc"}}},
+			wantRuleIdx: 4,
+		},
+		{
+			name:        "query value",
+			req:         &Request{Path: "/api/search", Query: url.Values{"export": {"true"}}},
+			wantRuleIdx: 5,
+		},
+		{
+			name:        "query value mismatch",
+			req:         &Request{Path: "/api/search", Query: url.Values{"export": {"false"}}},
+			wantRuleIdx: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := rules.match(tt.req)
+			if rule == nil {
+				t.Fatalf("expected non-nil rule, got nil")
+			}
+			if rule != &config.Rules[tt.wantRuleIdx] {
+				t.Errorf("expected rule %d (limit %d), got limit %d",
+					tt.wantRuleIdx, config.Rules[tt.wantRuleIdx].LimitPerSecond, rule.LimitPerSecond)
+			}
+		})
+	}
+}
+
+// TestLimiter_MatchRule_Precedence 测试多个规则同时匹配时的优先级，与配置顺序无关
+func TestLimiter_MatchRule_Precedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []RuleConfig
+		req   *Request
+		want  int // 期望匹配的LimitPerSecond
+	}{
+		{
+			name: "exact path beats wildcard regardless of order",
+			rules: []RuleConfig{
+				{Path: "/api/*", LimitPerSecond: 1},
+				{Path: "/api/login", LimitPerSecond: 2},
+			},
+			req:  &Request{Path: "/api/login"},
+			want: 2,
+		},
+		{
+			name: "longer wildcard beats shorter wildcard",
+			rules: []RuleConfig{
+				{Path: "/api/*", LimitPerSecond: 1},
+				{Path: "/api/user*", LimitPerSecond: 2},
+			},
+			req:  &Request{Path: "/api/users"},
+			want: 2,
+		},
+		{
+			name: "more conditions win on same path",
+			rules: []RuleConfig{
+				{Path: "/api/*", LimitPerSecond: 1, Methods: []string{"POST"}},
+				{Path: "/api/*", LimitPerSecond: 2, Methods: []string{"POST"}, Headers: map[string]string{"X-Debug": "1"}},
+			},
+			req:  &Request{Path: "/api/users", Method: http.MethodPost, Header: http.Header{"X-Debug": {"1"}}},
+			want: 2,
+		},
+		{
+			name: "priority overrides specificity",
+			rules: []RuleConfig{
+				{Path: "/api/login", LimitPerSecond: 1},
+				{Path: "/api/*", LimitPerSecond: 2, Priority: 10},
+			},
+			req:  &Request{Path: "/api/login"},
+			want: 2,
+		},
+		{
+			name: "ties keep config order",
+			rules: []RuleConfig{
+				{Path: "/api/*", LimitPerSecond: 1, Methods: []string{"GET"}},
+				{Path: "/api/*", LimitPerSecond: 2, Host: "example.com"},
+			},
+			req:  &Request{Path: "/api/users", Method: http.MethodGet, Host: "example.com"},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := newRuleTrie(tt.rules).match(tt.req)
+			if rule == nil {
+				t.Fatalf("expected non-nil rule, got nil")
+			}
+			if cast.ToInt(rule.LimitPerSecond) != tt.want {
+				t.Errorf("expected limit %d, got %d", tt.want, rule.LimitPerSecond)
+			}
+		})
+	}
+}
+
+// TestLimiter_Check_MethodRulesCountSeparately 测试同一路径上不同方法的规则分别计数
+func TestLimiter_Check_MethodRulesCountSeparately(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{Path: "/api/login", LimitPerMinute: 1, BurstSize: 1, Methods: []string{"POST"}},
+			{Path: "/api/login", LimitPerMinute: 3, BurstSize: 3, Methods: []string{"GET"}},
+		},
+	}
+	limiter := NewLimiter(nil, config)
+	ctx := context.Background()
+
+	post := &Request{Key: "ip", Path: "/api/login", Method: http.MethodPost}
+	if _, err := limiter.Check(ctx, post); err != nil {
+		t.Fatalf("expected first POST to be allowed, got %v", err)
+	}
+	if _, err := limiter.Check(ctx, post); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected second POST to be limited, got %v", err)
+	}
+
+	get := &Request{Key: "ip", Path: "/api/login", Method: http.MethodGet}
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Check(ctx, get)
+		if err != nil {
+			t.Fatalf("expected GET %d to be allowed, got %v", i+1, err)
+		}
+		if cast.ToInt(result.Limit) != 3 {
+			t.Errorf("expected limit 3, got %d", result.Limit)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 限流决策（对应ratelimit_decisions_total的decision标签）
+const (
+	DecisionAllowed        = "allowed"         // 放行（包括没有匹配规则、白名单）
+	DecisionRejected       = "rejected"        // 超限被拒绝
+	DecisionShadowRejected = "shadow_rejected" // shadow规则本应拒绝
+	DecisionBanned         = "banned"          // 封禁中
+	DecisionDenied         = "denied"          // 黑名单
+	DecisionFailClosed     = "fail_closed"     // Redis不可用，按failure_policy=closed拒绝
+	DecisionTimeout        = "timeout"         // 检查超过check_timeout（且没有降级、failure_policy为open）
+	DecisionError          = "error"           // 检查出错（如Redis不可用且没有降级）
+)
+
+// Redis调用失败的类型（对应ratelimit_redis_errors_total的kind标签）
+const (
+	redisErrorTimeout = "timeout" // 超过check_timeout
+	redisErrorOther   = "error"   // 其他错误（连接失败等）
+)
+
+// noRuleLabel 没有匹配规则时rule标签的值
+const noRuleLabel = "none"
+
+// Metrics 限流器的Prometheus指标
+//
+// 指标:
+//   - ratelimit_decisions_total{rule, decision}: 按规则统计的限流决策次数
+//   - ratelimit_script_duration_seconds{script}: Redis Lua脚本的执行耗时
+//   - ratelimit_fail_open_total{middleware}: 中间件因错误放行的次数
+//   - ratelimit_redis_errors_total{kind}: 存储调用失败的次数，kind为timeout（超过check_timeout）或error
+//
+// 使用方式:
+//
+//	metrics := ratelimit.NewMetrics(prometheus.DefaultRegisterer)
+//	limiter := ratelimit.NewLimiter(redisClient, config, ratelimit.WithMetrics(metrics))
+//	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+type Metrics struct {
+	decisions      *prometheus.CounterVec
+	scriptDuration *prometheus.HistogramVec
+	failOpen       *prometheus.CounterVec
+	redisErrors    *prometheus.CounterVec
+}
+
+// NewMetrics 创建限流器指标并注册到registerer，registerer为nil时不注册（由调用方注册Collectors）
+// 重复注册会panic，同一个registerer只能调用一次
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_decisions_total",
+			Help: "Rate limiter decisions by rule and decision.",
+		}, []string{"rule", "decision"}),
+		scriptDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ratelimit_script_duration_seconds",
+			Help:    "Latency of rate limiter Redis Lua scripts.",
+			Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		}, []string{"script"}),
+		failOpen: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_fail_open_total",
+			Help: "Requests let through by rate limit middlewares because of errors.",
+		}, []string{"middleware"}),
+		redisErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_redis_errors_total",
+			Help: "Failed rate limiter store calls by kind (timeout or error).",
+		}, []string{"kind"}),
+	}
+	if registerer != nil {
+		registerer.MustRegister(m.Collectors()...)
+	}
+	return m
+}
+
+// Collectors 获取全部指标，用于注册到自定义的registerer
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.decisions, m.scriptDuration, m.failOpen, m.redisErrors}
+}
+
+// WithMetrics 设置Prometheus指标
+func WithMetrics(metrics *Metrics) Option {
+	return func(l *Limiter) {
+		l.metrics = metrics
+	}
+}
+
+// observeDecision 记录一次限流决策，m为nil时什么都不做
+func (m *Metrics) observeDecision(result *Result, err error) {
+	if m == nil {
+		return
+	}
+
+	var rule *RuleConfig
+	if result != nil {
+		rule = result.Rule
+	}
+	m.decisions.WithLabelValues(ruleLabel(rule), decisionOf(result, err)).Inc()
+}
+
+// decisionOf 根据限流检查的结果和错误得出决策
+func decisionOf(result *Result, err error) string {
+	switch {
+	case errors.Is(err, ErrDenied):
+		return DecisionDenied
+	case errors.Is(err, ErrBanned):
+		return DecisionBanned
+	case errors.Is(err, ErrFailClosed):
+		return DecisionFailClosed
+	case errors.Is(err, ErrCheckTimeout):
+		return DecisionTimeout
+	case errors.Is(err, ErrRateLimitExceeded):
+		return DecisionRejected
+	case err != nil:
+		return DecisionError
+	case result != nil && result.ShadowRejected:
+		return DecisionShadowRejected
+	}
+	return DecisionAllowed
+}
+
+// ruleLabel 规则在指标和日志中的标识，没有匹配规则时为none
+func ruleLabel(rule *RuleConfig) string {
+	if rule == nil {
+		return noRuleLabel
+	}
+	return rule.Path
+}
+
+// observeScript 记录一次Lua脚本的执行耗时
+func (m *Metrics) observeScript(script string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.scriptDuration.WithLabelValues(script).Observe(d.Seconds())
+}
+
+// observeRedisError 记录一次存储调用失败，超时和其他错误分开计数
+func (m *Metrics) observeRedisError(err error) {
+	if m == nil {
+		return
+	}
+	kind := redisErrorOther
+	if errors.Is(err, ErrCheckTimeout) {
+		kind = redisErrorTimeout
+	}
+	m.redisErrors.WithLabelValues(kind).Inc()
+}
+
+// observeFailOpen 记录一次中间件因错误放行
+func (m *Metrics) observeFailOpen(middleware string) {
+	if m == nil {
+		return
+	}
+	m.failOpen.WithLabelValues(middleware).Inc()
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/cast"
+)
+
+// TestMetrics_Decisions 测试按规则统计限流决策
+func TestMetrics_Decisions(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	config := &Config{
+		Enabled:  true,
+		Backend:  BackendRedis,
+		Denylist: []string{"203.0.113.1"},
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 1, BurstSize: 1},
+			{Path: "/beta/*", LimitPerMinute: 1, BurstSize: 1, Mode: ModeShadow},
+		},
+	}
+	limiter := NewLimiter(setupTestRedis(t), config, WithMetrics(metrics))
+	ctx := context.Background()
+
+	countAllowed(t, limiter, &Request{Key: "ip", Path: "/api/users"}, 3)
+	countAllowed(t, limiter, &Request{Key: "ip", Path: "/beta/users"}, 2)
+	countAllowed(t, limiter, &Request{Key: "ip", Path: "/public"}, 1)
+	_, _ = limiter.Check(ctx, &Request{Key: "203.0.113.1", Path: "/api/users"})
+
+	tests := []struct {
+		rule     string
+		decision string
+		want     int
+	}{
+		{"/api/*", DecisionAllowed, 1},
+		{"/api/*", DecisionRejected, 2},
+		{"/beta/*", DecisionAllowed, 1},
+		{"/beta/*", DecisionShadowRejected, 1},
+		{noRuleLabel, DecisionAllowed, 1},
+		{noRuleLabel, DecisionDenied, 1},
+	}
+	for _, tt := range tests {
+		got := testutil.ToFloat64(metrics.decisions.WithLabelValues(tt.rule, tt.decision))
+		if cast.ToInt(got) != tt.want {
+			t.Errorf("expected %d %s decisions for %s, got %v", tt.want, tt.decision, tt.rule, got)
+		}
+	}
+
+	// Redis存储记录Lua脚本耗时
+	if got := testutil.CollectAndCount(metrics.scriptDuration); got != 1 {
+		t.Errorf("expected 1 script histogram, got %d", got)
+	}
+}
+
+// TestMetrics_FailOpen 测试Redis不可用时统计放行次数
+func TestMetrics_FailOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	metrics := NewMetrics(nil)
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled: true,
+		Rules:   []RuleConfig{{Path: "/api/*", LimitPerSecond: 1, MaxConcurrent: 1}},
+	}
+	limiter := NewLimiter(rdb, config, WithMetrics(metrics))
+	_ = rdb.Close()
+
+	r := gin.New()
+	r.Use(Middleware(limiter), ConcurrencyMiddleware(limiter))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/test", nil))
+	if cast.ToInt(w.Code) != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	for _, middleware := range []string{"ratelimit", "concurrency"} {
+		if got := testutil.ToFloat64(metrics.failOpen.WithLabelValues(middleware)); cast.ToInt(got) != 1 {
+			t.Errorf("expected 1 fail open for %s, got %v", middleware, got)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.decisions.WithLabelValues("/api/*", DecisionError)); cast.ToInt(got) != 1 {
+		t.Errorf("expected 1 error decision, got %v", got)
+	}
+}
@@ -1,168 +1,452 @@
 package ratelimit
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"working-project/common/breaker"
+	"working-project/common/logging"
 )
 
+// defaultMessages 默认的限流提示文案（按语言）
+var defaultMessages = map[string]string{
+	"zh": "请求过于频繁，请稍后再试",
+	"en": "Too many requests, please try again later",
+}
+
+// defaultLanguage 默认语言
+const defaultLanguage = "zh"
+
+// RejectInfo 被限流时的响应信息，供自定义处理器和响应体模板使用
+type RejectInfo struct {
+	Message    string // 按Accept-Language选择的提示文案
+	Language   string // 选中的语言
+	Limit      int    // 生效窗口的限额
+	Remaining  int    // 剩余配额
+	RetryAfter int    // 距离配额恢复的秒数
+	Reset      int64  // 配额恢复的Unix时间戳
+	Policy     string // 规则的全部限额，如 "10;w=1, 100;w=60"
+	Path       string // 请求路径
+	Banned     bool   // 限流key处于封禁中（见Config.PenaltyBox），RetryAfter为距离解封的秒数
+}
+
+// MiddlewareOptions 限流中间件选项
+type MiddlewareOptions struct {
+	// KeyFunc 自定义限流Key，例如按用户ID、Token等维度限流
+	// 默认使用客户端IP（按Config.ClientIP配置的可信代理解析）
+	KeyFunc func(*gin.Context) string
+
+	// TierFunc 解析请求所属的套餐（见Config.Tiers），例如读取请求头、JWT claim或按API Key查询
+	// 返回空字符串时使用全局规则
+	TierFunc func(*gin.Context) string
+
+	// CostFunc 计算本次请求消耗的配额，例如按分页大小、请求体大小计算
+	// 返回值<=0时使用规则配置的cost（默认1）
+	CostFunc func(*gin.Context) int
+
+	// RejectHandler 自定义被限流时的响应（状态码、响应体均由处理器决定）
+	// 设置后BodyTemplate不生效；限流响应头在调用前已经设置
+	RejectHandler func(c *gin.Context, info *RejectInfo)
+
+	// BodyTemplate 429响应体模板（text/template语法），模板数据为RejectInfo
+	// 提供json函数用于输出JSON字符串，例如：
+	//   {"code":42901,"msg":{{json .Message}},"retry_after":{{.RetryAfter}}}
+	// 模板不合法时创建中间件会panic
+	BodyTemplate string
+
+	// ContentType BodyTemplate响应的Content-Type，默认application/json; charset=utf-8
+	ContentType string
+
+	// Messages 按语言配置的提示文案，key为语言标签（如zh、en、zh-TW）
+	// 默认提供zh、en两种
+	Messages map[string]string
+
+	// DefaultLanguage Accept-Language无法匹配时使用的语言，默认zh
+	DefaultLanguage string
+
+	// DisableLegacyHeaders 不输出X-RateLimit-*响应头
+	DisableLegacyHeaders bool
+
+	// DisableStandardHeaders 不输出IETF草案RateLimit-*响应头
+	DisableStandardHeaders bool
+}
+
 // Middleware 限流中间件
 func Middleware(limiter *Limiter) gin.HandlerFunc {
+	return MiddlewareWithOptions(limiter, MiddlewareOptions{})
+}
+
+// MiddlewareWithKeyFunc 自定义限流Key的中间件
+// keyFunc: 自定义Key生成函数，例如可以根据用户ID、Token等维度限流
+func MiddlewareWithKeyFunc(limiter *Limiter, keyFunc func(*gin.Context) string) gin.HandlerFunc {
+	return MiddlewareWithOptions(limiter, MiddlewareOptions{KeyFunc: keyFunc})
+}
+
+// MiddlewareWithOptions 可配置响应的限流中间件
+func MiddlewareWithOptions(limiter *Limiter, opts MiddlewareOptions) gin.HandlerFunc {
+	bodyTemplate := opts.prepare(limiter)
+
 	return func(c *gin.Context) {
-		// 获取客户端IP
-		ip := getClientIP(c)
-		if ip == "" {
-			// 如果无法获取IP，为了安全起见，拒绝请求
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "unable to identify client",
-			})
-			c.Abort()
+		// 获取限流Key
+		key, ok := opts.requireKey(c)
+		if !ok {
 			return
 		}
 
 		// 获取请求路径
 		path := c.Request.URL.Path
+		ctx := logging.RequestContext(c.Request)
 
 		// 检查是否允许通过
-		allowed, remaining, resetTime, err := limiter.Allow(c.Request.Context(), ip, path)
+		result, err := limiter.Check(ctx, opts.buildRequest(c, limiter, key))
 
-		// 设置响应头，告知客户端限流状态
-		if remaining >= 0 {
-			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-			c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", resetTime.Unix()))
+		// 黑名单
+		if errors.Is(err, ErrDenied) {
+			limiter.log().InfoContext(ctx, "ratelimit: request denied",
+				logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx))
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "access denied",
+			})
+			c.Abort()
+			return
 		}
 
 		// 处理错误
-		if err != nil {
-			if errors.Is(err, ErrRateLimitExceeded) {
-				// 被限流，返回429
-				c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()))
-				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error":       "rate limit exceeded",
-					"message":     "请求过于频繁，请稍后再试",
-					"retry_after": resetTime.Unix(),
-				})
-				c.Abort()
-				return
-			}
-
+		if err != nil && !errors.Is(err, ErrRateLimitExceeded) {
 			// 其他错误（如Redis连接失败）
 			// 为了服务可用性，这里选择放行请求，但记录错误日志
-			// 生产环境建议接入日志系统
-			fmt.Printf("[RateLimit] Error: %v, IP: %s, Path: %s\n", err, ip, path)
+			logFailOpen(ctx, limiter.log(), "ratelimit: check failed, request let through", err, key, path)
+			limiter.metrics.observeFailOpen("ratelimit")
+			c.Next()
+			return
+		}
+
+		if result.ShadowRejected {
+			limiter.log().InfoContext(ctx, "ratelimit: shadow rejected",
+				logging.Rule(result.Rule.Path), logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx))
+		}
+
+		// 设置响应头，告知客户端限流状态
+		now := time.Now()
+		setRateLimitHeaders(c, result, now, opts)
+
+		if result.Allowed {
+			// 允许通过，记录处理结果用于自适应限流
+			start := time.Now()
+			c.Next()
+			result.Observe(time.Since(start), c.Writer.Status())
+			return
+		}
+
+		// 被限流，返回429
+		retryAfter := result.RetryAfter(now)
+		limiter.log().InfoContext(ctx, "ratelimit: request rejected",
+			logging.Rule(ruleLabel(result.Rule)), logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx),
+			slog.Bool("banned", result.Banned), slog.Int("retry_after", retryAfter))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+		opts.reject(c, limiter, bodyTemplate, &RejectInfo{
+			Limit:      result.Limit,
+			Remaining:  result.Remaining,
+			RetryAfter: retryAfter,
+			Reset:      result.ResetTime.Unix(),
+			Policy:     result.Policy,
+			Path:       path,
+			Banned:     result.Banned,
+		})
+	}
+}
+
+// concurrencyRetryAfter 并发数超限时建议客户端重试的秒数
+// 槽位随时可能释放，无法像速率限制那样计算恢复时间
+const concurrencyRetryAfter = 1
+
+// ConcurrencyMiddleware 并发限制中间件，限制规则max_concurrent指定的同时处理中的请求数
+// 与Middleware相互独立，需要同时限制速率时两个中间件都要注册
+func ConcurrencyMiddleware(limiter *Limiter) gin.HandlerFunc {
+	return ConcurrencyMiddlewareWithOptions(limiter, MiddlewareOptions{})
+}
+
+// ConcurrencyMiddlewareWithOptions 可配置响应的并发限制中间件
+// 获取到槽位后执行后续处理，c.Next()返回后释放槽位
+// 选项含义与MiddlewareWithOptions相同（CostFunc不生效），并发限制响应头为:
+//   - X-ConcurrencyLimit-Limit / X-ConcurrencyLimit-Remaining（DisableLegacyHeaders时不输出）
+func ConcurrencyMiddlewareWithOptions(limiter *Limiter, opts MiddlewareOptions) gin.HandlerFunc {
+	bodyTemplate := opts.prepare(limiter)
+
+	return func(c *gin.Context) {
+		key, ok := opts.requireKey(c)
+		if !ok {
+			return
+		}
+		path := c.Request.URL.Path
+		ctx := logging.RequestContext(c.Request)
+
+		lease, err := limiter.Acquire(ctx, opts.buildRequest(c, limiter, key))
+		if err != nil && !errors.Is(err, ErrConcurrencyLimitExceeded) {
+			// 与限流中间件一样，Redis等错误时放行请求
+			logFailOpen(ctx, limiter.log(), "ratelimit: concurrency acquire failed, request let through", err, key, path)
+			limiter.metrics.observeFailOpen("concurrency")
+			c.Next()
+			return
+		}
+		if lease == nil {
+			// 没有配置并发限制
 			c.Next()
 			return
 		}
 
-		if !allowed {
-			// 被限流，返回429
-			c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()))
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "rate limit exceeded",
-				"message":     "请求过于频繁，请稍后再试",
-				"retry_after": resetTime.Unix(),
+		if lease.ShadowRejected {
+			limiter.log().InfoContext(ctx, "ratelimit: concurrency shadow rejected",
+				logging.Rule(lease.Rule.Path), logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx))
+		}
+
+		// shadow规则不输出响应头，避免客户端据此降速
+		if !opts.DisableLegacyHeaders && lease.Rule.GetMode() != ModeShadow {
+			c.Header("X-ConcurrencyLimit-Limit", strconv.Itoa(lease.Limit))
+			c.Header("X-ConcurrencyLimit-Remaining", strconv.Itoa(lease.Remaining))
+		}
+
+		if err != nil {
+			// 没有空闲槽位，返回429
+			limiter.log().InfoContext(ctx, "ratelimit: concurrency limit exceeded",
+				logging.Rule(lease.Rule.Path), logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx))
+			c.Header("Retry-After", strconv.Itoa(concurrencyRetryAfter))
+			opts.reject(c, limiter, bodyTemplate, &RejectInfo{
+				Limit:      lease.Limit,
+				Remaining:  0,
+				RetryAfter: concurrencyRetryAfter,
+				Reset:      time.Now().Add(concurrencyRetryAfter * time.Second).Unix(),
+				Path:       path,
 			})
-			c.Abort()
 			return
 		}
 
-		// 允许通过
+		defer func() {
+			if err := lease.releaseWithTimeout(); err != nil {
+				limiter.log().WarnContext(ctx, "ratelimit: release concurrency slot failed",
+					logging.Err(err), logging.Rule(lease.Rule.Path), logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx))
+			}
+		}()
 		c.Next()
 	}
 }
 
-// getClientIP 获取客户端真实IP
-// 优先从X-Forwarded-For, X-Real-IP等Header获取，最后才使用RemoteAddr
-func getClientIP(c *gin.Context) string {
-	// 1. 尝试从X-Forwarded-For获取（可能被伪造，需要配合可信代理列表使用）
-	xForwardedFor := c.GetHeader("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// X-Forwarded-For格式: client, proxy1, proxy2
-		// 取第一个IP
-		ips := strings.Split(xForwardedFor, ",")
-		if len(ips) > 0 {
-			ip := strings.TrimSpace(ips[0])
-			if ip != "" {
-				return ip
-			}
+// logFailOpen 记录因错误放行的请求
+// 熔断器打开期间每个请求都会放行，只记录debug日志（熔断器状态变化时已记录warn日志）
+func logFailOpen(ctx context.Context, logger logging.Logger, msg string, err error, key, path string) {
+	args := []any{logging.Err(err), logging.KeyHash(key), logging.Path(path), logging.RequestID(ctx)}
+	if errors.Is(err, breaker.ErrOpen) {
+		logger.DebugContext(ctx, msg, args...)
+		return
+	}
+	logger.WarnContext(ctx, msg, args...)
+}
+
+// prepare 填充选项的默认值，并编译响应体模板（未配置模板时返回nil）
+func (opts *MiddlewareOptions) prepare(limiter *Limiter) *template.Template {
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = func(c *gin.Context) string {
+			return limiter.ClientIP(c.Request)
 		}
 	}
+	if opts.Messages == nil {
+		opts.Messages = defaultMessages
+	}
+	if opts.DefaultLanguage == "" {
+		opts.DefaultLanguage = defaultLanguage
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = "application/json; charset=utf-8"
+	}
+
+	if opts.BodyTemplate == "" {
+		return nil
+	}
+	return template.Must(template.New("ratelimit").
+		Funcs(template.FuncMap{"json": templateJSON}).
+		Parse(opts.BodyTemplate))
+}
+
+// requireKey 获取限流Key，无法获取时返回403并中止请求
+func (opts *MiddlewareOptions) requireKey(c *gin.Context) (string, bool) {
+	key := opts.KeyFunc(c)
+	if key == "" {
+		// 如果无法获取Key，为了安全起见，拒绝请求
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "unable to identify client",
+		})
+		c.Abort()
+		return "", false
+	}
+	return key, true
+}
 
-	// 2. 尝试从X-Real-IP获取
-	xRealIP := c.GetHeader("X-Real-IP")
-	if xRealIP != "" {
-		return strings.TrimSpace(xRealIP)
+// buildRequest 根据gin请求构造限流检查的请求信息
+func (opts *MiddlewareOptions) buildRequest(c *gin.Context, limiter *Limiter, key string) *Request {
+	// 解析套餐
+	tier := ""
+	if opts.TierFunc != nil {
+		tier = opts.TierFunc(c)
 	}
 
-	// 3. 使用RemoteAddr
-	// RemoteAddr格式: IP:Port
-	remoteAddr := c.Request.RemoteAddr
-	if idx := strings.LastIndex(remoteAddr, ":"); idx != -1 {
-		return remoteAddr[:idx]
+	// 计算本次请求消耗的配额
+	cost := 0
+	if opts.CostFunc != nil {
+		cost = opts.CostFunc(c)
 	}
 
-	return remoteAddr
+	return &Request{
+		Key:    key,
+		IP:     limiter.ClientIP(c.Request),
+		Path:   c.Request.URL.Path,
+		Cost:   cost,
+		Tier:   tier,
+		Route:  c.FullPath(),
+		Method: c.Request.Method,
+		Host:   c.Request.Host,
+		Header: c.Request.Header,
+		Query:  c.Request.URL.Query(),
+	}
 }
 
-// MiddlewareWithKeyFunc 自定义限流Key的中间件
-// keyFunc: 自定义Key生成函数，例如可以根据用户ID、Token等维度限流
-func MiddlewareWithKeyFunc(limiter *Limiter, keyFunc func(*gin.Context) string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 获取自定义Key
-		key := keyFunc(c)
-		if key == "" {
-			// 如果无法获取Key，拒绝请求
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "unable to identify client",
-			})
-			c.Abort()
-			return
+// reject 按选项输出429响应并中止请求
+// info的Message、Language由此处按Accept-Language填充
+func (opts *MiddlewareOptions) reject(c *gin.Context, limiter *Limiter, bodyTemplate *template.Template, info *RejectInfo) {
+	info.Language = preferredLanguage(c.GetHeader("Accept-Language"), opts.Messages, opts.DefaultLanguage)
+	info.Message = opts.Messages[info.Language]
+	if info.Message == "" {
+		// 自定义文案缺少该语言（如没有配置DefaultLanguage的文案）时使用内置文案
+		info.Message = defaultMessages[info.Language]
+		if info.Message == "" {
+			info.Message = defaultMessages[defaultLanguage]
 		}
+	}
 
-		// 获取请求路径
-		path := c.Request.URL.Path
+	switch {
+	case opts.RejectHandler != nil:
+		opts.RejectHandler(c, info)
+	case bodyTemplate != nil:
+		var buf bytes.Buffer
+		if err := bodyTemplate.Execute(&buf, info); err != nil {
+			ctx := logging.RequestContext(c.Request)
+			limiter.log().ErrorContext(ctx, "ratelimit: render body template failed",
+				logging.Err(err), logging.Path(info.Path), logging.RequestID(ctx))
+			c.Status(http.StatusTooManyRequests)
+			break
+		}
+		c.Data(http.StatusTooManyRequests, opts.ContentType, buf.Bytes())
+	default:
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "rate limit exceeded",
+			"message":     info.Message,
+			"retry_after": info.RetryAfter,
+		})
+	}
+	c.Abort()
+}
 
-		// 检查是否允许通过
-		allowed, remaining, resetTime, err := limiter.Allow(c.Request.Context(), key, path)
+// setRateLimitHeaders 设置限流相关的响应头
+// 未匹配到规则（Remaining<0）或没有限额（如failure_policy=closed时Redis不可用）时不设置
+//
+// 旧版响应头:
+//   - X-RateLimit-Limit / X-RateLimit-Remaining
+//   - X-RateLimit-Reset: 配额恢复的Unix时间戳
+//
+// IETF草案响应头（draft-ietf-httpapi-ratelimit-headers）:
+//   - RateLimit-Limit / RateLimit-Remaining
+//   - RateLimit-Reset: 距离配额恢复的秒数
+//   - RateLimit-Policy: 规则的全部限额，如 "10;w=1, 100;w=60"
+func setRateLimitHeaders(c *gin.Context, result *Result, now time.Time, opts MiddlewareOptions) {
+	// shadow规则不输出响应头，避免客户端据此降速
+	// 封禁时没有匹配的规则，只输出Retry-After
+	// failure_policy=closed拒绝时没有读到窗口的限额，只输出Retry-After
+	if result.Remaining < 0 || result.Limit <= 0 || result.Rule == nil || result.Rule.GetMode() == ModeShadow {
+		return
+	}
+
+	if !opts.DisableLegacyHeaders {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(result.ResetTime.Unix(), 10))
+	}
 
-		// 设置响应头
-		if remaining >= 0 {
-			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-			c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", resetTime.Unix()))
+	if !opts.DisableStandardHeaders {
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(result.RetryAfter(now)))
+		if result.Policy != "" {
+			c.Header("RateLimit-Policy", result.Policy)
 		}
+	}
+}
 
-		// 处理错误
-		if err != nil {
-			if errors.Is(err, ErrRateLimitExceeded) {
-				c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()))
-				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error":       "rate limit exceeded",
-					"message":     "请求过于频繁，请稍后再试",
-					"retry_after": resetTime.Unix(),
-				})
-				c.Abort()
-				return
-			}
+// preferredLanguage 根据Accept-Language选择提示文案的语言
+// 按q值从高到低依次尝试完整标签（zh-TW）和主标签（zh），都不匹配时使用fallback
+func preferredLanguage(acceptLanguage string, messages map[string]string, fallback string) string {
+	type languageRange struct {
+		tag string
+		q   float64
+	}
 
-			fmt.Printf("[RateLimit] Error: %v, Key: %s, Path: %s\n", err, key, path)
-			c.Next()
-			return
+	var ranges []languageRange
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
 		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, languageRange{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
 
-		if !allowed {
-			c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()))
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "rate limit exceeded",
-				"message":     "请求过于频繁，请稍后再试",
-				"retry_after": resetTime.Unix(),
-			})
-			c.Abort()
-			return
+	// messages的key不区分大小写
+	lookup := make(map[string]string, len(messages))
+	for lang := range messages {
+		lookup[strings.ToLower(lang)] = lang
+	}
+
+	for _, r := range ranges {
+		if lang, ok := lookup[r.tag]; ok {
+			return lang
+		}
+		if idx := strings.Index(r.tag, "-"); idx > 0 {
+			if lang, ok := lookup[r.tag[:idx]]; ok {
+				return lang
+			}
 		}
+	}
 
-		c.Next()
+	return fallback
+}
+
+// templateJSON 模板函数：把值编码为JSON
+func templateJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
 	}
+	return string(data), nil
 }
@@ -0,0 +1,483 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"working-project/common/logging"
+)
+
+// setupTestRouter 创建使用进程内限流的测试路由
+func setupTestRouter(t *testing.T, opts MiddlewareOptions) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{
+				Path:           "/api/*",
+				LimitPerSecond: 1,
+				LimitPerMinute: 100,
+				BurstSize:      1,
+			},
+		},
+	}
+
+	r := gin.New()
+	r.Use(MiddlewareWithOptions(NewLimiter(nil, config), opts))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/public", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return r
+}
+
+// doRequest 发送测试请求
+func doRequest(r *gin.Engine, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// TestMiddleware_Headers 测试旧版和IETF草案限流响应头
+func TestMiddleware_Headers(t *testing.T) {
+	r := setupTestRouter(t, MiddlewareOptions{})
+
+	w := doRequest(r, "/api/test", nil)
+	if cast.ToInt(w.Code) != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	expected := map[string]string{
+		"X-RateLimit-Limit":     "1",
+		"X-RateLimit-Remaining": "0",
+		"RateLimit-Limit":       "1",
+		"RateLimit-Remaining":   "0",
+		"RateLimit-Reset":       "1",
+		"RateLimit-Policy":      "1;w=1, 100;w=60",
+	}
+	for header, want := range expected {
+		if got := w.Header().Get(header); cast.ToString(got) != want {
+			t.Errorf("expected %s to be %q, got %q", header, want, got)
+		}
+	}
+	if w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Errorf("expected X-RateLimit-Reset to be set")
+	}
+
+	// 被限流：Retry-After是秒数而不是时间戳
+	w = doRequest(r, "/api/test", nil)
+	if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); cast.ToString(got) != "1" {
+		t.Errorf("expected Retry-After to be \"1\", got %q", got)
+	}
+	// 响应体的retry_after与Retry-After相同，也是秒数
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected JSON body, got %v", err)
+	}
+	if got := cast.ToInt(body["retry_after"]); got != 1 {
+		t.Errorf("expected retry_after to be 1 second, got %v", body["retry_after"])
+	}
+
+	// 未匹配规则的路径不设置限流响应头
+	w = doRequest(r, "/public", nil)
+	if w.Header().Get("RateLimit-Limit") != "" || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("expected no rate limit headers for unmatched path")
+	}
+}
+
+// TestMiddleware_DisableHeaders 测试关闭响应头
+func TestMiddleware_DisableHeaders(t *testing.T) {
+	r := setupTestRouter(t, MiddlewareOptions{DisableLegacyHeaders: true})
+
+	w := doRequest(r, "/api/test", nil)
+	if w.Header().Get("X-RateLimit-Remaining") != "" {
+		t.Errorf("expected no legacy headers")
+	}
+	if w.Header().Get("RateLimit-Remaining") == "" {
+		t.Errorf("expected standard headers")
+	}
+}
+
+// TestMiddleware_AcceptLanguage 测试按Accept-Language选择提示文案
+func TestMiddleware_AcceptLanguage(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{
+			name: "default language",
+			want: defaultMessages["zh"],
+		},
+		{
+			name:           "english with region",
+			acceptLanguage: "en-US,en;q=0.9",
+			want:           defaultMessages["en"],
+		},
+		{
+			name:           "q value ordering",
+			acceptLanguage: "fr;q=0.9, zh-CN;q=0.5, en;q=0.8",
+			want:           defaultMessages["en"],
+		},
+		{
+			name:           "unsupported language falls back",
+			acceptLanguage: "ja",
+			want:           defaultMessages["zh"],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := setupTestRouter(t, MiddlewareOptions{})
+			headers := map[string]string{"Accept-Language": tt.acceptLanguage}
+
+			doRequest(r, "/api/test", headers)
+			w := doRequest(r, "/api/test", headers)
+			if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+				t.Fatalf("expected status 429, got %d", w.Code)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected json body, got %v", err)
+			}
+			if cast.ToString(body["message"]) != tt.want {
+				t.Errorf("expected message %q, got %q", tt.want, body["message"])
+			}
+		})
+	}
+}
+
+// TestMiddleware_MessageFallback 测试自定义文案缺少默认语言时使用内置文案
+func TestMiddleware_MessageFallback(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultLanguage string
+		want            string
+	}{
+		{name: "builtin language", want: defaultMessages["zh"]},
+		{name: "unknown language", defaultLanguage: "ja", want: defaultMessages["zh"]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := setupTestRouter(t, MiddlewareOptions{
+				Messages:        map[string]string{"en": "slow down"},
+				DefaultLanguage: tt.defaultLanguage,
+			})
+			headers := map[string]string{"Accept-Language": "fr"}
+
+			doRequest(r, "/api/test", headers)
+			w := doRequest(r, "/api/test", headers)
+			if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+				t.Fatalf("expected status 429, got %d", w.Code)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected json body, got %v", err)
+			}
+			if cast.ToString(body["message"]) != tt.want {
+				t.Errorf("expected message %q, got %q", tt.want, body["message"])
+			}
+		})
+	}
+}
+
+// TestMiddleware_BodyTemplate 测试自定义响应体模板
+func TestMiddleware_BodyTemplate(t *testing.T) {
+	r := setupTestRouter(t, MiddlewareOptions{
+		BodyTemplate:    `{"code":42901,"msg":{{json .Message}},"retry":{{.RetryAfter}},"policy":{{json .Policy}}}`,
+		Messages:        map[string]string{"en": `slow "down"`},
+		DefaultLanguage: "en",
+	})
+
+	doRequest(r, "/api/test", nil)
+	w := doRequest(r, "/api/test", nil)
+	if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); cast.ToString(got) != "application/json; charset=utf-8" {
+		t.Errorf("expected json content type, got %q", got)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected valid json body, got %v: %s", err, w.Body.String())
+	}
+	if cast.ToInt(body["code"]) != 42901 {
+		t.Errorf("expected code 42901, got %v", body["code"])
+	}
+	if cast.ToString(body["msg"]) != `slow "down"` {
+		t.Errorf("expected escaped message, got %v", body["msg"])
+	}
+	if cast.ToInt(body["retry"]) != 1 {
+		t.Errorf("expected retry 1, got %v", body["retry"])
+	}
+	if cast.ToString(body["policy"]) != "1;w=1, 100;w=60" {
+		t.Errorf("expected policy, got %v", body["policy"])
+	}
+}
+
+// TestMiddleware_RejectHandler 测试自定义拒绝处理器
+func TestMiddleware_RejectHandler(t *testing.T) {
+	var got *RejectInfo
+	r := setupTestRouter(t, MiddlewareOptions{
+		RejectHandler: func(c *gin.Context, info *RejectInfo) {
+			got = info
+			c.String(http.StatusServiceUnavailable, "busy")
+		},
+	})
+
+	doRequest(r, "/api/test", nil)
+	w := doRequest(r, "/api/test", nil)
+	if cast.ToInt(w.Code) != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", w.Code)
+	}
+	if cast.ToString(w.Body.String()) != "busy" {
+		t.Errorf("expected body 'busy', got %q", w.Body.String())
+	}
+	if got == nil {
+		t.Fatalf("expected reject handler to be called")
+	}
+	if cast.ToString(got.Path) != "/api/test" {
+		t.Errorf("expected path /api/test, got %s", got.Path)
+	}
+	if cast.ToInt(got.Limit) != 1 {
+		t.Errorf("expected limit 1, got %d", got.Limit)
+	}
+	// 响应头在调用处理器之前已经设置
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected Retry-After header")
+	}
+}
+
+// TestMiddleware_ClientIP 测试默认Key只在可信代理后采信X-Forwarded-For
+func TestMiddleware_ClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerSecond: 1, LimitPerMinute: 100, BurstSize: 1},
+		},
+	}
+	limiter := NewLimiter(nil, config)
+	r := gin.New()
+	r.Use(Middleware(limiter))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	// 未配置可信代理：伪造的X-Forwarded-For不能绕过限流
+	doRequest(r, "/api/test", map[string]string{"X-Forwarded-For": "1.1.1.1"})
+	w := doRequest(r, "/api/test", map[string]string{"X-Forwarded-For": "2.2.2.2"})
+	if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Errorf("expected spoofed header to be ignored, got status %d", w.Code)
+	}
+
+	// 直连地址是可信代理：按X-Forwarded-For区分客户端
+	trusted := *config
+	trusted.ClientIP.TrustedProxies = []string{"192.168.0.0/16"}
+	if err := limiter.UpdateConfig(&trusted); err != nil {
+		t.Fatalf("expected no error on UpdateConfig, got %v", err)
+	}
+	for _, ip := range []string{"3.3.3.3", "4.4.4.4"} {
+		w = doRequest(r, "/api/test", map[string]string{"X-Forwarded-For": ip})
+		if cast.ToInt(w.Code) != http.StatusOK {
+			t.Errorf("expected status 200 for %s, got %d", ip, w.Code)
+		}
+	}
+}
+
+// TestMiddleware_RouteTemplateScope 测试按gin路由模板计数
+func TestMiddleware_RouteTemplateScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{Path: "/users/:id", LimitPerMinute: 1, BurstSize: 1, Scope: ScopePerRouteTemplate},
+		},
+	}
+	r := gin.New()
+	r.Use(Middleware(NewLimiter(nil, config)))
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	if w := doRequest(r, "/users/1", nil); cast.ToInt(w.Code) != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if w := doRequest(r, "/users/2", nil); cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Errorf("expected /users/2 to share quota with /users/1, got status %d", w.Code)
+	}
+}
+
+// TestMiddleware_CostFunc 测试按请求计算消耗的配额
+func TestMiddleware_CostFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{Path: "/api/search", LimitPerMinute: 100, BurstSize: 100},
+		},
+	}
+	r := gin.New()
+	r.Use(MiddlewareWithOptions(NewLimiter(nil, config), MiddlewareOptions{
+		CostFunc: func(c *gin.Context) int {
+			return cast.ToInt(c.Query("page_size")) / 10
+		},
+	}))
+	r.GET("/api/search", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := doRequest(r, "/api/search?page_size=500", nil)
+	if cast.ToInt(w.Code) != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); cast.ToString(got) != "50" {
+		t.Errorf("expected remaining 50, got %q", got)
+	}
+
+	// 未传page_size时CostFunc返回0，使用规则默认cost=1
+	w = doRequest(r, "/api/search", nil)
+	if got := w.Header().Get("RateLimit-Remaining"); cast.ToString(got) != "49" {
+		t.Errorf("expected remaining 49, got %q", got)
+	}
+}
+
+// TestMiddleware_TierFunc 测试按请求解析套餐
+func TestMiddleware_TierFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(MiddlewareWithOptions(NewLimiter(nil, tierTestConfig()), MiddlewareOptions{
+		TierFunc: func(c *gin.Context) string {
+			return c.GetHeader("X-Plan")
+		},
+	}))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := doRequest(r, "/api/test", map[string]string{"X-Plan": "pro"})
+	if got := w.Header().Get("RateLimit-Limit"); cast.ToString(got) != "3" {
+		t.Errorf("expected pro limit 3, got %q", got)
+	}
+	w = doRequest(r, "/api/test", nil)
+	if got := w.Header().Get("RateLimit-Limit"); cast.ToString(got) != "1" {
+		t.Errorf("expected global limit 1, got %q", got)
+	}
+}
+
+// TestMiddleware_Logger 测试被限流时输出带规则、key哈希、路径和请求ID的日志
+func TestMiddleware_Logger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var logs bytes.Buffer
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules:   []RuleConfig{{Path: "/api/*", LimitPerMinute: 1, BurstSize: 1}},
+	}
+	limiter := NewLimiter(nil, config, WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	r := gin.New()
+	r.Use(Middleware(limiter))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	headers := map[string]string{logging.RequestIDHeader: "req-42"}
+	doRequest(r, "/api/test", headers)
+	if logs.Len() != 0 {
+		t.Fatalf("expected no log for allowed request, got %s", logs.String())
+	}
+	if w := doRequest(r, "/api/test", headers); cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected 1 JSON log entry, got %q", logs.String())
+	}
+	want := map[string]string{
+		"rule":       "/api/*",
+		"key_hash":   logging.HashKey("192.168.1.1"),
+		"path":       "/api/test",
+		"request_id": "req-42",
+	}
+	for field, value := range want {
+		if cast.ToString(entry[field]) != value {
+			t.Errorf("expected %s=%s, got %v", field, value, entry[field])
+		}
+	}
+	if strings.Contains(logs.String(), "192.168.1.1") {
+		t.Errorf("expected raw key not to be logged, got %s", logs.String())
+	}
+}
+
+// TestMiddleware_FailurePolicy 测试Redis不可用时按规则的failure_policy放行或返回429
+func TestMiddleware_FailurePolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rdb := setupTestRedis(t)
+	_ = rdb.Close()
+	config := &Config{
+		Enabled: true,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 10, BurstSize: 10},
+			{Path: "/login", LimitPerMinute: 10, BurstSize: 10, FailurePolicy: FailureClosed},
+		},
+	}
+	limiter := NewLimiter(rdb, config, WithLogger(logging.Discard()))
+
+	r := gin.New()
+	r.Use(Middleware(limiter))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/login", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	if w := doRequest(r, "/api/test", nil); cast.ToInt(w.Code) != http.StatusOK {
+		t.Errorf("expected fail open rule to return 200, got %d", w.Code)
+	}
+	w := doRequest(r, "/login", nil)
+	if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Errorf("expected fail closed rule to return 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected Retry-After header for fail closed rule")
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "" || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected no limit headers for fail closed rule, got %v", w.Header())
+	}
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// overrideKeyPrefix 配额覆盖在Redis中的key前缀，完整key为 ratelimit:override:<限流key>
+const overrideKeyPrefix = "ratelimit:override:"
+
+// Override 单个限流key（客户、用户、API Key等）的配额覆盖
+// 存储在Redis中，客服/运营调整某个客户的限额时不需要修改配置文件
+type Override struct {
+	Tier       string  // 强制使用的套餐，为空则使用TierFunc解析出的套餐
+	Multiplier float64 // 限额倍数，limit和burst同时放大，<=0表示不调整
+}
+
+// SetOverride 设置限流key的配额覆盖
+// ttl>0时到期自动失效（如临时提额），否则一直有效直到DeleteOverride
+// 其它实例最多延迟Config.OverrideCacheTTL生效
+func (l *Limiter) SetOverride(ctx context.Context, key string, override *Override, ttl time.Duration) error {
+	if override == nil || override.Multiplier < 0 {
+		return ErrInvalidConfig
+	}
+	if override.Tier != "" {
+		if _, ok := l.getConfig().Tiers[override.Tier]; !ok {
+			return fmt.Errorf("%w: unknown tier %q", ErrInvalidConfig, override.Tier)
+		}
+	}
+	if l.redis == nil {
+		return ErrRedisUnavailable
+	}
+
+	redisKey := overrideKeyPrefix + key
+	_, err := l.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisKey)
+		pipe.HSet(ctx, redisKey,
+			"tier", override.Tier,
+			"multiplier", strconv.FormatFloat(override.Multiplier, 'f', -1, 64),
+		)
+		if ttl > 0 {
+			pipe.Expire(ctx, redisKey, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+
+	l.overrides.invalidate(key)
+	return nil
+}
+
+// GetOverride 获取限流key的配额覆盖（直接读取Redis），没有覆盖时返回nil
+func (l *Limiter) GetOverride(ctx context.Context, key string) (*Override, error) {
+	if l.redis == nil {
+		return nil, ErrRedisUnavailable
+	}
+
+	values, err := l.redis.HGetAll(ctx, overrideKeyPrefix+key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	override := &Override{Tier: values["tier"]}
+	if v := values["multiplier"]; v != "" {
+		multiplier, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid multiplier %q", ErrInvalidConfig, v)
+		}
+		override.Multiplier = multiplier
+	}
+	return override, nil
+}
+
+// DeleteOverride 删除限流key的配额覆盖
+func (l *Limiter) DeleteOverride(ctx context.Context, key string) error {
+	if l.redis == nil {
+		return ErrRedisUnavailable
+	}
+	if err := l.redis.Del(ctx, overrideKeyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+
+	l.overrides.invalidate(key)
+	return nil
+}
+
+// overrideErrorCacheTTL 查询配额覆盖失败时，按没有覆盖缓存的时间
+// Redis变慢时避免每个请求都在覆盖查询上消耗check_timeout，之后的限流检查仍会如实报告Redis的状态
+const overrideErrorCacheTTL = time.Second
+
+// lookupOverride 获取限流时使用的配额覆盖，优先使用本地缓存
+// Redis不可用等错误时按没有覆盖处理（短暂缓存），不影响限流本身；查询经过熔断器
+func (l *Limiter) lookupOverride(ctx context.Context, config *Config, key string) *Override {
+	if !config.EnableOverrides || l.redis == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if override, ok := l.overrides.get(key, now); ok {
+		return override
+	}
+
+	var override *Override
+	err := l.guardRedis(ctx, func() error {
+		var err error
+		override, err = l.GetOverride(ctx, key)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrInvalidConfig) {
+		ttl := overrideErrorCacheTTL
+		if cacheTTL := config.GetOverrideCacheTTL(); cacheTTL < ttl {
+			ttl = cacheTTL
+		}
+		l.overrides.set(key, nil, now.Add(ttl))
+		return nil
+	}
+	l.overrides.set(key, override, now.Add(config.GetOverrideCacheTTL()))
+	return override
+}
+
+// scaled 返回按倍数放大限额后的规则副本
+func (r *RuleConfig) scaled(multiplier float64) *RuleConfig {
+	rule := *r
+	scale := func(n int) int {
+		if n <= 0 {
+			return n
+		}
+		return int(math.Max(1, math.Ceil(float64(n)*multiplier)))
+	}
+	rule.LimitPerSecond = scale(r.LimitPerSecond)
+	rule.LimitPerMinute = scale(r.LimitPerMinute)
+	rule.BurstSize = scale(r.BurstSize)
+	rule.MaxConcurrent = scale(r.MaxConcurrent)
+	return &rule
+}
+
+// overrideCache 配额覆盖的本地缓存（包括"没有覆盖"的结果），避免每个请求都访问Redis
+type overrideCache struct {
+	mu        sync.Mutex
+	entries   map[string]overrideEntry
+	lastSweep time.Time
+}
+
+// overrideEntry 缓存项
+type overrideEntry struct {
+	override *Override
+	expireAt time.Time
+}
+
+// get 获取未过期的缓存项
+func (c *overrideCache) get(key string, now time.Time) (*Override, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expireAt) {
+		return nil, false
+	}
+	return entry.override, true
+}
+
+// set 写入缓存项，并顺便清理过期的缓存项
+func (c *overrideCache) set(key string, override *Override, expireAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]overrideEntry)
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= localSweepInterval {
+		c.lastSweep = now
+		for k, entry := range c.entries {
+			if now.After(entry.expireAt) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	c.entries[key] = overrideEntry{override: override, expireAt: expireAt}
+}
+
+// invalidate 删除缓存项（只影响当前实例）
+func (c *overrideCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
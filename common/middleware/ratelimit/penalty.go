@@ -0,0 +1,383 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"working-project/common/logging"
+)
+
+// 封禁相关的Redis key
+const (
+	banKeyPrefix     = "ratelimit:ban:"     // 封禁记录，完整key为 ratelimit:ban:<限流key>，值为封禁原因
+	banIndexKey      = "ratelimit:bans"     // 封禁索引（有序集合），成员为限流key，score为到期时间(毫秒)，用于列出封禁；每次封禁时清理已到期的成员
+	penaltyKeyPrefix = "ratelimit:penalty:" // 被拒绝次数，完整key为 ratelimit:penalty:<限流key>
+)
+
+// BanReasonPenaltyBox 自动封禁的原因
+const BanReasonPenaltyBox = "penalty_box"
+
+// penaltyScript 记录一次被拒绝，达到阈值时封禁
+// KEYS[1]: 被拒绝次数的计数key
+// KEYS[2]: 封禁记录key
+// KEYS[3]: 封禁索引key
+// ARGV[1]: 阈值(threshold)
+// ARGV[2]: 统计窗口(毫秒)
+// ARGV[3]: 封禁时长(毫秒)
+// ARGV[4]: 当前时间(毫秒)
+// ARGV[5]: 限流key
+// ARGV[6]: 封禁原因
+var penaltyScript = redis.NewScript(`
+	local threshold = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local duration = tonumber(ARGV[3])
+	local now = tonumber(ARGV[4])
+
+	local count = redis.call('INCR', KEYS[1])
+	if count == 1 then
+		redis.call('PEXPIRE', KEYS[1], window)
+	end
+	if count < threshold then
+		return 0
+	end
+
+	redis.call('DEL', KEYS[1])
+	redis.call('SET', KEYS[2], ARGV[6], 'PX', duration)
+	redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', '(' .. now)
+	redis.call('ZADD', KEYS[3], now + duration, ARGV[5])
+	return 1
+`)
+
+// Ban 一条封禁记录
+type Ban struct {
+	Key      string    // 限流key
+	Reason   string    // 封禁原因，自动封禁为penalty_box
+	ExpireAt time.Time // 到期时间
+}
+
+// banStore 封禁记录的存储
+type banStore interface {
+	// banned 查询是否处于封禁中，返回到期时间
+	banned(ctx context.Context, key string, now time.Time) (time.Time, bool, error)
+
+	// penalize 记录一次被拒绝，达到阈值时封禁，返回是否触发了封禁
+	penalize(ctx context.Context, key string, threshold int, window, duration time.Duration, now time.Time) (bool, error)
+
+	ban(ctx context.Context, key, reason string, duration time.Duration, now time.Time) error
+	lift(ctx context.Context, key string) error
+	list(ctx context.Context, now time.Time) ([]Ban, error)
+}
+
+// redisBanStore 封禁记录存储在Redis中，所有实例共同生效
+type redisBanStore struct {
+	redis *redis.Client
+}
+
+func (s *redisBanStore) banned(ctx context.Context, key string, now time.Time) (time.Time, bool, error) {
+	ttl, err := s.redis.PTTL(ctx, banKeyPrefix+key).Result()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	// key不存在时为-2，没有过期时间时为-1（不会出现）
+	if ttl <= 0 {
+		return time.Time{}, false, nil
+	}
+	return now.Add(ttl), true, nil
+}
+
+func (s *redisBanStore) penalize(
+	ctx context.Context,
+	key string,
+	threshold int,
+	window time.Duration,
+	duration time.Duration,
+	now time.Time,
+) (bool, error) {
+	banned, err := penaltyScript.Run(
+		ctx,
+		s.redis,
+		[]string{penaltyKeyPrefix + key, banKeyPrefix + key, banIndexKey},
+		threshold,
+		window.Milliseconds(),
+		duration.Milliseconds(),
+		now.UnixMilli(),
+		key,
+		BanReasonPenaltyBox,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return banned == 1, nil
+}
+
+func (s *redisBanStore) ban(ctx context.Context, key, reason string, duration time.Duration, now time.Time) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, banKeyPrefix+key, reason, duration)
+		pipe.ZRemRangeByScore(ctx, banIndexKey, "-inf", fmt.Sprintf("(%d", now.UnixMilli()))
+		pipe.ZAdd(ctx, banIndexKey, redis.Z{Score: float64(now.Add(duration).UnixMilli()), Member: key})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+func (s *redisBanStore) lift(ctx context.Context, key string) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, banKeyPrefix+key, penaltyKeyPrefix+key)
+		pipe.ZRem(ctx, banIndexKey, key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+func (s *redisBanStore) list(ctx context.Context, now time.Time) ([]Ban, error) {
+	// 先清理已到期的索引
+	min := fmt.Sprintf("(%d", now.UnixMilli())
+	if err := s.redis.ZRemRangeByScore(ctx, banIndexKey, "-inf", min).Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	members, err := s.redis.ZRangeWithScores(ctx, banIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(members))
+	for _, z := range members {
+		keys = append(keys, banKeyPrefix+fmt.Sprint(z.Member))
+	}
+	reasons, err := s.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+
+	bans := make([]Ban, 0, len(members))
+	for i, z := range members {
+		// 封禁记录已被删除（如直接删除了Redis key）
+		if reasons[i] == nil {
+			continue
+		}
+		bans = append(bans, Ban{
+			Key:      fmt.Sprint(z.Member),
+			Reason:   fmt.Sprint(reasons[i]),
+			ExpireAt: time.UnixMilli(int64(z.Score)),
+		})
+	}
+	return bans, nil
+}
+
+// localBanStore 封禁记录存储在进程内，只在当前实例生效
+type localBanStore struct {
+	mu         sync.Mutex
+	bans       map[string]Ban
+	rejections map[string]localRejections
+}
+
+// localRejections 进程内的被拒绝次数
+type localRejections struct {
+	count    int
+	expireAt time.Time
+}
+
+func newLocalBanStore() *localBanStore {
+	return &localBanStore{
+		bans:       make(map[string]Ban),
+		rejections: make(map[string]localRejections),
+	}
+}
+
+func (s *localBanStore) banned(ctx context.Context, key string, now time.Time) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ban, ok := s.bans[key]
+	if !ok || !now.Before(ban.ExpireAt) {
+		return time.Time{}, false, nil
+	}
+	return ban.ExpireAt, true, nil
+}
+
+func (s *localBanStore) penalize(
+	ctx context.Context,
+	key string,
+	threshold int,
+	window time.Duration,
+	duration time.Duration,
+	now time.Time,
+) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.rejections[key]
+	if !now.Before(r.expireAt) {
+		r = localRejections{expireAt: now.Add(window)}
+	}
+	r.count++
+	if r.count < threshold {
+		s.rejections[key] = r
+		return false, nil
+	}
+
+	delete(s.rejections, key)
+	s.bans[key] = Ban{Key: key, Reason: BanReasonPenaltyBox, ExpireAt: now.Add(duration)}
+	return true, nil
+}
+
+func (s *localBanStore) ban(ctx context.Context, key, reason string, duration time.Duration, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bans[key] = Ban{Key: key, Reason: reason, ExpireAt: now.Add(duration)}
+	return nil
+}
+
+func (s *localBanStore) lift(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.bans, key)
+	delete(s.rejections, key)
+	return nil
+}
+
+func (s *localBanStore) list(ctx context.Context, now time.Time) ([]Ban, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bans := make([]Ban, 0, len(s.bans))
+	for key, ban := range s.bans {
+		if !now.Before(ban.ExpireAt) {
+			delete(s.bans, key)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	for key, r := range s.rejections {
+		if !now.Before(r.expireAt) {
+			delete(s.rejections, key)
+		}
+	}
+	return bans, nil
+}
+
+// banStore 获取封禁记录的存储，未通过NewLimiter创建时按需创建进程内存储
+func (l *Limiter) banStore() banStore {
+	l.mu.RLock()
+	store := l.bans
+	l.mu.RUnlock()
+	if store != nil {
+		return store
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bans == nil {
+		l.bans = newLocalBanStore()
+	}
+	return l.bans
+}
+
+// checkBan 查询限流key是否处于封禁中
+// 与限流检查一样经过熔断器并受check_timeout限制；查询失败（如Redis不可用）时按没有封禁处理，不影响限流本身
+func (l *Limiter) checkBan(ctx context.Context, key string, now time.Time) (time.Time, bool) {
+	var expireAt time.Time
+	var banned bool
+	err := l.guardBans(ctx, func(store banStore) error {
+		var err error
+		expireAt, banned, err = store.banned(ctx, key, now)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expireAt, banned
+}
+
+// guardBans 访问封禁记录的存储，Redis存储通过熔断器访问（熔断器打开时直接返回ErrCircuitOpen）
+func (l *Limiter) guardBans(ctx context.Context, fn func(store banStore) error) error {
+	store := l.banStore()
+	if _, ok := store.(*redisBanStore); !ok {
+		return fn(store)
+	}
+	return l.guardRedis(ctx, func() error {
+		return fn(store)
+	})
+}
+
+// penalize 记录一次被拒绝，window内达到threshold次时自动封禁
+func (l *Limiter) penalize(ctx context.Context, config *Config, key string, now time.Time) {
+	box := config.PenaltyBox
+	if !box.Enabled || box.Threshold <= 0 {
+		return
+	}
+
+	var banned bool
+	err := l.guardBans(ctx, func(store banStore) error {
+		start := time.Now()
+		var err error
+		banned, err = store.penalize(ctx, key, box.Threshold, box.GetWindow(), box.GetBanDuration(), now)
+		if _, ok := store.(*redisBanStore); ok {
+			l.metrics.observeScript("penalty", time.Since(start))
+		}
+		return err
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+	if err != nil {
+		l.log().WarnContext(ctx, "ratelimit: record penalty failed",
+			logging.Err(err), logging.KeyHash(key), logging.RequestID(ctx))
+		return
+	}
+	if banned {
+		l.log().WarnContext(ctx, "ratelimit: key banned by penalty box",
+			logging.KeyHash(key), logging.RequestID(ctx),
+			slog.Int("rejections", box.Threshold), slog.Duration("duration", box.GetBanDuration()))
+	}
+}
+
+// Ban 手动封禁限流key（如运营处理恶意账号），需要启用penalty_box才会生效
+// reason为空时记为manual
+func (l *Limiter) Ban(ctx context.Context, key, reason string, duration time.Duration) error {
+	if key == "" || duration <= 0 {
+		return ErrInvalidConfig
+	}
+	if reason == "" {
+		reason = "manual"
+	}
+	return l.banStore().ban(ctx, key, reason, duration, time.Now())
+}
+
+// LiftBan 解除限流key的封禁，同时清空其被拒绝次数
+func (l *Limiter) LiftBan(ctx context.Context, key string) error {
+	return l.banStore().lift(ctx, key)
+}
+
+// ListBans 列出所有未到期的封禁，按到期时间排序
+func (l *Limiter) ListBans(ctx context.Context) ([]Ban, error) {
+	bans, err := l.banStore().list(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if !bans[i].ExpireAt.Equal(bans[j].ExpireAt) {
+			return bans[i].ExpireAt.Before(bans[j].ExpireAt)
+		}
+		return bans[i].Key < bans[j].Key
+	})
+	return bans, nil
+}
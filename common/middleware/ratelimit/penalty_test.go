@@ -0,0 +1,229 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/cast"
+
+	"working-project/common/logging"
+)
+
+// penaltyTestConfig 黑白名单和封禁测试使用的配置
+func penaltyTestConfig(backend string) *Config {
+	return &Config{
+		Enabled:   true,
+		Backend:   backend,
+		Allowlist: []string{"10.0.0.0/8"},
+		Denylist:  []string{"203.0.113.0/24", "10.0.0.1"},
+		PenaltyBox: PenaltyBoxConfig{
+			Enabled:     true,
+			Threshold:   3,
+			Window:      time.Minute,
+			BanDuration: time.Hour,
+		},
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 1, BurstSize: 1},
+		},
+	}
+}
+
+// TestLimiter_Check_AllowDenyList 测试IP黑白名单
+func TestLimiter_Check_AllowDenyList(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(nil, penaltyTestConfig(BackendLocal))
+
+	tests := []struct {
+		name    string
+		req     *Request
+		want    int
+		wantErr error
+	}{
+		{name: "allowlist is not limited", req: &Request{Key: "10.1.2.3", Path: "/api/users"}, want: 5},
+		{name: "allowlist wins over denylist", req: &Request{Key: "10.0.0.1", Path: "/api/users"}, want: 5},
+		{name: "denylist", req: &Request{Key: "203.0.113.7", Path: "/api/users"}, want: 0, wantErr: ErrDenied},
+		{name: "IP field takes precedence over key", req: &Request{Key: "user:1", IP: "203.0.113.8", Path: "/api/users"}, want: 0, wantErr: ErrDenied},
+		{name: "other IPs are limited", req: &Request{Key: "192.0.2.1", Path: "/api/users"}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			for i := 0; i < 5; i++ {
+				result, err := limiter.Check(context.Background(), tt.req)
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				if result.Allowed {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("expected %d allowed requests, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestLimiter_PenaltyBox 测试多次被拒绝后自动封禁，以及列出、解除封禁
+func TestLimiter_PenaltyBox(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []string{BackendRedis, BackendLocal} {
+		backend := backend
+		t.Run(backend, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := NewLimiter(setupTestRedis(t), penaltyTestConfig(backend))
+			ctx := context.Background()
+			req := &Request{Key: "192.0.2.1", Path: "/api/users"}
+
+			// 1次放行 + 3次拒绝后封禁
+			countAllowed(t, limiter, req, 4)
+
+			result, err := limiter.Check(ctx, &Request{Key: "192.0.2.1", Path: "/api/other"})
+			if !errors.Is(err, ErrBanned) || !errors.Is(err, ErrRateLimitExceeded) {
+				t.Fatalf("expected ErrBanned, got %v", err)
+			}
+			if !result.Banned || result.RetryAfter(time.Now()) <= 0 {
+				t.Errorf("expected banned result with retry after, got %+v", result)
+			}
+
+			bans, err := limiter.ListBans(ctx)
+			if err != nil {
+				t.Fatalf("expected no error on ListBans, got %v", err)
+			}
+			if len(bans) != 1 || cast.ToString(bans[0].Key) != "192.0.2.1" || cast.ToString(bans[0].Reason) != BanReasonPenaltyBox {
+				t.Fatalf("expected 1 penalty box ban, got %+v", bans)
+			}
+
+			// 手动封禁
+			if err := limiter.Ban(ctx, "user:9", "", time.Minute); err != nil {
+				t.Fatalf("expected no error on Ban, got %v", err)
+			}
+			if _, err := limiter.Check(ctx, &Request{Key: "user:9", Path: "/public"}); !errors.Is(err, ErrBanned) {
+				t.Errorf("expected manual ban, got %v", err)
+			}
+			bans, _ = limiter.ListBans(ctx)
+			if len(bans) != 2 || cast.ToString(bans[0].Reason) != "manual" {
+				t.Errorf("expected manual ban to expire first, got %+v", bans)
+			}
+
+			// 解除封禁
+			if err := limiter.LiftBan(ctx, "192.0.2.1"); err != nil {
+				t.Fatalf("expected no error on LiftBan, got %v", err)
+			}
+			if _, err := limiter.Check(ctx, &Request{Key: "192.0.2.1", Path: "/api/other"}); err != nil {
+				t.Errorf("expected no error after LiftBan, got %v", err)
+			}
+			bans, _ = limiter.ListBans(ctx)
+			if len(bans) != 1 {
+				t.Errorf("expected 1 ban after LiftBan, got %+v", bans)
+			}
+		})
+	}
+}
+
+// TestRedisBanStore_PruneIndex 测试封禁时清理封禁索引中已到期的成员，索引不会无限增长
+func TestRedisBanStore_PruneIndex(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	store := &redisBanStore{redis: rdb}
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := store.ban(ctx, "a", "manual", time.Second, now); err != nil {
+		t.Fatalf("expected no error on ban, got %v", err)
+	}
+	if err := store.ban(ctx, "b", "manual", time.Minute, now.Add(2*time.Second)); err != nil {
+		t.Fatalf("expected no error on ban, got %v", err)
+	}
+	if members, _ := rdb.ZRange(ctx, banIndexKey, 0, -1).Result(); len(members) != 1 || members[0] != "b" {
+		t.Errorf("expected expired ban to be pruned on ban, got %v", members)
+	}
+
+	if _, err := store.penalize(ctx, "c", 1, time.Minute, time.Minute, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("expected no error on penalize, got %v", err)
+	}
+	if members, _ := rdb.ZRange(ctx, banIndexKey, 0, -1).Result(); len(members) != 1 || members[0] != "c" {
+		t.Errorf("expected expired ban to be pruned on penalty, got %v", members)
+	}
+}
+
+// TestLimiter_PenaltyBox_CheckTimeout 测试封禁查询经过熔断器并受check_timeout限制
+func TestLimiter_PenaltyBox_CheckTimeout(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewMetrics(nil)
+	config := penaltyTestConfig(BackendRedis)
+	config.CheckTimeout = 50 * time.Millisecond
+	limiter := NewLimiter(setupBlackholeRedis(t), config, WithMetrics(metrics), WithLogger(logging.Discard()))
+
+	start := time.Now()
+	if _, err := limiter.Check(context.Background(), &Request{Key: "192.0.2.1", Path: "/api/users"}); !errors.Is(err, ErrCheckTimeout) {
+		t.Fatalf("expected ErrCheckTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected check to give up after check_timeout, took %v", elapsed)
+	}
+	// 封禁查询和限流检查各计一次超时
+	if got := testutil.ToFloat64(metrics.redisErrors.WithLabelValues(redisErrorTimeout)); cast.ToInt(got) != 2 {
+		t.Errorf("expected 2 timeouts, got %v", got)
+	}
+}
+
+// TestMiddleware_AllowDenyList 测试中间件的黑名单和封禁响应
+func TestMiddleware_AllowDenyList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := NewLimiter(nil, penaltyTestConfig(BackendLocal))
+	r := gin.New()
+	r.Use(Middleware(limiter))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("203.0.113.1:1234"); cast.ToInt(w.Code) != http.StatusForbidden {
+		t.Errorf("expected status 403 for denylist, got %d", w.Code)
+	}
+
+	if err := limiter.Ban(context.Background(), "192.0.2.5", "abuse", time.Minute); err != nil {
+		t.Fatalf("expected no error on Ban, got %v", err)
+	}
+	w := serve("192.0.2.5:1234")
+	if cast.ToInt(w.Code) != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 for banned key, got %d", w.Code)
+	}
+	if retryAfter := cast.ToInt(w.Header().Get("Retry-After")); retryAfter < 59 || retryAfter > 60 {
+		t.Errorf("expected Retry-After about 60, got %d", retryAfter)
+	}
+}
+
+// TestConfig_Validate_AllowDenyList 测试黑白名单校验
+func TestConfig_Validate_AllowDenyList(t *testing.T) {
+	config := penaltyTestConfig(BackendLocal)
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	config.Denylist = append(config.Denylist, "not-an-ip")
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
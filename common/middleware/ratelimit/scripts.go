@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"working-project/common/logging"
+)
+
+// 预加载Lua脚本相关的参数
+const (
+	scriptLoadTimeout    = 3 * time.Second // 一次预加载的超时时间
+	scriptReloadInterval = time.Second     // 两次预加载的最小间隔，避免连接池扩容时反复加载
+)
+
+// luaScripts 限流器用到的所有Lua脚本
+// 脚本都是包级变量，SHA只在初始化时计算一次；执行时统一用Script.Run，先发EVALSHA，
+// Redis返回NOSCRIPT（重启、SCRIPT FLUSH、故障切换后脚本缓存为空）时再用EVAL发送脚本内容，
+// EVAL同时会把脚本写回缓存，调用方无需处理
+var luaScripts = []*redis.Script{
+	tokenBucketScript,
+	slidingWindowLogScript,
+	slidingWindowCounterScript,
+	fixedWindowScript,
+	gcraScript,
+	concurrencyAcquireScript,
+	concurrencyRenewScript,
+	penaltyScript,
+}
+
+// loadScripts 通过SCRIPT LOAD把所有脚本写入Redis的脚本缓存，之后的EVALSHA不会再遇到NOSCRIPT
+func loadScripts(ctx context.Context, rdb *redis.Client) error {
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, script := range luaScripts {
+			script.Load(ctx, pipe)
+		}
+		return nil
+	})
+	return err
+}
+
+// scriptPreloaders 已安装预加载钩子的Redis客户端，多个Limiter共用一个客户端时只安装一次
+// 使用该客户端的Limiter都Close后删除
+var (
+	scriptPreloadersMu sync.Mutex
+	scriptPreloaders   = make(map[*redis.Client]*scriptPreloader)
+)
+
+// scriptPreloader 在创建Limiter和客户端建立新连接时预加载Lua脚本
+// 新建连接通常意味着Redis重启或发生了故障切换，脚本缓存可能已经清空，
+// 提前加载可以避免之后每个脚本的第一次执行都多一次NOSCRIPT往返
+type scriptPreloader struct {
+	rdb     *redis.Client
+	logger  logging.Logger
+	refs    int // 使用该预加载器的Limiter数，由scriptPreloadersMu保护
+	closed  atomic.Bool
+	loading atomic.Bool
+	last    atomic.Int64 // 最近一次开始加载的时间(纳秒)
+}
+
+// preloadScripts 为Redis客户端安装预加载钩子，并在后台加载一次脚本
+// 加载失败只记录日志，执行脚本时会退回EVAL；rdb为nil时不预加载，返回nil
+func preloadScripts(rdb *redis.Client, logger logging.Logger) *scriptPreloader {
+	if rdb == nil {
+		return nil
+	}
+
+	scriptPreloadersMu.Lock()
+	p, ok := scriptPreloaders[rdb]
+	if !ok {
+		p = &scriptPreloader{rdb: rdb, logger: logger}
+		scriptPreloaders[rdb] = p
+		rdb.AddHook(p)
+	}
+	p.refs++
+	scriptPreloadersMu.Unlock()
+
+	p.last.Store(0)
+	p.reload()
+	return p
+}
+
+// release Limiter关闭时调用，最后一个使用者释放后删除预加载器
+// go-redis不能移除钩子，已安装的钩子之后不再加载脚本
+func (p *scriptPreloader) release() {
+	scriptPreloadersMu.Lock()
+	defer scriptPreloadersMu.Unlock()
+
+	p.refs--
+	if p.refs > 0 {
+		return
+	}
+	if scriptPreloaders[p.rdb] == p {
+		delete(scriptPreloaders, p.rdb)
+	}
+	p.closed.Store(true)
+}
+
+// reload 在后台加载脚本，已有加载在进行或距离上次加载不足scriptReloadInterval时跳过
+func (p *scriptPreloader) reload() {
+	if p.closed.Load() {
+		return
+	}
+	now := time.Now().UnixNano()
+	if now-p.last.Load() < int64(scriptReloadInterval) || !p.loading.CompareAndSwap(false, true) {
+		return
+	}
+	p.last.Store(now)
+
+	go func() {
+		defer p.loading.Store(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), scriptLoadTimeout)
+		defer cancel()
+		if err := loadScripts(ctx, p.rdb); err != nil {
+			logging.OrDefault(p.logger).WarnContext(ctx, "ratelimit: preload lua scripts failed", logging.Err(err))
+		}
+	}()
+}
+
+// DialHook 实现redis.Hook，建立新连接后重新加载脚本
+func (p *scriptPreloader) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err == nil {
+			p.reload()
+		}
+		return conn, err
+	}
+}
+
+// ProcessHook 实现redis.Hook
+func (p *scriptPreloader) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+// ProcessPipelineHook 实现redis.Hook
+func (p *scriptPreloader) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
@@ -0,0 +1,302 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"working-project/common/logging"
+)
+
+// waitScriptsLoaded 等待所有Lua脚本出现在Redis的脚本缓存中
+func waitScriptsLoaded(t *testing.T, rdb *redis.Client) {
+	t.Helper()
+
+	hashes := make([]string, 0, len(luaScripts))
+	for _, script := range luaScripts {
+		hashes = append(hashes, script.Hash())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		exists, err := rdb.ScriptExists(context.Background(), hashes...).Result()
+		if err != nil {
+			t.Fatalf("expected no error on SCRIPT EXISTS, got %v", err)
+		}
+		loaded := true
+		for _, ok := range exists {
+			loaded = loaded && ok
+		}
+		if loaded {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected all scripts to be loaded, got %v", exists)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestPreloadScripts 测试创建Limiter和建立新连接时预加载脚本，脚本缓存被清空后仍能正常限流
+func TestPreloadScripts(t *testing.T) {
+	t.Parallel()
+
+	client := setupTestRedis(t)
+	ctx := context.Background()
+	config := &Config{
+		Enabled: true,
+		Rules:   []RuleConfig{{Path: "/api/*", LimitPerMinute: 2, Algorithm: AlgorithmFixedWindow}},
+	}
+
+	limiter := NewLimiter(client, config, WithLogger(logging.Discard()))
+	waitScriptsLoaded(t, client)
+
+	// 多个Limiter共用一个客户端时只安装一次钩子
+	NewLimiter(client, config, WithLogger(logging.Discard()))
+	scriptPreloadersMu.Lock()
+	p := scriptPreloaders[client]
+	refs := p.refs
+	scriptPreloadersMu.Unlock()
+	if refs != 2 {
+		t.Errorf("expected 1 preloader shared by 2 limiters, got %d", refs)
+	}
+
+	// 脚本缓存被清空（如Redis重启）后退回EVAL
+	if err := client.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatalf("expected no error on SCRIPT FLUSH, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		allowed, _, _, err := limiter.Allow(ctx, "192.0.2.1", "/api/users")
+		if err != nil && !errors.Is(err, ErrRateLimitExceeded) {
+			t.Fatalf("expected no redis error after SCRIPT FLUSH, got %v", err)
+		}
+		if allowed != (i < 2) {
+			t.Errorf("request %d: expected allowed=%v, got %v", i, i < 2, allowed)
+		}
+	}
+
+	// 建立新连接后重新加载
+	if err := client.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatalf("expected no error on SCRIPT FLUSH, got %v", err)
+	}
+	p.last.Store(0)
+	dial := p.DialHook(func(context.Context, string, string) (net.Conn, error) {
+		return nil, nil
+	})
+	if _, err := dial(ctx, "tcp", "127.0.0.1:6379"); err != nil {
+		t.Fatalf("expected no error on dial, got %v", err)
+	}
+	waitScriptsLoaded(t, client)
+}
+
+// TestPreloadScripts_WithStore 测试在WithStore的Redis存储使用的客户端上预加载，Close后删除预加载器
+func TestPreloadScripts_WithStore(t *testing.T) {
+	t.Parallel()
+
+	client := setupTestRedis(t)
+	config := &Config{
+		Enabled: true,
+		Rules:   []RuleConfig{{Path: "/api/*", LimitPerMinute: 2, Algorithm: AlgorithmFixedWindow}},
+	}
+
+	limiter := NewLimiter(nil, config, WithStore(NewRedisStore(client)), WithLogger(logging.Discard()))
+	waitScriptsLoaded(t, client)
+	if allowed, _, _, err := limiter.Allow(context.Background(), "192.0.2.1", "/api/users"); err != nil || !allowed {
+		t.Fatalf("expected request to be allowed, got allowed=%v err=%v", allowed, err)
+	}
+
+	if err := limiter.Close(); err != nil {
+		t.Fatalf("expected no error on Close, got %v", err)
+	}
+	scriptPreloadersMu.Lock()
+	_, ok := scriptPreloaders[client]
+	scriptPreloadersMu.Unlock()
+	if ok {
+		t.Errorf("expected preloader to be removed after Close")
+	}
+
+	// 没有客户端的Redis存储不预加载
+	if l := NewLimiter(nil, config, WithStore(NewRedisStore(nil)), WithLogger(logging.Discard())); l.preloader != nil {
+		t.Errorf("expected no preloader without a client")
+	}
+}
+
+// startRESPStub 启动一个说RESP协议的Redis替身
+// 只实现执行脚本需要的命令，固定返回{1, 9, 0}，用于在不受Lua解释器开销影响的情况下
+// 比较客户端发送EVAL/EVALSHA的开销
+func startRESPStub(tb testing.TB) string {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("expected no error on net.Listen, got %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	var mu sync.Mutex
+	scripts := make(map[string]bool)
+	load := func(src string) string {
+		sum := sha1.Sum([]byte(src))
+		sha := hex.EncodeToString(sum[:])
+		mu.Lock()
+		scripts[sha] = true
+		mu.Unlock()
+		return sha
+	}
+	loaded := func(sha string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return scripts[strings.ToLower(sha)]
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				w := bufio.NewWriter(conn)
+				for {
+					args, err := readRESPCommand(r)
+					if err != nil {
+						return
+					}
+					switch strings.ToUpper(args[0]) {
+					case "HELLO":
+						// 按不支持RESP3的Redis处理，客户端会退回RESP2
+						w.WriteString("-ERR unknown command 'HELLO'\r\n")
+					case "EVAL":
+						load(args[1])
+						w.WriteString("*3\r\n:1\r\n:9\r\n:0\r\n")
+					case "EVALSHA":
+						if !loaded(args[1]) {
+							w.WriteString("-NOSCRIPT No matching script. Please use EVAL.\r\n")
+							break
+						}
+						w.WriteString("*3\r\n:1\r\n:9\r\n:0\r\n")
+					case "SCRIPT":
+						sha := load(args[2])
+						fmt.Fprintf(w, "$%d\r\n%s\r\n", len(sha), sha)
+					default:
+						w.WriteString("+OK\r\n")
+					}
+					if r.Buffered() == 0 {
+						if err := w.Flush(); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// readRESPCommand 读取一条RESP数组格式的命令
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected line %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// BenchmarkTokenBucketScript 对比执行令牌桶脚本的几种方式
+//   - new_script: 每次请求调用redis.NewScript（重新计算SHA）再Run
+//   - eval: 每次请求用EVAL发送完整的脚本内容
+//   - preloaded: 使用包级脚本，预加载后只发送EVALSHA
+func BenchmarkTokenBucketScript(b *testing.B) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("expected no error on miniredis.Run, got %v", err)
+	}
+	b.Cleanup(mr.Close)
+
+	backends := []struct {
+		name string
+		addr string
+	}{
+		{"miniredis", mr.Addr()},
+		{"resp", startRESPStub(b)},
+	}
+
+	ctx := context.Background()
+	keys := []string{"ratelimit:bench"}
+	modes := []struct {
+		name string
+		run  func(rdb *redis.Client) error
+	}{
+		{"new_script", func(rdb *redis.Client) error {
+			return redis.NewScript(tokenBucketLua).Run(ctx, rdb, keys, 1000000, 1000000, 1, time.Now().Unix(), 60, 1).Err()
+		}},
+		{"eval", func(rdb *redis.Client) error {
+			return tokenBucketScript.Eval(ctx, rdb, keys, 1000000, 1000000, 1, time.Now().Unix(), 60, 1).Err()
+		}},
+		{"preloaded", func(rdb *redis.Client) error {
+			return tokenBucketScript.Run(ctx, rdb, keys, 1000000, 1000000, 1, time.Now().Unix(), 60, 1).Err()
+		}},
+	}
+
+	for _, backend := range backends {
+		for _, mode := range modes {
+			b.Run(backend.name+"/"+mode.name, func(b *testing.B) {
+				rdb := redis.NewClient(&redis.Options{Addr: backend.addr})
+				defer rdb.Close()
+				if err := loadScripts(ctx, rdb); err != nil {
+					b.Fatalf("expected no error on loadScripts, got %v", err)
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := mode.run(rdb); err != nil {
+						b.Fatalf("expected no error, got %v", err)
+					}
+				}
+			})
+		}
+	}
+}
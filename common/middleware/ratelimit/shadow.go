@@ -0,0 +1,257 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"working-project/common/logging"
+)
+
+// shadowKeyPrefix shadow模式超限记录在Redis中的key前缀，完整key为 ratelimit:shadow:<规则标识>
+// 每个规则一个有序集合，成员为限流key，score为本应被拒绝的次数
+const shadowKeyPrefix = "ratelimit:shadow:"
+
+// localShadowMaxKeys 进程内记录每个规则最多保留的限流key数，超过后不再记录新key
+const localShadowMaxKeys = 10000
+
+// ShadowKey 单个限流key本应被拒绝的次数
+type ShadowKey struct {
+	Key   string
+	Count int64
+}
+
+// ShadowRule 单个shadow规则的超限汇总
+type ShadowRule struct {
+	Tier string      // 规则所属的套餐，全局规则为空
+	Path string      // 规则路径
+	Keys int64       // 本应被拒绝过的限流key数
+	Top  []ShadowKey // 本应被拒绝次数最多的限流key，按次数从多到少排序
+}
+
+// shadowRecorder shadow模式超限记录的存储
+type shadowRecorder interface {
+	record(ctx context.Context, id, key string, ttl time.Duration) error
+	top(ctx context.Context, id string, n int) (int64, []ShadowKey, error)
+	reset(ctx context.Context, ids []string) error
+}
+
+// redisShadowRecorder 记录在Redis中，多实例汇总到一起
+type redisShadowRecorder struct {
+	redis *redis.Client
+}
+
+func (r *redisShadowRecorder) record(ctx context.Context, id, key string, ttl time.Duration) error {
+	_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZIncrBy(ctx, shadowKeyPrefix+id, 1, key)
+		pipe.PExpire(ctx, shadowKeyPrefix+id, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+func (r *redisShadowRecorder) top(ctx context.Context, id string, n int) (int64, []ShadowKey, error) {
+	var card *redis.IntCmd
+	var members *redis.ZSliceCmd
+	_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		card = pipe.ZCard(ctx, shadowKeyPrefix+id)
+		members = pipe.ZRevRangeWithScores(ctx, shadowKeyPrefix+id, 0, int64(n-1))
+		return nil
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+
+	keys := make([]ShadowKey, 0, len(members.Val()))
+	for _, z := range members.Val() {
+		keys = append(keys, ShadowKey{Key: fmt.Sprint(z.Member), Count: int64(z.Score)})
+	}
+	return card.Val(), keys, nil
+}
+
+func (r *redisShadowRecorder) reset(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, shadowKeyPrefix+id)
+	}
+	if err := r.redis.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+// localShadowRecorder 记录在进程内，只包含当前实例的请求
+type localShadowRecorder struct {
+	mu    sync.Mutex
+	rules map[string]*localShadowRule
+}
+
+// localShadowRule 单个规则的进程内记录
+type localShadowRule struct {
+	counts   map[string]int64
+	expireAt time.Time
+}
+
+func newLocalShadowRecorder() *localShadowRecorder {
+	return &localShadowRecorder{rules: make(map[string]*localShadowRule)}
+}
+
+func (r *localShadowRecorder) record(ctx context.Context, id, key string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	rule, ok := r.rules[id]
+	if !ok || now.After(rule.expireAt) {
+		rule = &localShadowRule{counts: make(map[string]int64)}
+		r.rules[id] = rule
+	}
+	rule.expireAt = now.Add(ttl)
+
+	if _, ok := rule.counts[key]; ok || len(rule.counts) < localShadowMaxKeys {
+		rule.counts[key]++
+	}
+	return nil
+}
+
+func (r *localShadowRecorder) top(ctx context.Context, id string, n int) (int64, []ShadowKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rule, ok := r.rules[id]
+	if !ok || time.Now().After(rule.expireAt) {
+		return 0, nil, nil
+	}
+
+	keys := make([]ShadowKey, 0, len(rule.counts))
+	for key, count := range rule.counts {
+		keys = append(keys, ShadowKey{Key: key, Count: count})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return int64(len(rule.counts)), keys, nil
+}
+
+func (r *localShadowRecorder) reset(ctx context.Context, ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		delete(r.rules, id)
+	}
+	return nil
+}
+
+// recordShadow 记录一次shadow模式下本应被拒绝的请求
+// 记录失败不影响请求本身
+func (l *Limiter) recordShadow(ctx context.Context, config *Config, tier string, rule *RuleConfig, key string) {
+	if l.breaker.Open() {
+		return
+	}
+	if err := l.shadowRecorder().record(ctx, ruleID(tier, rule), key, config.GetShadowTTL()); err != nil {
+		l.log().WarnContext(ctx, "ratelimit: record shadow rejection failed",
+			logging.Err(err), logging.Rule(rule.Path), logging.KeyHash(key), logging.RequestID(ctx))
+	}
+}
+
+// shadowRecorder 获取shadow记录的存储，未通过NewLimiter创建时按需创建进程内存储
+func (l *Limiter) shadowRecorder() shadowRecorder {
+	l.mu.RLock()
+	recorder := l.shadow
+	l.mu.RUnlock()
+	if recorder != nil {
+		return recorder
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shadow == nil {
+		l.shadow = newLocalShadowRecorder()
+	}
+	return l.shadow
+}
+
+// shadowRuleRef 当前配置中的一个shadow规则
+type shadowRuleRef struct {
+	tier string
+	rule *RuleConfig
+}
+
+// shadowRules 列出当前配置中所有shadow模式的规则（包括套餐规则和默认规则）
+func shadowRules(config *Config) []shadowRuleRef {
+	var refs []shadowRuleRef
+	add := func(tier string, rules []RuleConfig, defaultRule *RuleConfig) {
+		for i := range rules {
+			if rules[i].GetMode() == ModeShadow {
+				refs = append(refs, shadowRuleRef{tier: tier, rule: &rules[i]})
+			}
+		}
+		if defaultRule != nil && defaultRule.GetMode() == ModeShadow {
+			refs = append(refs, shadowRuleRef{tier: tier, rule: defaultRule})
+		}
+	}
+
+	add("", config.Rules, config.DefaultRule)
+
+	tiers := make([]string, 0, len(config.Tiers))
+	for name := range config.Tiers {
+		tiers = append(tiers, name)
+	}
+	sort.Strings(tiers)
+	for _, name := range tiers {
+		add(name, config.Tiers[name].Rules, config.Tiers[name].DefaultRule)
+	}
+	return refs
+}
+
+// ShadowSummary 汇总当前配置中各shadow规则本应被拒绝的限流key，用于上线前调整限额
+// topN: 每个规则返回的限流key数，<=0时返回10个
+// 使用Redis时汇总所有实例的记录，进程内限流时只包含当前实例
+func (l *Limiter) ShadowSummary(ctx context.Context, topN int) ([]ShadowRule, error) {
+	if topN <= 0 {
+		topN = 10
+	}
+
+	config, _ := l.snapshot()
+	recorder := l.shadowRecorder()
+
+	refs := shadowRules(config)
+	summary := make([]ShadowRule, 0, len(refs))
+	for _, ref := range refs {
+		keys, top, err := recorder.top(ctx, ruleID(ref.tier, ref.rule), topN)
+		if err != nil {
+			return nil, err
+		}
+		summary = append(summary, ShadowRule{Tier: ref.tier, Path: ref.rule.Path, Keys: keys, Top: top})
+	}
+	return summary, nil
+}
+
+// ResetShadowSummary 清空当前配置中各shadow规则的超限记录（如调整限额后重新评估）
+func (l *Limiter) ResetShadowSummary(ctx context.Context) error {
+	config, _ := l.snapshot()
+
+	refs := shadowRules(config)
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ruleID(ref.tier, ref.rule))
+	}
+	return l.shadowRecorder().reset(ctx, ids)
+}
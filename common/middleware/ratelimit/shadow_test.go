@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+)
+
+// shadowTestConfig shadow模式测试使用的配置
+func shadowTestConfig(backend string) *Config {
+	return &Config{
+		Enabled: true,
+		Backend: backend,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 2, BurstSize: 2, Mode: ModeShadow},
+			{Path: "/report/*", MaxConcurrent: 1, Mode: ModeShadow},
+			{Path: "/login", LimitPerMinute: 1, BurstSize: 1},
+		},
+	}
+}
+
+// TestLimiter_Check_Shadow 测试shadow模式只记录不拒绝，并汇总本应被拒绝的key
+func TestLimiter_Check_Shadow(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []string{BackendRedis, BackendLocal} {
+		backend := backend
+		t.Run(backend, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := NewLimiter(setupTestRedis(t), shadowTestConfig(backend))
+			ctx := context.Background()
+
+			// shadow规则全部放行，超出部分标记为ShadowRejected
+			shadowRejected := 0
+			for i := 0; i < 5; i++ {
+				result, err := limiter.Check(ctx, &Request{Key: "heavy", Path: "/api/users"})
+				if err != nil {
+					t.Fatalf("expected no error in shadow mode, got %v", err)
+				}
+				if !cast.ToBool(result.Allowed) {
+					t.Fatalf("expected shadow rule to allow")
+				}
+				if result.ShadowRejected {
+					shadowRejected++
+				}
+			}
+			if shadowRejected != 3 {
+				t.Errorf("expected 3 shadow rejections, got %d", shadowRejected)
+			}
+			countAllowed(t, limiter, &Request{Key: "light", Path: "/api/users"}, 3)
+
+			// enforce规则照常拒绝
+			if got := countAllowed(t, limiter, &Request{Key: "heavy", Path: "/login"}, 3); got != 1 {
+				t.Errorf("expected enforce rule to allow 1 request, got %d", got)
+			}
+
+			summary, err := limiter.ShadowSummary(ctx, 10)
+			if err != nil {
+				t.Fatalf("expected no error on ShadowSummary, got %v", err)
+			}
+			if len(summary) != 2 {
+				t.Fatalf("expected 2 shadow rules, got %d", len(summary))
+			}
+			api := summary[0]
+			if cast.ToString(api.Path) != "/api/*" || api.Keys != 2 || len(api.Top) != 2 {
+				t.Fatalf("expected 2 keys for /api/*, got %+v", api)
+			}
+			if cast.ToString(api.Top[0].Key) != "heavy" || api.Top[0].Count != 3 {
+				t.Errorf("expected heavy to be rejected 3 times, got %+v", api.Top[0])
+			}
+			if cast.ToString(api.Top[1].Key) != "light" || api.Top[1].Count != 1 {
+				t.Errorf("expected light to be rejected once, got %+v", api.Top[1])
+			}
+
+			if err := limiter.ResetShadowSummary(ctx); err != nil {
+				t.Fatalf("expected no error on ResetShadowSummary, got %v", err)
+			}
+			summary, _ = limiter.ShadowSummary(ctx, 10)
+			if summary[0].Keys != 0 {
+				t.Errorf("expected summary to be reset, got %+v", summary[0])
+			}
+		})
+	}
+}
+
+// TestLimiter_Check_ShadowCountsAllWindows 测试shadow规则被第一个窗口拒绝后仍然计入其余窗口
+func TestLimiter_Check_ShadowCountsAllWindows(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerSecond: 1, LimitPerMinute: 3, Algorithm: AlgorithmFixedWindow, Mode: ModeShadow},
+		},
+	}
+	limiter := NewLimiter(nil, config)
+	req := &Request{Key: "ip", Path: "/api/users"}
+
+	rejectedBySecond := false
+	for i := 0; i < 5; i++ {
+		result, err := limiter.Check(context.Background(), req)
+		if err != nil || !result.Allowed {
+			t.Fatalf("expected shadow rule to allow, got %+v %v", result, err)
+		}
+		if result.ShadowRejected && result.Window == time.Second {
+			rejectedBySecond = true
+		}
+	}
+	if !rejectedBySecond {
+		t.Errorf("expected shadow rejection by the per-second window")
+	}
+
+	// 切换为enforce后，每分钟的配额已经被shadow期间的请求用完
+	enforced := *config
+	enforced.Rules = []RuleConfig{{Path: "/api/*", LimitPerMinute: 3, Algorithm: AlgorithmFixedWindow}}
+	if err := limiter.UpdateConfig(&enforced); err != nil {
+		t.Fatalf("expected no error on UpdateConfig, got %v", err)
+	}
+	if got := countAllowed(t, limiter, req, 1); got != 0 {
+		t.Errorf("expected per-minute window to be exhausted by shadow traffic, got %d allowed", got)
+	}
+}
+
+// TestLimiter_Acquire_Shadow 测试并发限制的shadow模式
+func TestLimiter_Acquire_Shadow(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(nil, shadowTestConfig(BackendLocal))
+	ctx := context.Background()
+	req := &Request{Key: "ip", Path: "/report/daily"}
+
+	first, err := limiter.Acquire(ctx, req)
+	if err != nil || first.ShadowRejected {
+		t.Fatalf("expected slot, got %+v %v", first, err)
+	}
+	second, err := limiter.Acquire(ctx, req)
+	if errors.Is(err, ErrConcurrencyLimitExceeded) || !second.ShadowRejected {
+		t.Errorf("expected shadow rejection without error, got %+v %v", second, err)
+	}
+	_ = second.Release(ctx)
+	_ = first.Release(ctx)
+
+	summary, _ := limiter.ShadowSummary(ctx, 1)
+	if cast.ToString(summary[1].Path) != "/report/*" || summary[1].Keys != 1 {
+		t.Errorf("expected 1 key for /report/*, got %+v", summary[1])
+	}
+}
+
+// TestMiddleware_Shadow 测试shadow模式的中间件不返回429，也不输出限流响应头
+func TestMiddleware_Shadow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(Middleware(NewLimiter(nil, shadowTestConfig(BackendLocal))))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/test", nil))
+		if cast.ToInt(w.Code) != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("expected no rate limit headers for shadow rule, got %s", w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowLogScript 滑动窗口日志Lua脚本
+// 使用有序集合(ZSET)记录窗口内每一次请求的时间戳
+// KEYS[1]: Redis key
+// ARGV[1]: 窗口内最大请求数(limit)
+// ARGV[2]: 窗口大小(毫秒)
+// ARGV[3]: 当前时间戳(毫秒)
+// ARGV[4]: 本次请求的唯一成员名前缀
+// ARGV[5]: 本次消耗的配额(cost)，每份配额占用一个成员
+var slidingWindowLogScript = redis.NewScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local member = ARGV[4]
+	local cost = tonumber(ARGV[5])
+
+	-- 清理窗口之外的请求记录
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+
+	-- 统计窗口内的请求数
+	local count = redis.call('ZCARD', key)
+
+	local allowed = 0
+	if count + cost <= limit then
+		for i = 1, cost do
+			redis.call('ZADD', key, now, member .. ':' .. i)
+		end
+		count = count + cost
+		allowed = 1
+	end
+	redis.call('PEXPIRE', key, window)
+
+	-- 最早的一条记录移出窗口时，配额恢复
+	local reset_after = 0
+	local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+	if oldest[2] then
+		reset_after = tonumber(oldest[2]) + window - now
+	end
+
+	return {allowed, limit - count, reset_after}
+`)
+
+// slidingWindowCounterScript 滑动窗口计数Lua脚本
+// 按上一个窗口的计数加权估算当前滑动窗口内的请求数
+// KEYS[1]: 当前窗口的计数key
+// KEYS[2]: 上一个窗口的计数key
+// ARGV[1]: 窗口内最大请求数(limit)
+// ARGV[2]: 窗口大小(毫秒)
+// ARGV[3]: 当前窗口已经过去的时间(毫秒)
+// ARGV[4]: 本次消耗的配额(cost)
+var slidingWindowCounterScript = redis.NewScript(`
+	local current_key = KEYS[1]
+	local previous_key = KEYS[2]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local elapsed = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+
+	local previous = tonumber(redis.call('GET', previous_key) or '0')
+	local current = tonumber(redis.call('GET', current_key) or '0')
+
+	-- 上一个窗口中仍处于滑动窗口内的部分按比例计入
+	local weight = (window - elapsed) / window
+	local estimated = previous * weight + current
+
+	local allowed = 0
+	if estimated + cost <= limit then
+		redis.call('INCRBY', current_key, cost)
+		-- 当前窗口的计数在下一个窗口中还要作为previous使用，所以保留2个窗口
+		redis.call('PEXPIRE', current_key, window * 2)
+		estimated = estimated + cost
+		allowed = 1
+	end
+
+	return {allowed, math.floor(limit - estimated), window - elapsed}
+`)
+
+// slidingWindowLog 滑动窗口日志算法
+// 精确限制任意period长度的时间窗口内的请求数，适合登录等严格接口
+// 每个请求占用一个ZSET成员，limit较大时内存开销较高
+type slidingWindowLog struct{}
+
+func (slidingWindowLog) allow(
+	ctx context.Context,
+	rdb redis.Scripter,
+	key string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
+) (bool, int, time.Time, error) {
+	// 成员名需要全局唯一，避免同一毫秒内的多个请求被ZADD合并
+	member := strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	allowed, remaining, resetAfter, err := runScript(
+		ctx,
+		rdb,
+		slidingWindowLogScript,
+		[]string{key + ":log"},
+		limit,
+		period.Milliseconds(),
+		now.UnixMilli(),
+		member,
+		cost,
+	)
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	return allowed, remaining, now.Add(time.Duration(resetAfter) * time.Millisecond), nil
+}
+
+// slidingWindowCounter 滑动窗口计数算法
+// 只存储当前和上一个固定窗口的计数，是滑动窗口日志的低内存近似
+type slidingWindowCounter struct{}
+
+func (slidingWindowCounter) allow(
+	ctx context.Context,
+	rdb redis.Scripter,
+	key string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
+) (bool, int, time.Time, error) {
+	window := period.Milliseconds()
+	nowMs := now.UnixMilli()
+	index := nowMs / window
+
+	allowed, remaining, resetAfter, err := runScript(
+		ctx,
+		rdb,
+		slidingWindowCounterScript,
+		[]string{
+			fmt.Sprintf("%s:swc:%d", key, index),
+			fmt.Sprintf("%s:swc:%d", key, index-1),
+		},
+		limit,
+		window,
+		nowMs%window,
+		cost,
+	)
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	return allowed, remaining, now.Add(time.Duration(resetAfter) * time.Millisecond), nil
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store 限流状态存储接口
+// Limiter通过Store执行具体的限流检查，可以是Redis（分布式）或进程内存（单实例）
+type Store interface {
+	// Allow 对key执行一次限流检查，有cost份配额则原子地消费cost份
+	// 参数:
+	//   - key: 限流key
+	//   - algorithm: 限流算法名称（见Algorithm*常量）
+	//   - limit: 每个period允许的请求数
+	//   - burst: 突发容量
+	//   - cost: 本次请求消耗的配额，>=1
+	//   - period: 时间周期
+	//   - now: 当前时间
+	//
+	// 返回: allowed(是否允许), remaining(剩余配额), resetTime(配额恢复时间), error
+	Allow(
+		ctx context.Context,
+		key string,
+		algorithm string,
+		limit int,
+		burst int,
+		cost int,
+		period time.Duration,
+		now time.Time,
+	) (bool, int, time.Time, error)
+}
+
+// RedisStore 基于Redis的分布式存储
+// 每种算法对应一段Lua脚本，多实例共享同一份限流状态
+type RedisStore struct {
+	redis *redis.Client
+}
+
+// NewRedisStore 创建Redis存储
+func NewRedisStore(redisClient *redis.Client) *RedisStore {
+	return &RedisStore{redis: redisClient}
+}
+
+// Client 返回存储使用的Redis客户端
+func (s *RedisStore) Client() *redis.Client {
+	return s.redis
+}
+
+// Allow 实现Store接口
+func (s *RedisStore) Allow(
+	ctx context.Context,
+	key string,
+	algorithm string,
+	limit int,
+	burst int,
+	cost int,
+	period time.Duration,
+	now time.Time,
+) (bool, int, time.Time, error) {
+	algo, err := getAlgorithm(algorithm)
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	return algo.allow(ctx, s.redis, key, limit, burst, cost, period, now)
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import "working-project/common/clientip"
+
+// ruleSet 编译后的全部规则：全局规则集、各套餐的规则集和IP黑白名单
+// 与Config一起在创建限流器和热加载时构建
+type ruleSet struct {
+	base  *ruleTrie
+	tiers map[string]*ruleTrie
+	allow clientip.PrefixList
+	deny  clientip.PrefixList
+}
+
+// newRuleSet 编译配置中的全部规则
+// 不合法的黑白名单条目会导致整个名单为空（请先通过Config.Validate校验）
+func newRuleSet(config *Config) *ruleSet {
+	s := &ruleSet{
+		base:  newRuleTrie(config.Rules),
+		tiers: make(map[string]*ruleTrie, len(config.Tiers)),
+	}
+	for name, tier := range config.Tiers {
+		s.tiers[name] = newRuleTrie(tier.Rules)
+	}
+	s.allow, _ = clientip.ParsePrefixList(config.Allowlist)
+	s.deny, _ = clientip.ParsePrefixList(config.Denylist)
+	return s
+}
+
+// match 按套餐查找匹配的规则
+// 查找顺序：套餐规则 > 全局规则 > 套餐default_rule > 全局default_rule
+// 返回匹配的规则及其所属的套餐（全局规则返回空字符串），都不匹配时返回nil
+func (s *ruleSet) match(config *Config, req *Request, tier string) (*RuleConfig, string) {
+	tierConfig, hasTier := config.Tiers[tier]
+	if hasTier {
+		if rule := s.tiers[tier].match(req); rule != nil {
+			return rule, tier
+		}
+	}
+
+	if rule := s.base.match(req); rule != nil {
+		return rule, ""
+	}
+
+	if hasTier && tierConfig.DefaultRule != nil {
+		return tierConfig.DefaultRule, tier
+	}
+	return config.DefaultRule, ""
+}
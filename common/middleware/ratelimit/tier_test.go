@@ -0,0 +1,261 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/cast"
+
+	"working-project/common/logging"
+)
+
+// tierTestConfig 套餐测试使用的配置
+func tierTestConfig() *Config {
+	return &Config{
+		Enabled: true,
+		Backend: BackendLocal,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 1, BurstSize: 1},
+			{Path: "/api/login", LimitPerMinute: 2, BurstSize: 2},
+		},
+		Tiers: map[string]TierConfig{
+			"pro": {
+				Rules: []RuleConfig{
+					{Path: "/api/*", LimitPerMinute: 3, BurstSize: 3},
+				},
+			},
+			"enterprise": {
+				DefaultRule: &RuleConfig{Path: "*", LimitPerMinute: 5, BurstSize: 5},
+			},
+		},
+	}
+}
+
+// countAllowed 统计连续请求中被允许的次数
+func countAllowed(t *testing.T, limiter *Limiter, req *Request, n int) int {
+	t.Helper()
+
+	count := 0
+	for i := 0; i < n; i++ {
+		result, err := limiter.Check(context.Background(), req)
+		if err != nil && !errors.Is(err, ErrRateLimitExceeded) {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if result.Allowed {
+			count++
+		}
+	}
+	return count
+}
+
+// TestLimiter_Check_Tiers 测试按套餐匹配规则
+func TestLimiter_Check_Tiers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		req  *Request
+		want int
+	}{
+		{
+			name: "no tier uses global rules",
+			req:  &Request{Key: "ip", Path: "/api/users"},
+			want: 1,
+		},
+		{
+			name: "tier rule overrides global rule",
+			req:  &Request{Key: "ip", Path: "/api/users", Tier: "pro"},
+			want: 3,
+		},
+		{
+			name: "tier rules are matched before global rules",
+			req:  &Request{Key: "ip", Path: "/api/login", Tier: "pro"},
+			want: 3,
+		},
+		{
+			name: "global rule is used when tier rule does not match",
+			req:  &Request{Key: "ip", Path: "/api/login", Tier: "enterprise"},
+			want: 2,
+		},
+		{
+			name: "tier default rule",
+			req:  &Request{Key: "ip", Path: "/public", Tier: "enterprise"},
+			want: 5,
+		},
+		{
+			name: "unknown tier uses global rules",
+			req:  &Request{Key: "ip", Path: "/api/users", Tier: "gold"},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewLimiter(nil, tierTestConfig())
+			if got := countAllowed(t, limiter, tt.req, 10); got != tt.want {
+				t.Errorf("expected %d allowed requests, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestLimiter_Check_TierSwitch 测试key切换套餐后按新套餐的限额重新计数
+func TestLimiter_Check_TierSwitch(t *testing.T) {
+	t.Parallel()
+
+	for _, scope := range []string{ScopePerPath, ScopePerRule, ScopePerRouteTemplate} {
+		scope := scope
+		t.Run(scope, func(t *testing.T) {
+			t.Parallel()
+
+			config := tierTestConfig()
+			config.Rules[0].Scope = scope
+			config.Tiers["pro"].Rules[0].Scope = scope
+			limiter := NewLimiter(nil, config)
+
+			req := &Request{Key: "ip", Path: "/api/users", Route: "/api/users"}
+			if got := countAllowed(t, limiter, req, 5); got != 1 {
+				t.Errorf("expected 1 allowed request without tier, got %d", got)
+			}
+			req.Tier = "pro"
+			if got := countAllowed(t, limiter, req, 5); got != 3 {
+				t.Errorf("expected 3 allowed requests after upgrading to pro, got %d", got)
+			}
+		})
+	}
+}
+
+// TestConfig_Validate_Tiers 测试套餐规则校验
+func TestConfig_Validate_Tiers(t *testing.T) {
+	config := tierTestConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	config.Tiers["pro"].Rules[0].LimitPerMinute = -1
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+// TestLimiter_Override 测试Redis中按key存储的配额覆盖
+func TestLimiter_Override(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := tierTestConfig()
+	config.Backend = BackendRedis
+	config.EnableOverrides = true
+	limiter := NewLimiter(rdb, config)
+	ctx := context.Background()
+
+	// 没有覆盖
+	override, err := limiter.GetOverride(ctx, "customer-1")
+	if err != nil || override != nil {
+		t.Fatalf("expected no override, got %v %v", override, err)
+	}
+
+	// 放大3倍
+	if err := limiter.SetOverride(ctx, "customer-1", &Override{Multiplier: 3}, 0); err != nil {
+		t.Fatalf("expected no error on SetOverride, got %v", err)
+	}
+	if got := countAllowed(t, limiter, &Request{Key: "customer-1", Path: "/api/users"}, 10); got != 3 {
+		t.Errorf("expected 3 allowed requests with multiplier, got %d", got)
+	}
+	if got := countAllowed(t, limiter, &Request{Key: "customer-2", Path: "/api/users"}, 10); got != 1 {
+		t.Errorf("expected other key to keep 1 allowed request, got %d", got)
+	}
+
+	// 指定套餐
+	if err := limiter.SetOverride(ctx, "customer-3", &Override{Tier: "enterprise"}, time.Hour); err != nil {
+		t.Fatalf("expected no error on SetOverride, got %v", err)
+	}
+	override, err = limiter.GetOverride(ctx, "customer-3")
+	if err != nil || override == nil {
+		t.Fatalf("expected override, got %v %v", override, err)
+	}
+	if cast.ToString(override.Tier) != "enterprise" {
+		t.Errorf("expected tier enterprise, got %s", override.Tier)
+	}
+	if ttl := rdb.TTL(ctx, overrideKeyPrefix+"customer-3").Val(); ttl <= 0 {
+		t.Errorf("expected override to expire, got ttl %v", ttl)
+	}
+	if got := countAllowed(t, limiter, &Request{Key: "customer-3", Path: "/public"}, 10); got != 5 {
+		t.Errorf("expected 5 allowed requests with enterprise tier, got %d", got)
+	}
+
+	// 删除覆盖后恢复默认配额
+	if err := limiter.DeleteOverride(ctx, "customer-3"); err != nil {
+		t.Fatalf("expected no error on DeleteOverride, got %v", err)
+	}
+	if got := countAllowed(t, limiter, &Request{Key: "customer-3", Path: "/public"}, 10); got != 10 {
+		t.Errorf("expected /public to be unlimited without tier, got %d allowed requests", got)
+	}
+
+	// 未知套餐
+	if err := limiter.SetOverride(ctx, "customer-4", &Override{Tier: "gold"}, 0); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for unknown tier, got %v", err)
+	}
+}
+
+// TestLimiter_Override_Disabled 测试未启用配额覆盖时不读取Redis
+func TestLimiter_Override_Disabled(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := tierTestConfig()
+	config.Backend = BackendRedis
+	limiter := NewLimiter(rdb, config)
+	ctx := context.Background()
+
+	if err := limiter.SetOverride(ctx, "customer", &Override{Multiplier: 3}, 0); err != nil {
+		t.Fatalf("expected no error on SetOverride, got %v", err)
+	}
+	if got := countAllowed(t, limiter, &Request{Key: "customer", Path: "/api/users"}, 10); got != 1 {
+		t.Errorf("expected override to be ignored, got %d allowed requests", got)
+	}
+
+	// 没有Redis时无法设置覆盖
+	if err := NewLimiter(nil, config).SetOverride(ctx, "customer", &Override{}, 0); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("expected ErrRedisUnavailable, got %v", err)
+	}
+}
+
+// TestLimiter_Override_CheckTimeout 测试覆盖查询经过熔断器和check_timeout，失败后短暂缓存
+func TestLimiter_Override_CheckTimeout(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewMetrics(nil)
+	config := tierTestConfig()
+	config.Backend = BackendRedis
+	config.EnableOverrides = true
+	config.CheckTimeout = 50 * time.Millisecond
+	limiter := NewLimiter(setupBlackholeRedis(t), config, WithMetrics(metrics), WithLogger(logging.Discard()))
+
+	req := &Request{Key: "customer-1", Path: "/api/users"}
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Check(context.Background(), req); !errors.Is(err, ErrCheckTimeout) {
+			t.Fatalf("expected ErrCheckTimeout, got %v", err)
+		}
+	}
+	// 第一次覆盖查询和两次限流检查各计一次超时，第二次检查使用缓存的查询失败结果
+	if got := testutil.ToFloat64(metrics.redisErrors.WithLabelValues(redisErrorTimeout)); cast.ToInt(got) != 3 {
+		t.Errorf("expected 3 timeouts, got %v", got)
+	}
+}
+
+// TestRuleConfig_Scaled 测试按倍数放大规则
+func TestRuleConfig_Scaled(t *testing.T) {
+	rule := &RuleConfig{Path: "/api/*", LimitPerSecond: 3, LimitPerMinute: 0, BurstSize: 5}
+
+	scaled := rule.scaled(1.5)
+	if cast.ToInt(scaled.LimitPerSecond) != 5 || cast.ToInt(scaled.LimitPerMinute) != 0 || cast.ToInt(scaled.BurstSize) != 8 {
+		t.Errorf("expected 5/0/8, got %d/%d/%d", scaled.LimitPerSecond, scaled.LimitPerMinute, scaled.BurstSize)
+	}
+	if cast.ToInt(rule.LimitPerSecond) != 3 {
+		t.Errorf("expected original rule to be unchanged, got %d", rule.LimitPerSecond)
+	}
+}
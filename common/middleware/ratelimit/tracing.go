@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName 限流器的instrumentation名称
+const tracerName = "working-project/common/middleware/ratelimit"
+
+// span属性名
+const (
+	attrPath      = "ratelimit.path"      // 请求路径
+	attrTier      = "ratelimit.tier"      // 请求所属的套餐
+	attrRule      = "ratelimit.rule"      // 匹配的规则，没有匹配规则时为none
+	attrDecision  = "ratelimit.decision"  // 限流决策，取值同ratelimit_decisions_total的decision标签
+	attrRemaining = "ratelimit.remaining" // 剩余配额
+	attrAlgorithm = "ratelimit.algorithm" // 限流算法
+	attrLimit     = "ratelimit.limit"     // 窗口限额
+	attrCost      = "ratelimit.cost"      // 本次消耗的配额
+	attrWindow    = "ratelimit.window"    // 窗口时长
+	attrAllowed   = "ratelimit.allowed"   // 窗口是否放行
+	attrFallback  = "ratelimit.fallback"  // 是否降级到了进程内存储
+)
+
+// WithTracerProvider 设置OpenTelemetry的TracerProvider，为限流检查和每次存储调用创建span
+// 未设置时使用otel.GetTracerProvider()（未注册全局provider时不记录）
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(l *Limiter) {
+		l.tracerProvider = provider
+	}
+}
+
+// startSpan 创建span
+func (l *Limiter) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	provider := l.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endCheckSpan 记录限流检查的规则和决策并结束span
+func endCheckSpan(span trace.Span, result *Result, err error) {
+	var rule *RuleConfig
+	if result != nil {
+		rule = result.Rule
+	}
+	decision := decisionOf(result, err)
+	span.SetAttributes(
+		attribute.String(attrRule, ruleLabel(rule)),
+		attribute.String(attrDecision, decision),
+	)
+	if result != nil {
+		span.SetAttributes(attribute.Int(attrRemaining, result.Remaining))
+	}
+	if decision == DecisionError {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startAllowSpan 创建单个窗口存储调用的span
+func (l *Limiter) startAllowSpan(
+	ctx context.Context,
+	algorithm string,
+	limit int,
+	cost int,
+	period time.Duration,
+) (context.Context, trace.Span) {
+	return l.startSpan(ctx, "ratelimit.allow",
+		attribute.String(attrAlgorithm, algorithm),
+		attribute.Int(attrLimit, limit),
+		attribute.Int(attrCost, cost),
+		attribute.String(attrWindow, period.String()),
+	)
+}
+
+// endAllowSpan 记录存储调用的结果并结束span
+func endAllowSpan(span trace.Span, allowed bool, remaining int, fallback bool, err error) {
+	span.SetAttributes(
+		attribute.Bool(attrAllowed, allowed),
+		attribute.Int(attrRemaining, remaining),
+		attribute.Bool(attrFallback, fallback),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttr 获取span的属性值，不存在时返回空字符串
+func spanAttr(span tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// setupTestTracer 创建记录到内存的TracerProvider
+func setupTestTracer(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+	return provider, exporter
+}
+
+// TestLimiter_Check_Tracing 测试限流检查的span及其规则、决策属性
+func TestLimiter_Check_Tracing(t *testing.T) {
+	t.Parallel()
+
+	provider, exporter := setupTestTracer(t)
+	config := &Config{
+		Enabled: true,
+		Rules: []RuleConfig{
+			{Path: "/api/*", LimitPerMinute: 1, BurstSize: 1},
+		},
+	}
+	limiter := NewLimiter(setupTestRedis(t), config, WithTracerProvider(provider))
+	ctx := context.Background()
+
+	if allowed, _, _, err := limiter.Allow(ctx, "user:1", "/api/users"); err != nil || !allowed {
+		t.Fatalf("expected first request to be allowed, got %v %v", allowed, err)
+	}
+	if _, err := limiter.Check(ctx, &Request{Key: "user:1", Path: "/api/users", Tier: "free"}); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Fatalf("expected ErrRateLimitExceeded, got %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	var checks, allows []tracetest.SpanStub
+	for _, span := range spans {
+		switch span.Name {
+		case "ratelimit.Check":
+			checks = append(checks, span)
+		case "ratelimit.allow":
+			allows = append(allows, span)
+		}
+	}
+	if len(checks) != 2 {
+		t.Fatalf("expected 2 check spans, got %d", len(checks))
+	}
+	// 每次检查一次存储调用
+	if len(allows) != 2 {
+		t.Fatalf("expected 2 allow spans, got %d", len(allows))
+	}
+
+	tests := []struct {
+		span     tracetest.SpanStub
+		decision string
+		tier     string
+	}{
+		{checks[0], DecisionAllowed, ""},
+		{checks[1], DecisionRejected, "free"},
+	}
+	for _, tt := range tests {
+		if got := spanAttr(tt.span, attrRule); cast.ToString(got) != "/api/*" {
+			t.Errorf("expected rule /api/*, got %s", got)
+		}
+		if got := spanAttr(tt.span, attrDecision); got != tt.decision {
+			t.Errorf("expected decision %s, got %s", tt.decision, got)
+		}
+		if got := spanAttr(tt.span, attrTier); got != tt.tier {
+			t.Errorf("expected tier %q, got %q", tt.tier, got)
+		}
+		if tt.span.Status.Code == codes.Error {
+			t.Errorf("expected rejection not to be a span error")
+		}
+	}
+
+	// 存储调用是检查的子span
+	for _, span := range allows {
+		if span.Parent.SpanID() != checks[0].SpanContext.SpanID() && span.Parent.SpanID() != checks[1].SpanContext.SpanID() {
+			t.Errorf("expected allow span to be a child of a check span")
+		}
+		if spanAttr(span, attrAlgorithm) != AlgorithmTokenBucket {
+			t.Errorf("expected algorithm %s, got %s", AlgorithmTokenBucket, spanAttr(span, attrAlgorithm))
+		}
+	}
+	if got := spanAttr(allows[1], attrAllowed); got != "false" {
+		t.Errorf("expected second request to be rejected by the store, got allowed=%s", got)
+	}
+}
+
+// TestLimiter_Check_TracingError 测试Redis不可用时span记录错误和降级
+func TestLimiter_Check_TracingError(t *testing.T) {
+	t.Parallel()
+
+	provider, exporter := setupTestTracer(t)
+	rdb := setupTestRedis(t)
+	_ = rdb.Close()
+	config := &Config{
+		Enabled:       true,
+		LocalFallback: true,
+		Rules:         []RuleConfig{{Path: "/api/*", LimitPerMinute: 10, BurstSize: 10}},
+	}
+	limiter := NewLimiter(rdb, config, WithTracerProvider(provider))
+
+	if _, err := limiter.Check(context.Background(), &Request{Key: "user:1", Path: "/api/users"}); err != nil {
+		t.Fatalf("expected fallback to succeed, got %v", err)
+	}
+
+	for _, span := range exporter.GetSpans() {
+		if span.Name != "ratelimit.allow" {
+			continue
+		}
+		if spanAttr(span, attrFallback) != "true" {
+			t.Errorf("expected fallback attribute, got %v", span.Attributes)
+		}
+		if len(span.Events) == 0 {
+			t.Errorf("expected Redis error to be recorded")
+		}
+	}
+
+	// 没有降级时检查出错
+	limiter = NewLimiter(rdb, &Config{Enabled: true, Rules: config.Rules}, WithTracerProvider(provider))
+	exporter.Reset()
+	if _, err := limiter.Check(context.Background(), &Request{Key: "user:1", Path: "/api/users"}); err == nil {
+		t.Fatalf("expected error without fallback")
+	}
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "ratelimit.Check" {
+			if span.Status.Code != codes.Error || spanAttr(span, attrDecision) != DecisionError {
+				t.Errorf("expected error span, got %v %v", span.Status, span.Attributes)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"path"
+	"strings"
+)
+
+// segmentType 路径模式中路径段的类型
+//   - *      匹配任意单个路径段，如 /api/*/profile
+//   - :name  命名参数，匹配规则与 * 相同，如 /users/:id
+//   - **     匹配零个或多个路径段，如 /api/**
+//   - 包含 *、?、[ 的其它段按 path.Match 匹配单个路径段，如 /static/*.js、/api/v[12]
+type segmentType int
+
+const (
+	segmentStatic   segmentType = iota // 普通路径段
+	segmentParam                       // * 或 :name
+	segmentPattern                     // 段内通配
+	segmentCatchAll                    // **
+)
+
+// ruleTrie 按路径段编译的规则前缀树
+// 在创建限流器和热加载时构建一次，匹配耗时只与路径深度有关，与规则数量无关
+type ruleTrie struct {
+	rules []RuleConfig
+	specs []specificity // 每个规则路径的具体程度，编译时预先计算
+	root  *trieNode
+}
+
+// trieNode 前缀树节点
+type trieNode struct {
+	static   map[string]*trieNode // 普通路径段
+	param    *trieNode            // * 或 :name
+	patterns []patternChild       // 段内通配，如 *.js
+	catchAll *trieNode            // **
+	rules    []int                // 在此节点结束的规则下标
+}
+
+// patternChild 段内通配的子节点
+type patternChild struct {
+	pattern string
+	node    *trieNode
+}
+
+// newRuleTrie 编译规则前缀树
+func newRuleTrie(rules []RuleConfig) *ruleTrie {
+	t := &ruleTrie{
+		rules: rules,
+		specs: make([]specificity, len(rules)),
+		root:  &trieNode{},
+	}
+	for i := range rules {
+		t.specs[i] = pathSpecificity(rules[i].Path)
+		node := t.root
+		for _, seg := range splitPath(rules[i].Path) {
+			node = node.child(seg)
+		}
+		node.rules = append(node.rules, i)
+	}
+	return t
+}
+
+// child 获取或创建路径段对应的子节点
+func (n *trieNode) child(seg string) *trieNode {
+	switch segmentKind(seg) {
+	case segmentCatchAll:
+		if n.catchAll == nil {
+			n.catchAll = &trieNode{}
+		}
+		return n.catchAll
+	case segmentParam:
+		if n.param == nil {
+			n.param = &trieNode{}
+		}
+		return n.param
+	case segmentPattern:
+		for _, p := range n.patterns {
+			if p.pattern == seg {
+				return p.node
+			}
+		}
+		node := &trieNode{}
+		n.patterns = append(n.patterns, patternChild{pattern: seg, node: node})
+		return node
+	default:
+		if n.static == nil {
+			n.static = make(map[string]*trieNode)
+		}
+		node, ok := n.static[seg]
+		if !ok {
+			node = &trieNode{}
+			n.static[seg] = node
+		}
+		return node
+	}
+}
+
+// match 查找路径匹配、且附加条件满足的最优先规则
+func (t *ruleTrie) match(req *Request) *RuleConfig {
+	var best *RuleConfig
+	bestIdx := -1
+	t.root.collect(splitPath(req.Path), 0, func(i int) {
+		rule := &t.rules[i]
+		if i == bestIdx || !rule.matchConditions(req) {
+			return
+		}
+		if best == nil {
+			best, bestIdx = rule, i
+			return
+		}
+		// 优先级完全相同时保留配置中靠前的规则
+		bestSpec := t.specs[bestIdx]
+		if precedes(rule, t.specs[i], best, bestSpec) ||
+			(!precedes(best, bestSpec, rule, t.specs[i]) && i < bestIdx) {
+			best, bestIdx = rule, i
+		}
+	})
+	return best
+}
+
+// collect 深度优先遍历所有能匹配segs[i:]的分支，对每个匹配的规则调用fn
+func (n *trieNode) collect(segs []string, i int, fn func(int)) {
+	if i == len(segs) {
+		for _, idx := range n.rules {
+			fn(idx)
+		}
+		// ** 可以匹配零个路径段
+		if n.catchAll != nil {
+			n.catchAll.collect(segs, i, fn)
+		}
+		return
+	}
+
+	seg := segs[i]
+	if child, ok := n.static[seg]; ok {
+		child.collect(segs, i+1, fn)
+	}
+	if n.param != nil {
+		n.param.collect(segs, i+1, fn)
+	}
+	for _, p := range n.patterns {
+		if matched, _ := path.Match(p.pattern, seg); matched {
+			p.node.collect(segs, i+1, fn)
+		}
+	}
+	if n.catchAll != nil {
+		for j := i; j <= len(segs); j++ {
+			n.catchAll.collect(segs, j, fn)
+		}
+	}
+}
+
+// segmentsMatch 判断单个路径模式是否匹配路径（与ruleTrie的匹配规则一致）
+func segmentsMatch(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+
+	seg := pattern[0]
+	switch segmentKind(seg) {
+	case segmentCatchAll:
+		for j := 0; j <= len(segs); j++ {
+			if segmentsMatch(pattern[1:], segs[j:]) {
+				return true
+			}
+		}
+		return false
+	case segmentParam:
+		return len(segs) > 0 && segmentsMatch(pattern[1:], segs[1:])
+	case segmentPattern:
+		if len(segs) == 0 {
+			return false
+		}
+		matched, _ := path.Match(seg, segs[0])
+		return matched && segmentsMatch(pattern[1:], segs[1:])
+	default:
+		return len(segs) > 0 && seg == segs[0] && segmentsMatch(pattern[1:], segs[1:])
+	}
+}
+
+// segmentKind 获取路径段的类型
+func segmentKind(seg string) segmentType {
+	switch {
+	case seg == "**":
+		return segmentCatchAll
+	case seg == "*", len(seg) > 1 && seg[0] == ':':
+		return segmentParam
+	case strings.ContainsAny(seg, "*?["):
+		return segmentPattern
+	default:
+		return segmentStatic
+	}
+}
+
+// splitPath 按 / 切分路径
+func splitPath(p string) []string {
+	return strings.Split(p, "/")
+}
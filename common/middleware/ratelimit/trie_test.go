@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cast"
+)
+
+// TestRuleTrie_Match 测试前缀树的路径匹配
+func TestRuleTrie_Match(t *testing.T) {
+	rules := []RuleConfig{
+		{Path: "/api/*", LimitPerSecond: 1},
+		{Path: "/api/**", LimitPerSecond: 2},
+		{Path: "/api/users/:id", LimitPerSecond: 3},
+		{Path: "/api/users/:id/orders/*", LimitPerSecond: 4},
+		{Path: "/static/*.js", LimitPerSecond: 5},
+		{Path: "/**/export", LimitPerSecond: 6},
+		{Path: "/api/users/me", LimitPerSecond: 7},
+		{Path: "/v[12]/items/?", LimitPerSecond: 8},
+	}
+	trie := newRuleTrie(rules)
+
+	tests := []struct {
+		path string
+		want int // 期望匹配的LimitPerSecond，0表示不匹配
+	}{
+		{path: "/api/users", want: 1},
+		{path: "/api/v1/users", want: 2},
+		{path: "/api", want: 2},
+		{path: "/api/users/42", want: 3},
+		{path: "/api/users/me", want: 7},
+		{path: "/api/users/42/orders/7", want: 4},
+		{path: "/api/users/42/orders/7/items", want: 2},
+		{path: "/static/app.js", want: 5},
+		{path: "/static/app.css"},
+		{path: "/static/js/app.js"},
+		{path: "/reports/2024/export", want: 6},
+		{path: "/export", want: 6},
+		{path: "/public/info"},
+		{path: "/v1/items/a", want: 8},
+		{path: "/v2/items/b", want: 8},
+		{path: "/v3/items/a"},
+		{path: "/v1/items/ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rule := trie.match(&Request{Path: tt.path})
+			if tt.want == 0 {
+				if rule != nil {
+					t.Errorf("expected nil rule, got %s", rule.Path)
+				}
+				return
+			}
+			if rule == nil {
+				t.Fatalf("expected non-nil rule, got nil")
+			}
+			if cast.ToInt(rule.LimitPerSecond) != tt.want {
+				t.Errorf("expected limit %d, got %d (%s)", tt.want, rule.LimitPerSecond, rule.Path)
+			}
+		})
+	}
+}
+
+// TestRuleTrie_ConsistentWithPathMatch 测试前缀树与单个模式匹配的结果一致
+func TestRuleTrie_ConsistentWithPathMatch(t *testing.T) {
+	limiter := &Limiter{config: &Config{}}
+	patterns := []string{"/api/*", "/api/**", "/a/**/b/*", "/:x/:y", "/v*/users", "/v[0-9]/*", "/api/?", "*", "/"}
+	paths := []string{"/api", "/api/", "/api/x", "/api/x/y", "/a/b/c", "/a/x/y/b/c", "/v1/users", "/users", "/"}
+
+	for _, pattern := range patterns {
+		trie := newRuleTrie([]RuleConfig{{Path: pattern, LimitPerSecond: 1}})
+		for _, p := range paths {
+			want := limiter.pathMatch(pattern, p)
+			got := trie.match(&Request{Path: p}) != nil
+			if cast.ToBool(got) != cast.ToBool(want) {
+				t.Errorf("pattern %s path %s: expected %v, got %v", pattern, p, want, got)
+			}
+		}
+	}
+}
+
+// TestRuleConfig_Validate_BadPattern 测试不合法的路径模式
+func TestRuleConfig_Validate_BadPattern(t *testing.T) {
+	for _, p := range []string{"/api/[a-*", "/api/v[12"} {
+		rule := &RuleConfig{Path: p, LimitPerSecond: 1}
+		if err := rule.Validate(); err == nil {
+			t.Errorf("%s: expected error for bad pattern, got nil", p)
+		}
+	}
+}
+
+// TestRuleConfig_Validate_CatchAll 测试每个规则最多一个 **
+func TestRuleConfig_Validate_CatchAll(t *testing.T) {
+	if err := (&RuleConfig{Path: "/api/**/export", LimitPerSecond: 1}).Validate(); err != nil {
+		t.Errorf("expected no error for one **, got %v", err)
+	}
+	rule := &RuleConfig{Path: "/**/api/**", LimitPerSecond: 1}
+	if err := rule.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for two **, got %v", err)
+	}
+}
+
+// benchmarkRules 生成n个规则，模拟按业务模块划分的大量路由
+func benchmarkRules(n int) []RuleConfig {
+	rules := make([]RuleConfig, 0, n)
+	for i := 0; i < n; i++ {
+		switch i % 3 {
+		case 0:
+			rules = append(rules, RuleConfig{Path: fmt.Sprintf("/svc%d/users", i), LimitPerSecond: 10})
+		case 1:
+			rules = append(rules, RuleConfig{Path: fmt.Sprintf("/svc%d/*", i), LimitPerSecond: 10})
+		default:
+			rules = append(rules, RuleConfig{Path: fmt.Sprintf("/svc%d/*/detail", i), LimitPerSecond: 10})
+		}
+	}
+	return rules
+}
+
+// legacyMatchRule 改造前的匹配方式：逐个规则调用filepath.Match，作为性能对比基准
+func legacyMatchRule(rules []RuleConfig, path string) *RuleConfig {
+	for i := range rules {
+		pattern := rules[i].Path
+		if pattern == path {
+			return &rules[i]
+		}
+		if strings.Contains(pattern, "*") {
+			if matched, _ := filepath.Match(pattern, path); matched {
+				return &rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// BenchmarkRuleMatch 性能测试：前缀树匹配与逐个filepath.Match的对比
+// 请求路径匹配最后一个规则（逐个匹配的最坏情况）
+func BenchmarkRuleMatch(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		rules := benchmarkRules(n)
+		last := n - 1
+		path := fmt.Sprintf("/svc%d/42/detail", last)
+		if last%3 != 2 {
+			path = fmt.Sprintf("/svc%d/users", last)
+		}
+		req := &Request{Path: path}
+
+		b.Run(fmt.Sprintf("loop/rules=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if legacyMatchRule(rules, path) == nil {
+					b.Fatal("expected match")
+				}
+			}
+		})
+
+		trie := newRuleTrie(rules)
+		b.Run(fmt.Sprintf("trie/rules=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if trie.match(req) == nil {
+					b.Fatal("expected match")
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,13 @@
 package stats
 
+import (
+	"fmt"
+	"time"
+
+	"working-project/common/breaker"
+	"working-project/common/clientip"
+)
+
 // Config 统计模块配置
 type Config struct {
 	// Redis连接名称（引用infrastructure/redis.Manager中的连接）
@@ -11,12 +19,35 @@ type Config struct {
 	// 是否按路径统计（true=统计每个路径的PV/UV，false=只统计全站PV/UV）
 	EnablePathStats bool `yaml:"enable_path_stats"`
 
+	// UV存储策略：set（默认，精确去重）、hyperloglog（固定内存，约0.81%误差）、
+	// hash（精确去重并记录每个访客的访问次数）
+	// 各策略使用不同的Redis key，修改后之前的UV数据不会被新策略读取
+	UVStrategy string `yaml:"uv_strategy"`
+
+	// 划分天、小时等bucket使用的时区（IANA名称，如Asia/Shanghai、UTC），Track和查询都按该时区
+	// 为空时使用服务器本地时区；多实例部署在不同时区的服务器上时必须配置，否则会写入不同的天
+	Timezone string `yaml:"timezone"`
+
 	// 数据保留天数（默认90天）
 	RetentionDays int `yaml:"retention_days"`
 
+	// 除按天以外还要统计的时间粒度及其保留时长，如 {minute: 2h, hour: 168h}
+	// 可选minute、hour、week、month，保留时长为0时使用默认值（minute 24h、hour 7天、week 1年、month 2年）
+	// 按天总是统计，保留时长由retention_days决定；按路径统计只按天进行
+	Granularities map[Granularity]time.Duration `yaml:"granularities"`
+
+	// 周/月汇总：把按天的数据汇总到周和月，加速跨度较大的范围查询
+	Rollup RollupConfig `yaml:"rollup"`
+
 	// 排除的路径（这些路径不参与统计）
 	// 例如：健康检查接口、静态资源等
 	ExcludePaths []string `yaml:"exclude_paths"`
+
+	// 客户端IP解析配置（可信代理等），未登录访客以客户端IP作为访客标识
+	ClientIP clientip.Config `yaml:"client_ip"`
+
+	// Redis熔断器：连续失败或变慢时打开，打开期间Track和查询直接返回ErrCircuitOpen，不再等待Redis超时
+	CircuitBreaker breaker.Config `yaml:"circuit_breaker"`
 }
 
 // DailyStats 每日统计数据
@@ -35,11 +66,19 @@ type PathStats struct {
 
 // RangeStats 时间范围统计数据
 type RangeStats struct {
-	StartDate  string       `json:"start_date"`  // 开始日期
-	EndDate    string       `json:"end_date"`    // 结束日期
-	TotalPV    int64        `json:"total_pv"`    // 总PV
-	TotalUV    int64        `json:"total_uv"`    // 总UV（注意：跨天的UV不能简单相加）
-	DailyStats []DailyStats `json:"daily_stats"` // 每日明细
+	StartDate  string        `json:"start_date"`        // 开始日期
+	EndDate    string        `json:"end_date"`          // 结束日期
+	TotalPV    int64         `json:"total_pv"`          // 总PV
+	TotalUV    int64         `json:"total_uv"`          // 总UV（注意：跨天的UV不能简单相加）
+	DailyStats []DailyStats  `json:"daily_stats"`       // 每日明细
+	Rollups    []RollupStats `json:"rollups,omitempty"` // 范围内已汇总的整周、整月（启用rollup时）
+}
+
+// RollupStats 周/月汇总的统计数据
+type RollupStats struct {
+	Bucket string `json:"bucket"` // 周或月，格式：2024-W03、2024-01
+	PV     int64  `json:"pv"`     // 页面浏览量
+	UV     int64  `json:"uv"`     // 独立访客数（周/月内去重）
 }
 
 // GetRetentionDays 获取数据保留天数（有默认值）
@@ -50,6 +89,94 @@ func (c *Config) GetRetentionDays() int {
 	return c.RetentionDays
 }
 
+// GranularityEnabled 是否统计该时间粒度（day总是统计）
+func (c *Config) GranularityEnabled(g Granularity) bool {
+	if g == GranularityDay {
+		return true
+	}
+	_, ok := c.Granularities[g]
+	return ok && g.valid()
+}
+
+// EnabledGranularities 获取统计的所有时间粒度，从细到粗
+func (c *Config) EnabledGranularities() []Granularity {
+	var result []Granularity
+	for _, g := range granularities {
+		if c.GranularityEnabled(g) {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// GetGranularityRetention 获取时间粒度的保留时长（有默认值）
+func (c *Config) GetGranularityRetention(g Granularity) time.Duration {
+	if g == GranularityDay {
+		return time.Duration(c.GetRetentionDays()) * 24 * time.Hour
+	}
+	if retention := c.Granularities[g]; retention > 0 {
+		return retention
+	}
+	return defaultGranularityRetention[g]
+}
+
+// GetLocation 获取timezone对应的时区，未配置时返回服务器本地时区
+func (c *Config) GetLocation() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: timezone %q: %v", ErrInvalidConfig, c.Timezone, err)
+	}
+	return loc, nil
+}
+
+// GetUVStrategy 获取UV存储策略，如果未配置则返回set
+func (c *Config) GetUVStrategy() string {
+	if c.UVStrategy == "" {
+		return UVStrategySet
+	}
+	return c.UVStrategy
+}
+
+// Validate 验证统计配置是否合法
+// 热加载时用于保证错误的配置不会替换正在使用的配置
+func (c *Config) Validate() error {
+	for i, path := range c.ExcludePaths {
+		if path == "" {
+			return fmt.Errorf("%w: exclude_paths[%d] is empty", ErrInvalidConfig, i)
+		}
+	}
+	for g, retention := range c.Granularities {
+		if !g.valid() {
+			return fmt.Errorf("%w: unknown granularity %q", ErrInvalidConfig, g)
+		}
+		if g == GranularityDay {
+			return fmt.Errorf("%w: day is always enabled, use retention_days", ErrInvalidConfig)
+		}
+		if retention < 0 {
+			return fmt.Errorf("%w: granularities.%s is negative", ErrInvalidConfig, g)
+		}
+	}
+	if c.Rollup.Interval < 0 {
+		return fmt.Errorf("%w: rollup.interval is negative", ErrInvalidConfig)
+	}
+	if _, err := c.GetLocation(); err != nil {
+		return err
+	}
+	if _, err := getUVStore(c.GetUVStrategy()); err != nil {
+		return err
+	}
+	if err := c.ClientIP.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if err := c.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("%w: circuit_breaker: %v", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
 // IsExcludedPath 检查路径是否在排除列表中
 func (c *Config) IsExcludedPath(path string) bool {
 	for _, excludePath := range c.ExcludePaths {
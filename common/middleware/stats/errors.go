@@ -1,15 +1,29 @@
 package stats
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"working-project/common/breaker"
+)
 
 // 统计相关错误定义
 var (
 	// ErrRedisUnavailable Redis不可用
 	ErrRedisUnavailable = errors.New("redis unavailable")
 
+	// ErrCircuitOpen 熔断器打开，没有访问Redis（同时包装了ErrRedisUnavailable和breaker.ErrOpen）
+	ErrCircuitOpen = fmt.Errorf("%w: %w", ErrRedisUnavailable, breaker.ErrOpen)
+
 	// ErrInvalidConfig 配置错误
 	ErrInvalidConfig = errors.New("invalid stats config")
 
+	// ErrUnsupportedStrategy 当前的uv_strategy不支持该查询
+	ErrUnsupportedStrategy = errors.New("unsupported by uv strategy")
+
+	// ErrInvalidSeries 时间序列查询参数错误（未知的指标、未启用的粒度、点数过多）
+	ErrInvalidSeries = errors.New("invalid series query")
+
 	// ErrInvalidDate 日期格式错误
 	ErrInvalidDate = errors.New("invalid date format")
 
@@ -0,0 +1,46 @@
+package stats
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics 统计追踪器的Prometheus指标
+//
+// 指标:
+//   - stats_track_failures_total: 中间件记录访问失败的次数（如Redis不可用）
+type Metrics struct {
+	trackFailures prometheus.Counter
+}
+
+// NewMetrics 创建统计指标并注册到registerer，registerer为nil时不注册（由调用方注册Collectors）
+// 重复注册会panic，同一个registerer只能调用一次
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		trackFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stats_track_failures_total",
+			Help: "Failed stats Track calls in the stats middleware.",
+		}),
+	}
+	if registerer != nil {
+		registerer.MustRegister(m.Collectors()...)
+	}
+	return m
+}
+
+// Collectors 获取全部指标，用于注册到自定义的registerer
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.trackFailures}
+}
+
+// WithMetrics 设置Prometheus指标
+func WithMetrics(metrics *Metrics) Option {
+	return func(t *Tracker) {
+		t.metrics = metrics
+	}
+}
+
+// observeTrackFailure 记录一次Track失败，m为nil时什么都不做
+func (m *Metrics) observeTrackFailure() {
+	if m == nil {
+		return
+	}
+	m.trackFailures.Inc()
+}
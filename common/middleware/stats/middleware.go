@@ -1,10 +1,11 @@
 package stats
 
 import (
-	"fmt"
-	"strings"
+	"errors"
 
 	"github.com/gin-gonic/gin"
+
+	"working-project/common/logging"
 )
 
 // Middleware 统计中间件
@@ -17,7 +18,7 @@ import (
 func Middleware(tracker *Tracker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 如果未启用统计，直接放行
-		if !tracker.config.Enabled {
+		if !tracker.getConfig().Enabled {
 			c.Next()
 			return
 		}
@@ -57,18 +58,26 @@ func Middleware(tracker *Tracker) gin.HandlerFunc {
 		// 获取访客唯一标识：优先使用用户ID，未登录则使用IP
 		visitorID := c.GetHeader("Authorization")
 		if visitorID == "" {
-			// 未登录用户，使用IP作为标识
-			visitorID = getClientIP(c)
+			// 未登录用户，使用IP作为标识（按可信代理配置解析，防止伪造）
+			visitorID = tracker.ClientIP(c.Request)
 		}
 
 		// 获取访问路径（不包含query参数）
 		urlPath := c.Request.URL.Path
 
 		// 记录统计（注意：传入context.Context而不是gin.Context）
-		err := tracker.Track(c.Request.Context(), visitorID, urlPath)
+		ctx := logging.RequestContext(c.Request)
+		err := tracker.Track(ctx, visitorID, urlPath)
 		if err != nil {
-			// 统计失败不应影响业务，只打印日志
-			fmt.Printf("[Stats] Track failed: %v, path: %s, visitor: %s\n", err, urlPath, visitorID)
+			// 统计失败不应影响业务，只记录日志（访客标识可能是Token，只记录哈希）
+			// 熔断器打开期间每个请求都会失败，只记录debug日志（熔断器状态变化时已记录warn日志）
+			args := []any{logging.Err(err), logging.KeyHash(visitorID), logging.Path(urlPath), logging.RequestID(ctx)}
+			if errors.Is(err, ErrCircuitOpen) {
+				tracker.log().DebugContext(ctx, "stats: track skipped, circuit breaker open", args...)
+			} else {
+				tracker.log().WarnContext(ctx, "stats: track failed", args...)
+			}
+			tracker.metrics.observeTrackFailure()
 		}
 
 		// ========================================
@@ -76,44 +85,3 @@ func Middleware(tracker *Tracker) gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// ========================================
-// 辅助函数
-// ========================================
-
-// getClientIP 获取客户端IP
-// 从X-Forwarded-For或RemoteAddr获取
-func getClientIP(c *gin.Context) string {
-	// 1. 尝试从X-Forwarded-For获取（可能被伪造，需要配合可信代理列表使用）
-	xff := c.GetHeader("X-Forwarded-For")
-	if xff != "" {
-		// X-Forwarded-For格式: client, proxy1, proxy2
-		// 取第一个IP
-		parts := splitAndTrim(xff, ",")
-		if len(parts) > 0 && parts[0] != "" {
-			return parts[0]
-		}
-	}
-
-	// 2. 尝试从X-Real-IP获取
-	xRealIP := c.GetHeader("X-Real-IP")
-	if xRealIP != "" {
-		return xRealIP
-	}
-
-	// 3. 使用RemoteAddr（Gin的ClientIP方法已处理端口号）
-	return c.ClientIP()
-}
-
-// splitAndTrim 分割字符串并去除空格
-func splitAndTrim(s, sep string) []string {
-	parts := strings.Split(s, sep)
-	result := make([]string, 0, len(parts))
-	for _, part := range parts {
-		trimmed := strings.TrimSpace(part)
-		if trimmed != "" {
-			result = append(result, trimmed)
-		}
-	}
-	return result
-}
@@ -0,0 +1,364 @@
+package stats
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+
+	"working-project/common/logging"
+)
+
+// rollupKeyPrefix 周/月汇总的Redis key前缀
+// 格式: stats:rollup:pv:2024-W03、stats:rollup:uv:2024-01（UV的类型与uv_strategy相同，前缀同buildUVKey）
+// 汇总覆盖到哪一天记录在 stats:rollup:meta:uv 中（Hash，field为bucket，value为YYYY-MM-DD）
+const rollupKeyPrefix = "stats:rollup:"
+
+// defaultRollupInterval 默认的汇总检查间隔
+const defaultRollupInterval = 10 * time.Minute
+
+// rollupGranularities 汇总的粒度，从粗到细（范围查询优先使用更粗的汇总）
+var rollupGranularities = []Granularity{GranularityMonth, GranularityWeek}
+
+// RollupConfig 周/月汇总配置
+type RollupConfig struct {
+	// 是否启用汇总：启用后RollupWorker在每天结束后把按天的PV、UV汇总到周和月，
+	// GetRangeStats、GetUniqueUVInRange在汇总覆盖查询范围时使用汇总
+	Enabled bool `yaml:"enabled"`
+
+	// 汇总任务的执行间隔（默认10m），只影响一天结束后多久完成汇总，修改需要重启RollupWorker
+	Interval time.Duration `yaml:"interval"`
+}
+
+// GetInterval 获取汇总任务的执行间隔（有默认值）
+func (c *RollupConfig) GetInterval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultRollupInterval
+	}
+	return c.Interval
+}
+
+// Rollup 汇总已经结束的天
+// 该方法由RollupWorker定期调用，也可以由定时任务直接调用
+//
+// 功能:
+//   - 重新计算昨天所在的周和月（从bucket的第一天到昨天）的PV和去重UV
+//   - retention_days内没有汇总或没有汇总完整的周、月（如任务停止过、刚启用rollup）一并补上
+//   - 已经汇总到最新的bucket跳过，重复调用没有额外开销
+//
+// 注意:
+//   - 汇总只读取按天的数据，开始日期已经超过retention_days的bucket不再汇总
+//   - 汇总的保留时长与granularities中week、month的保留时长相同
+func (t *Tracker) Rollup(ctx context.Context) (err error) {
+	ctx, span := t.startSpan(ctx, "stats.Rollup")
+	defer func() { endSpan(span, err) }()
+
+	config := t.getConfig()
+	today := GranularityDay.truncate(t.clock())
+	return t.rollupRange(ctx, config, today.AddDate(0, 0, -config.GetRetentionDays()), today, false)
+}
+
+// RollupRange 重新计算包含[startDate, endDate]中任意一天的周和月的汇总
+// 用于修复按天的数据后重新汇总；与Rollup不同，已经汇总到最新的bucket也会重新计算
+// 参数:
+//   - ctx: 上下文
+//   - startDate: 开始日期，格式：YYYY-MM-DD
+//   - endDate: 结束日期，格式：YYYY-MM-DD
+//
+// 注意:
+//   - 与Rollup相同，只汇总到昨天，开始日期已经超过retention_days的bucket跳过
+func (t *Tracker) RollupRange(ctx context.Context, startDate, endDate string) (err error) {
+	ctx, span := t.startSpan(ctx, "stats.RollupRange",
+		attribute.String(attrStartDate, startDate), attribute.String(attrEndDate, endDate))
+	defer func() { endSpan(span, err) }()
+
+	start, err := t.parseDate(startDate)
+	if err != nil {
+		return err
+	}
+	end, err := t.parseDate(endDate)
+	if err != nil {
+		return err
+	}
+	if end.Before(start) {
+		return ErrInvalidDate
+	}
+
+	return t.rollupRange(ctx, t.getConfig(), start, end, true)
+}
+
+// rollupRange 汇总包含[from, to]中任意一天的周和月（只汇总到昨天）
+// force为false时跳过已经汇总到最新的bucket
+func (t *Tracker) rollupRange(ctx context.Context, config *Config, from, to time.Time, force bool) error {
+	strategy := config.GetUVStrategy()
+	store, err := getUVStore(strategy)
+	if err != nil {
+		return err
+	}
+
+	today := GranularityDay.truncate(t.clock())
+	yesterday := today.AddDate(0, 0, -1)
+	oldest := today.AddDate(0, 0, -config.GetRetentionDays())
+	if to.After(yesterday) {
+		to = yesterday
+	}
+
+	var covered map[string]string
+	if !force {
+		err = t.guardRedis(func() error {
+			var err error
+			covered, err = t.redis.HGetAll(ctx, t.buildRollupMetaKey(strategy)).Result()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, granularity := range rollupGranularities {
+		for start := granularity.truncate(from); !start.After(to); start = granularity.next(start) {
+			// bucket的第一天已经超过retention_days，按天的数据不完整
+			if start.Before(oldest) {
+				continue
+			}
+			through := granularity.next(start).AddDate(0, 0, -1)
+			if through.After(yesterday) {
+				through = yesterday
+			}
+			if !force && covered[granularity.format(start)] == through.Format("2006-01-02") {
+				continue
+			}
+			if err := t.rollupBucket(ctx, config, store, strategy, granularity, start, through); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollupBucket 把start到through（含）每天的数据汇总到start所在的bucket
+func (t *Tracker) rollupBucket(
+	ctx context.Context,
+	config *Config,
+	store uvStore,
+	strategy string,
+	granularity Granularity,
+	start, through time.Time,
+) error {
+	var pvKeys, uvKeys []string
+	for day := start; !day.After(through); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		pvKeys = append(pvKeys, t.buildPVKey(date, ""))
+		uvKeys = append(uvKeys, t.buildUVKey(strategy, date, ""))
+	}
+
+	bucket := granularity.format(start)
+	expire := config.GetGranularityRetention(granularity)
+	metaExpire := config.GetGranularityRetention(GranularityMonth)
+	if week := config.GetGranularityRetention(GranularityWeek); week > metaExpire {
+		metaExpire = week
+	}
+
+	return t.guardRedis(func() error {
+		values, err := t.redis.MGet(ctx, pvKeys...).Result()
+		if err != nil {
+			return err
+		}
+		var pv int64
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				n, _ := strconv.ParseInt(s, 10, 64)
+				pv += n
+			}
+		}
+
+		pvKey := t.buildRollupPVKey(bucket)
+		uvKey := t.buildRollupUVKey(strategy, bucket)
+		metaKey := t.buildRollupMetaKey(strategy)
+		_, err = t.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, pvKey, pv, expire)
+			pipe.Del(ctx, uvKey)
+			store.merge(ctx, pipe, uvKey, uvKeys)
+			pipe.Expire(ctx, uvKey, expire)
+			pipe.HSet(ctx, metaKey, bucket, through.Format("2006-01-02"))
+			pipe.Expire(ctx, metaKey, metaExpire)
+			return nil
+		})
+		return err
+	})
+}
+
+// rangePlan 范围查询的读取计划：能用汇总的部分读取汇总，其余按天读取
+type rangePlan struct {
+	buckets []string // 使用的周/月汇总的bucket
+	days    []string // 没有被汇总覆盖的天
+}
+
+// planRange 把[start, end]拆分为汇总和剩余的天
+// 汇总的bucket必须从范围内开始、覆盖到的最后一天不超过end；未启用汇总时全部按天读取
+func (t *Tracker) planRange(ctx context.Context, config *Config, strategy string, start, end time.Time) (*rangePlan, error) {
+	plan := &rangePlan{}
+	if !config.Rollup.Enabled {
+		for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+			plan.days = append(plan.days, day.Format("2006-01-02"))
+		}
+		return plan, nil
+	}
+
+	// 查询范围内开始的所有bucket覆盖到哪一天
+	var fields []string
+	for _, granularity := range rollupGranularities {
+		for s := granularity.truncate(start); !s.After(end); s = granularity.next(s) {
+			if !s.Before(start) {
+				fields = append(fields, granularity.format(s))
+			}
+		}
+	}
+	covered := make(map[string]time.Time, len(fields))
+	if len(fields) > 0 {
+		var values []interface{}
+		err := t.guardRedis(func() error {
+			var err error
+			values, err = t.redis.HMGet(ctx, t.buildRollupMetaKey(strategy), fields...).Result()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			s, _ := value.(string)
+			if through, err := time.ParseInLocation("2006-01-02", s, start.Location()); err == nil {
+				covered[fields[i]] = through
+			}
+		}
+	}
+
+	for day := start; !day.After(end); {
+		used := false
+		for _, granularity := range rollupGranularities {
+			if !granularity.truncate(day).Equal(day) {
+				continue
+			}
+			bucket := granularity.format(day)
+			through, ok := covered[bucket]
+			if !ok || through.Before(day) || through.After(end) {
+				continue
+			}
+			plan.buckets = append(plan.buckets, bucket)
+			day = through.AddDate(0, 0, 1)
+			used = true
+			break
+		}
+		if !used {
+			plan.days = append(plan.days, day.Format("2006-01-02"))
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+	return plan, nil
+}
+
+// buildRollupPVKey 构建PV汇总的Redis Key
+// 格式: stats:rollup:pv:2024-W03 或 stats:rollup:pv:2024-01
+func (t *Tracker) buildRollupPVKey(bucket string) string {
+	return rollupKeyPrefix + "pv:" + bucket
+}
+
+// buildRollupUVKey 构建UV汇总的Redis Key
+// 格式: stats:rollup:uv:2024-W03、stats:rollup:uv_hyperloglog:2024-01
+func (t *Tracker) buildRollupUVKey(strategy, bucket string) string {
+	return rollupKeyPrefix + uvKeyName(strategy) + ":" + bucket
+}
+
+// buildRollupMetaKey 构建记录汇总覆盖范围的Redis Key
+// 格式: stats:rollup:meta:uv（各uv_strategy的汇总分别记录）
+func (t *Tracker) buildRollupMetaKey(strategy string) string {
+	return rollupKeyPrefix + "meta:" + uvKeyName(strategy)
+}
+
+// RollupWorker 周/月汇总的后台任务
+//
+// 使用方式:
+//
+//	worker := stats.NewRollupWorker(tracker)
+//	worker.Start(ctx)
+//	defer worker.Stop()
+type RollupWorker struct {
+	tracker *Tracker
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewRollupWorker 创建汇总任务
+func NewRollupWorker(tracker *Tracker) *RollupWorker {
+	return &RollupWorker{tracker: tracker}
+}
+
+// Start 启动后台汇总：立即执行一次，之后按rollup.interval定期执行
+// rollup.enabled为false时不汇总（热加载打开后自动开始）
+func (w *RollupWorker) Start(ctx context.Context) {
+	w.mu.Lock()
+	if w.stopCh != nil {
+		w.mu.Unlock()
+		return
+	}
+	w.stopCh = make(chan struct{})
+	w.doneCh = make(chan struct{})
+	stopCh, doneCh := w.stopCh, w.doneCh
+	w.mu.Unlock()
+
+	interval := w.tracker.getConfig().Rollup.GetInterval()
+	go func() {
+		defer close(doneCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			w.runOnce(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止后台汇总并等待其退出
+func (w *RollupWorker) Stop() {
+	w.mu.Lock()
+	stopCh, doneCh := w.stopCh, w.doneCh
+	w.stopCh, w.doneCh = nil, nil
+	w.mu.Unlock()
+
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+// runOnce 执行一次汇总，失败时记录日志，下次执行时重试
+func (w *RollupWorker) runOnce(ctx context.Context) {
+	if !w.tracker.getConfig().Rollup.Enabled {
+		return
+	}
+	if err := w.tracker.Rollup(ctx); err != nil {
+		w.tracker.log().WarnContext(ctx, "stats: rollup failed", logging.Err(err))
+	}
+}
+
+// rollupAttrs 范围查询使用汇总情况的span属性
+func rollupAttrs(plan *rangePlan) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int(attrRollups, len(plan.buckets)),
+		attribute.Int(attrDays, len(plan.days)),
+	}
+}
@@ -0,0 +1,390 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Granularity 统计的时间粒度
+type Granularity string
+
+// 支持的时间粒度
+const (
+	GranularityMinute Granularity = "minute" // 按分钟，bucket格式：2006-01-02T1504
+	GranularityHour   Granularity = "hour"   // 按小时，bucket格式：2006-01-02T15
+	GranularityDay    Granularity = "day"    // 按天（总是启用），bucket格式：2006-01-02
+	GranularityWeek   Granularity = "week"   // 按ISO周（周一开始），bucket格式：2006-W01
+	GranularityMonth  Granularity = "month"  // 按自然月，bucket格式：2006-01
+)
+
+// 时间序列的指标
+const (
+	MetricPV = "pv" // 页面浏览量
+	MetricUV = "uv" // 独立访客数（bucket内去重）
+)
+
+// maxSeriesPoints GetSeries一次最多返回的点数，防止误传的大范围一次发出过多Redis命令
+const maxSeriesPoints = 10000
+
+// 各粒度未配置保留时长时的默认值（day使用retention_days）
+var defaultGranularityRetention = map[Granularity]time.Duration{
+	GranularityMinute: 24 * time.Hour,
+	GranularityHour:   7 * 24 * time.Hour,
+	GranularityWeek:   365 * 24 * time.Hour,
+	GranularityMonth:  2 * 365 * 24 * time.Hour,
+}
+
+// granularities 所有粒度，从细到粗
+var granularities = []Granularity{GranularityMinute, GranularityHour, GranularityDay, GranularityWeek, GranularityMonth}
+
+// valid 是否是支持的粒度
+func (g Granularity) valid() bool {
+	for _, known := range granularities {
+		if g == known {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate 获取t所在bucket的开始时间（按t的时区）
+func (g Granularity) truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch g {
+	case GranularityMinute:
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location())
+	case GranularityHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case GranularityWeek:
+		// 周一为一周的开始
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case GranularityMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// next 获取bucket开始时间start的下一个bucket的开始时间
+// 按日历计算而不是加固定时长，夏令时切换的那天也不会错位
+func (g Granularity) next(start time.Time) time.Time {
+	switch g {
+	case GranularityMinute:
+		return start.Add(time.Minute)
+	case GranularityHour:
+		return start.Add(time.Hour)
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// format 获取t所在bucket的名称，用于Redis key
+func (g Granularity) format(t time.Time) string {
+	switch g {
+	case GranularityMinute:
+		return t.Format("2006-01-02T1504")
+	case GranularityHour:
+		return t.Format("2006-01-02T15")
+	case GranularityWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case GranularityMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseBucket 解析bucket名称，返回粒度和bucket的开始时间
+func parseBucket(bucket string, loc *time.Location) (Granularity, time.Time, bool) {
+	layouts := []struct {
+		granularity Granularity
+		layout      string
+	}{
+		{GranularityMinute, "2006-01-02T1504"},
+		{GranularityHour, "2006-01-02T15"},
+		{GranularityDay, "2006-01-02"},
+		{GranularityMonth, "2006-01"},
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l.layout, bucket, loc); err == nil {
+			return l.granularity, t, true
+		}
+	}
+
+	// ISO周：2006-W01
+	yearStr, weekStr, ok := strings.Cut(bucket, "-W")
+	if !ok || len(yearStr) != 4 || len(weekStr) != 2 {
+		return "", time.Time{}, false
+	}
+	year, err1 := strconv.Atoi(yearStr)
+	week, err2 := strconv.Atoi(weekStr)
+	if err1 != nil || err2 != nil || week < 1 || week > 53 {
+		return "", time.Time{}, false
+	}
+	// 1月4日总是在第1周
+	firstWeek := GranularityWeek.truncate(time.Date(year, time.January, 4, 0, 0, 0, 0, loc))
+	return GranularityWeek, firstWeek.AddDate(0, 0, 7*(week-1)), true
+}
+
+// Point 时间序列中的一个点
+type Point struct {
+	Time   time.Time `json:"time"`   // bucket的开始时间
+	Bucket string    `json:"bucket"` // bucket名称，如 2024-01-15T10
+	Value  int64     `json:"value"`  // 指标值，没有数据时为0
+}
+
+// Series 时间序列
+type Series struct {
+	Metric      string      `json:"metric"`      // 指标：pv或uv
+	Granularity Granularity `json:"granularity"` // 时间粒度
+	Points      []Point     `json:"points"`      // 从from到to的每个bucket，没有数据的bucket值为0
+}
+
+// QueryOption 查询选项
+type QueryOption func(*queryOptions)
+
+// queryOptions 查询选项
+type queryOptions struct {
+	location *time.Location
+}
+
+// InTimezone 按指定时区划分时间序列的bucket（如按报表读者所在的时区出日报），默认使用配置的timezone
+// 该时区的bucket边界与存储的bucket不一致时（如按天查询且时差不是整天），
+// 由启用的更细粒度的bucket汇总：PV求和，UV合并去重（hyperloglog策略有误差）
+func InTimezone(loc *time.Location) QueryOption {
+	return func(o *queryOptions) {
+		o.location = loc
+	}
+}
+
+// GetSeries 获取全站PV或UV的时间序列
+// 参数:
+//   - ctx: 上下文
+//   - metric: 指标，MetricPV或MetricUV
+//   - granularity: 时间粒度，需要在granularities中启用（day总是启用）
+//   - from: 开始时间（所在的bucket包含在内）
+//   - to: 结束时间（所在的bucket包含在内）
+//   - opts: 查询选项，如InTimezone
+//
+// 返回:
+//   - Series: 连续的时间序列，没有数据的bucket值为0，可以直接用于画图
+//
+// 注意:
+//   - UV是每个bucket内的去重访客数，不同bucket之间不去重
+//   - 超过保留时长的bucket已经过期，值为0
+func (t *Tracker) GetSeries(
+	ctx context.Context,
+	metric string,
+	granularity Granularity,
+	from, to time.Time,
+	opts ...QueryOption,
+) (_ *Series, err error) {
+	ctx, span := t.startSpan(ctx, "stats.GetSeries",
+		attribute.String(attrMetric, metric), attribute.String(attrGranularity, string(granularity)))
+	defer func() { endSpan(span, err) }()
+
+	config := t.getConfig()
+	if metric != MetricPV && metric != MetricUV {
+		return nil, fmt.Errorf("%w: unknown metric %q", ErrInvalidSeries, metric)
+	}
+	if !config.GranularityEnabled(granularity) {
+		return nil, fmt.Errorf("%w: granularity %q is not enabled", ErrInvalidSeries, granularity)
+	}
+	if to.Before(from) {
+		return nil, ErrStartDate
+	}
+
+	storage := t.getLocation()
+	options := queryOptions{location: storage}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.location == nil {
+		options.location = storage
+	}
+
+	strategy := config.GetUVStrategy()
+	store, err := getUVStore(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按查询时区展开bucket，保证序列连续
+	var points []Point
+	for start := granularity.truncate(from.In(options.location)); !start.After(to); start = granularity.next(start) {
+		if len(points) >= maxSeriesPoints {
+			return nil, fmt.Errorf("%w: more than %d points", ErrInvalidSeries, maxSeriesPoints)
+		}
+		points = append(points, Point{Time: start, Bucket: granularity.format(start)})
+	}
+
+	// 每个点对应的存储bucket
+	source, err := sourceGranularity(config, granularity, points, storage)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([][]string, len(points))
+	total := 0
+	for i, point := range points {
+		end := granularity.next(point.Time)
+		for start := point.Time.In(storage); start.Before(end); start = source.next(start) {
+			buckets[i] = append(buckets[i], source.format(start))
+		}
+		total += len(buckets[i])
+		if total > maxSeriesPoints {
+			return nil, fmt.Errorf("%w: more than %d %s buckets", ErrInvalidSeries, maxSeriesPoints, source)
+		}
+	}
+
+	if metric == MetricPV {
+		err = t.readPVSeries(ctx, points, buckets)
+	} else {
+		err = t.readUVSeries(ctx, store, strategy, points, buckets)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Series{
+		Metric:      metric,
+		Granularity: granularity,
+		Points:      points,
+	}, nil
+}
+
+// sourceGranularity 选择读取的存储粒度
+// 查询的bucket边界都是该粒度在存储时区下的bucket边界时才能读取，优先使用查询粒度本身，否则使用更细的粒度
+func sourceGranularity(config *Config, granularity Granularity, points []Point, storage *time.Location) (Granularity, error) {
+	aligned := func(g Granularity) bool {
+		for _, point := range points {
+			for _, boundary := range []time.Time{point.Time, granularity.next(point.Time)} {
+				b := boundary.In(storage)
+				if !g.truncate(b).Equal(b) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	if aligned(granularity) {
+		return granularity, nil
+	}
+	// 从粗到细查找，读取的bucket越少越好
+	candidates := config.EnabledGranularities()
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if g := candidates[i]; g.finerThan(granularity) && aligned(g) {
+			return g, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s buckets in %s do not align with stored buckets in %s, enable a finer granularity",
+		ErrInvalidSeries, granularity, points[0].Time.Location(), storage)
+}
+
+// finerThan 是否比other更细
+func (g Granularity) finerThan(other Granularity) bool {
+	for _, known := range granularities {
+		if known == other {
+			return false
+		}
+		if known == g {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesBatchEnd 从start开始的一批点的结束位置（不含）
+// 与readCounts相同，每批最多maxBatchSize个存储bucket，一批一个Pipeline；单个点的bucket超过时单独一批
+func seriesBatchEnd(buckets [][]string, start int) int {
+	end, n := start, 0
+	for end < len(buckets) && (end == start || n+len(buckets[end]) <= maxBatchSize) {
+		n += len(buckets[end])
+		end++
+	}
+	return end
+}
+
+// readPVSeries 读取每个点的PV（多个存储bucket时求和），每批一条MGET
+func (t *Tracker) readPVSeries(ctx context.Context, points []Point, buckets [][]string) error {
+	for start := 0; start < len(points); {
+		end := seriesBatchEnd(buckets, start)
+
+		var keys []string
+		for i := start; i < end; i++ {
+			for _, bucket := range buckets[i] {
+				keys = append(keys, t.buildPVKey(bucket, ""))
+			}
+		}
+		var values []interface{}
+		err := t.guardRedis(func() error {
+			var err error
+			values, err = t.redis.MGet(ctx, keys...).Result()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for i := start; i < end; i++ {
+			for range buckets[i] {
+				if s, ok := values[0].(string); ok {
+					pv, _ := strconv.ParseInt(s, 10, 64)
+					points[i].Value += pv
+				}
+				values = values[1:]
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// readUVSeries 读取每个点的UV（多个存储bucket时合并去重），每批一个Pipeline
+func (t *Tracker) readUVSeries(ctx context.Context, store uvStore, strategy string, points []Point, buckets [][]string) error {
+	for start := 0; start < len(points); {
+		end := seriesBatchEnd(buckets, start)
+
+		err := t.guardRedis(func() error {
+			pipe := t.redis.Pipeline()
+			counts := make([]func() int64, 0, end-start)
+			for i := start; i < end; i++ {
+				if len(buckets[i]) == 1 {
+					counts = append(counts, store.count(ctx, pipe, t.buildUVKey(strategy, buckets[i][0], "")).Val)
+					continue
+				}
+				keys := make([]string, 0, len(buckets[i]))
+				for _, bucket := range buckets[i] {
+					keys = append(keys, t.buildUVKey(strategy, bucket, ""))
+				}
+				count, err := store.unionCount(ctx, pipe, keys)
+				if err != nil {
+					return err
+				}
+				counts = append(counts, count)
+			}
+			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+				return err
+			}
+
+			for i, count := range counts {
+				points[start+i].Value = count()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
@@ -2,35 +2,91 @@ package stats
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"working-project/common/breaker"
+	"working-project/common/clientip"
 	infraredis "working-project/common/infrastructure/redis"
+	"working-project/common/logging"
 )
 
 // Tracker 统计追踪器
 type Tracker struct {
-	redis  *redis.Client
-	config *Config
+	redis    *redis.Client
+	mu       sync.RWMutex // 保护config、clientIP和location，支持热加载
+	config   *Config
+	clientIP *clientip.Resolver
+	location *time.Location   // config.Timezone解析后的时区
+	now      func() time.Time // 时钟，测试时可替换
+	metrics  *Metrics         // Prometheus指标，为nil则不记录
+	logger   logging.Logger   // 结构化日志，为nil则使用slog.Default()
+	breaker  *breaker.Breaker // Redis熔断器，为nil则不熔断
+
+	tracerProvider trace.TracerProvider // OpenTelemetry，为nil则使用全局provider
+}
+
+// Option 统计追踪器选项
+type Option func(*Tracker)
+
+// WithLogger 设置结构化日志，记录统计失败等事件
+func WithLogger(logger logging.Logger) Option {
+	return func(t *Tracker) {
+		t.logger = logger
+	}
+}
+
+// WithClock 使用自定义时钟（用于测试）
+func WithClock(now func() time.Time) Option {
+	return func(t *Tracker) {
+		t.now = now
+	}
+}
+
+// log 获取结构化日志，未设置时使用slog.Default()
+func (t *Tracker) log() logging.Logger {
+	return logging.OrDefault(t.logger)
 }
 
 // NewTracker 创建统计追踪器
-func NewTracker(redisClient *redis.Client, config *Config) *Tracker {
-	return &Tracker{
-		redis:  redisClient,
-		config: config,
+func NewTracker(redisClient *redis.Client, config *Config, opts ...Option) *Tracker {
+	t := &Tracker{
+		redis:    redisClient,
+		config:   config,
+		clientIP: clientip.NewResolver(&config.ClientIP),
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+	loc, err := config.GetLocation()
+	if err != nil {
+		t.log().WarnContext(context.Background(), "stats: invalid timezone, using local time", logging.Err(err))
+		loc = time.Local
+	}
+	t.location = loc
+	t.breaker = breaker.New(config.CircuitBreaker, breaker.WithStateChange(func(from, to breaker.State) {
+		t.log().WarnContext(context.Background(), "stats: circuit breaker state changed",
+			slog.String("from", from.String()), slog.String("to", to.String()))
+	}))
+	return t
 }
 
 // NewTrackerFromManager 从Redis Manager创建追踪器（推荐使用）
 // 参数：
 //   - manager: Redis管理器
 //   - config: 统计配置（config.RedisName指定要使用的Redis连接）
-func NewTrackerFromManager(manager *infraredis.Manager, config *Config) (*Tracker, error) {
+func NewTrackerFromManager(manager *infraredis.Manager, config *Config, opts ...Option) (*Tracker, error) {
 	if config == nil {
 		return nil, ErrInvalidConfig
 	}
@@ -47,7 +103,80 @@ func NewTrackerFromManager(manager *infraredis.Manager, config *Config) (*Tracke
 		return nil, fmt.Errorf("failed to get redis client '%s': %w", redisName, err)
 	}
 
-	return NewTracker(redisClient, config), nil
+	return NewTracker(redisClient, config, opts...), nil
+}
+
+// UpdateConfig 热更新统计配置
+// 新配置校验失败时返回错误，继续使用旧配置
+// 注意：调用后不要再修改config；redis_name只在创建时生效，修改需要重启
+func (t *Tracker) UpdateConfig(config *Config) error {
+	if config == nil {
+		return ErrInvalidConfig
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	loc, err := config.GetLocation()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.config = config
+	t.clientIP = clientip.NewResolver(&config.ClientIP)
+	t.location = loc
+	t.mu.Unlock()
+
+	t.breaker.SetConfig(config.CircuitBreaker)
+	return nil
+}
+
+// getConfig 获取当前生效的配置
+func (t *Tracker) getConfig() *Config {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.config
+}
+
+// getLocation 获取当前生效的时区
+func (t *Tracker) getLocation() *time.Location {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.location == nil {
+		return time.Local
+	}
+	return t.location
+}
+
+// clock 获取按配置时区表示的当前时间
+func (t *Tracker) clock() time.Time {
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	return now().In(t.getLocation())
+}
+
+// parseDate 按配置的时区解析YYYY-MM-DD格式的日期，格式错误时返回ErrInvalidDate
+func (t *Tracker) parseDate(date string) (time.Time, error) {
+	d, err := time.ParseInLocation("2006-01-02", date, t.getLocation())
+	if err != nil {
+		return time.Time{}, ErrInvalidDate
+	}
+	return d, nil
+}
+
+// ClientIP 按配置的可信代理解析请求的客户端IP
+func (t *Tracker) ClientIP(req *http.Request) string {
+	t.mu.RLock()
+	resolver := t.clientIP
+	t.mu.RUnlock()
+
+	if resolver == nil {
+		resolver = clientip.NewResolver(nil)
+	}
+	return resolver.ClientIP(req)
 }
 
 // Track 记录一次访问
@@ -58,44 +187,61 @@ func NewTrackerFromManager(manager *infraredis.Manager, config *Config) (*Tracke
 //
 // 功能:
 //   - PV计数+1（使用INCR）
-//   - UV去重计数（使用Set的SADD，自动去重）
-func (t *Tracker) Track(ctx context.Context, visitorID, path string) error {
+//   - UV去重计数（按uv_strategy写入Set、HyperLogLog或Hash）
+func (t *Tracker) Track(ctx context.Context, visitorID, path string) (err error) {
+	ctx, span := t.startSpan(ctx, "stats.Track", attribute.String(attrPath, path))
+	defer func() { endSpan(span, err) }()
+
+	config := t.getConfig()
+
 	// 如果未启用统计，直接返回
-	if !t.config.Enabled {
+	if !config.Enabled {
 		return nil
 	}
 
 	// 如果路径在排除列表中，不统计
-	if t.config.IsExcludedPath(path) {
+	if config.IsExcludedPath(path) {
 		return nil
 	}
 
-	// 获取当前日期（格式：YYYY-MM-DD）
-	date := time.Now().Format("2006-01-02")
-	expire := t.getExpireTime()
+	// 获取当前日期（格式：YYYY-MM-DD，按配置的时区）
+	now := t.clock()
+	date := now.Format("2006-01-02")
+	expire := t.getExpireTime(config)
+	strategy := config.GetUVStrategy()
+	uv, err := getUVStore(strategy)
+	if err != nil {
+		return err
+	}
 
 	pipe := t.redis.Pipeline()
 
-	// 1. 全站PV统计（String类型，INCR原子递增）
-	pvKey := t.buildPVKey(date, "")
-	pipe.Incr(ctx, pvKey)
-	pipe.Expire(ctx, pvKey, expire)
+	// 1. 全站PV、UV统计，每个启用的时间粒度一组key（按天的key为 stats:pv:YYYY-MM-DD）
+	for _, granularity := range config.EnabledGranularities() {
+		bucket := granularity.format(now)
+		bucketExpire := config.GetGranularityRetention(granularity)
 
-	// 2. 全站UV统计（Set类型，SADD自动去重）
-	uvKey := t.buildUVKey(date, "")
-	pipe.SAdd(ctx, uvKey, visitorID)
-	pipe.Expire(ctx, uvKey, expire)
+		// PV（String类型，INCR原子递增）
+		pvKey := t.buildPVKey(bucket, "")
+		pipe.Incr(ctx, pvKey)
+		pipe.Expire(ctx, pvKey, bucketExpire)
 
-	// 3. 如果启用了路径统计
-	if t.config.EnablePathStats {
+		// UV（按uv_strategy去重）
+		uvKey := t.buildUVKey(strategy, bucket, "")
+		uv.add(ctx, pipe, uvKey, visitorID)
+		pipe.Expire(ctx, uvKey, bucketExpire)
+	}
+
+	// 2. 如果启用了路径统计（只按天）
+	if config.EnablePathStats {
 		// 路径PV
 		pathPVKey := t.buildPVKey(date, path)
 		pipe.Incr(ctx, pathPVKey)
 		pipe.Expire(ctx, pathPVKey, expire)
 
 		// 路径UV
-		pathUVKey := t.buildUVKey(date, path)
-		pipe.SAdd(ctx, pathUVKey, visitorID)
+		pathUVKey := t.buildUVKey(strategy, date, path)
+		uv.add(ctx, pipe, pathUVKey, visitorID)
 		pipe.Expire(ctx, pathUVKey, expire)
 
 		// 记录路径到路径列表（用于查询）
@@ -105,8 +251,10 @@ func (t *Tracker) Track(ctx context.Context, visitorID, path string) error {
 	}
 
 	// 执行Pipeline
-	_, err := pipe.Exec(ctx)
-	return err
+	return t.guardRedis(func() error {
+		_, err := pipe.Exec(ctx)
+		return err
+	})
 }
 
 // GetDailyStats 获取某一天的统计数据
@@ -116,31 +264,27 @@ func (t *Tracker) Track(ctx context.Context, visitorID, path string) error {
 //
 // 返回:
 //   - DailyStats: 包含PV和UV的统计数据
-func (t *Tracker) GetDailyStats(ctx context.Context, date string) (*DailyStats, error) {
+func (t *Tracker) GetDailyStats(ctx context.Context, date string) (_ *DailyStats, err error) {
+	ctx, span := t.startSpan(ctx, "stats.GetDailyStats", attribute.String(attrDate, date))
+	defer func() { endSpan(span, err) }()
+
 	// 验证日期格式
-	_, err := time.Parse("2006-01-02", date)
-	if err != nil {
-		return nil, ErrInvalidDate
+	if _, err = t.parseDate(date); err != nil {
+		return nil, err
 	}
 
-	// 查询PV（String类型，GET命令，O(1)）
-	pvKey := t.buildPVKey(date, "")
-	pvStr, err := t.redis.Get(ctx, pvKey).Result()
-	if err == redis.Nil {
-		pvStr = "0"
-	} else if err != nil {
+	strategy := t.getConfig().GetUVStrategy()
+	store, err := getUVStore(strategy)
+	if err != nil {
 		return nil, err
 	}
-	pv, _ := strconv.ParseInt(pvStr, 10, 64)
 
-	// 查询UV（Set类型，SCARD命令，O(1)）
-	uvKey := t.buildUVKey(date, "")
-	uv, err := t.redis.SCard(ctx, uvKey).Result()
-	if err == redis.Nil {
-		uv = 0
-	} else if err != nil {
+	// 一次往返查询PV（GET）和UV（SCARD/PFCOUNT/HLEN），都是O(1)
+	pvs, uvs, err := t.readCounts(ctx, store, []string{t.buildPVKey(date, "")}, []string{t.buildUVKey(strategy, date, "")})
+	if err != nil {
 		return nil, err
 	}
+	pv, uv := pvs[0], uvs[0]
 
 	return &DailyStats{
 		Date: date,
@@ -159,40 +303,84 @@ func (t *Tracker) GetDailyStats(ctx context.Context, date string) (*DailyStats,
 //   - RangeStats: 包含总计和每日明细
 //
 // 注意:
-//   - TotalPV 是每天PV的累加
+//   - DailyStats 包含范围内的每一天（读取按天的数据，过期后为0）
+//   - 启用rollup时，范围内已汇总的整周、整月另外在Rollups中返回
+//   - TotalPV 是每天PV的累加（已汇总的整周、整月使用Rollups的PV，按天的数据过期后仍然准确）
 //   - TotalUV 是每天UV的累加（会重复计数，如需真实UV请使用GetUniqueUVInRange）
-func (t *Tracker) GetRangeStats(ctx context.Context, startDate, endDate string) (*RangeStats, error) {
+func (t *Tracker) GetRangeStats(ctx context.Context, startDate, endDate string) (_ *RangeStats, err error) {
+	ctx, span := t.startSpan(ctx, "stats.GetRangeStats",
+		attribute.String(attrStartDate, startDate), attribute.String(attrEndDate, endDate))
+	defer func() { endSpan(span, err) }()
+
 	// 验证日期格式和范围
-	start, err := time.Parse("2006-01-02", startDate)
+	start, err := t.parseDate(startDate)
 	if err != nil {
-		return nil, ErrInvalidDate
+		return nil, err
 	}
 
-	end, err := time.Parse("2006-01-02", endDate)
+	end, err := t.parseDate(endDate)
 	if err != nil {
-		return nil, ErrInvalidDate
+		return nil, err
 	}
 
 	if end.Before(start) {
 		return nil, ErrInvalidDate
 	}
 
-	var dailyStats []DailyStats
-	var totalPV, totalUV int64
+	config := t.getConfig()
+	strategy := config.GetUVStrategy()
+	store, err := getUVStore(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	// 启用汇总时，被周/月汇总覆盖的部分读取汇总，其余按天读取
+	plan, err := t.planRange(ctx, config, strategy, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if config.Rollup.Enabled {
+		span.SetAttributes(rollupAttrs(plan)...)
+	}
 
-	// 遍历日期范围，查询每一天的数据
+	// 汇总和每一天的PV、UV一起批量查询
+	var dates []string
 	for current := start; !current.After(end); current = current.AddDate(0, 0, 1) {
-		dateStr := current.Format("2006-01-02")
+		dates = append(dates, current.Format("2006-01-02"))
+	}
+	pvKeys := make([]string, 0, len(plan.buckets)+len(dates))
+	uvKeys := make([]string, 0, len(plan.buckets)+len(dates))
+	for _, bucket := range plan.buckets {
+		pvKeys = append(pvKeys, t.buildRollupPVKey(bucket))
+		uvKeys = append(uvKeys, t.buildRollupUVKey(strategy, bucket))
+	}
+	for _, date := range dates {
+		pvKeys = append(pvKeys, t.buildPVKey(date, ""))
+		uvKeys = append(uvKeys, t.buildUVKey(strategy, date, ""))
+	}
+	pvs, uvs, err := t.readCounts(ctx, store, pvKeys, uvKeys)
+	if err != nil {
+		return nil, err
+	}
 
-		// 查询每天的PV和UV
-		stats, err := t.GetDailyStats(ctx, dateStr)
-		if err != nil {
-			return nil, err
+	var totalPV, totalUV int64
+	var rollups []RollupStats
+	for i, bucket := range plan.buckets {
+		rollups = append(rollups, RollupStats{Bucket: bucket, PV: pvs[i], UV: uvs[i]})
+		totalPV += pvs[i]
+	}
+	uncovered := make(map[string]bool, len(plan.days))
+	for _, date := range plan.days {
+		uncovered[date] = true
+	}
+	dailyStats := make([]DailyStats, 0, len(dates))
+	for i, date := range dates {
+		n := len(plan.buckets) + i
+		dailyStats = append(dailyStats, DailyStats{Date: date, PV: pvs[n], UV: uvs[n]})
+		if uncovered[date] {
+			totalPV += pvs[n]
 		}
-
-		dailyStats = append(dailyStats, *stats)
-		totalPV += stats.PV
-		totalUV += stats.UV
+		totalUV += uvs[n]
 	}
 
 	return &RangeStats{
@@ -201,6 +389,7 @@ func (t *Tracker) GetRangeStats(ctx context.Context, startDate, endDate string)
 		TotalPV:    totalPV,
 		TotalUV:    totalUV, // 注意：这是简单累加，会重复计数
 		DailyStats: dailyStats,
+		Rollups:    rollups,
 	}, nil
 }
 
@@ -214,34 +403,66 @@ func (t *Tracker) GetRangeStats(ctx context.Context, startDate, endDate string)
 //   - 去重后的真实独立访客数
 //
 // 注意:
-//   - 使用SUNION合并多天的Set并自动去重
-//   - 数据量大时较慢，建议查询天数不超过30天
+//   - set策略使用SUNION合并多天的Set并自动去重，数据量大时较慢，建议查询天数不超过30天
+//   - hyperloglog策略使用PFMERGE合并后PFCOUNT，结果有约0.81%的误差
+//   - hash策略取出各天的访客后在程序中去重
+//   - 启用rollup时，已经汇总的整周、整月读取一个汇总key代替多天的key
 //   - 示例：用户A在1号和2号都访问，只计数1次
-func (t *Tracker) GetUniqueUVInRange(ctx context.Context, startDate, endDate string) (int64, error) {
-	start, err := time.Parse("2006-01-02", startDate)
+func (t *Tracker) GetUniqueUVInRange(ctx context.Context, startDate, endDate string) (_ int64, err error) {
+	ctx, span := t.startSpan(ctx, "stats.GetUniqueUVInRange",
+		attribute.String(attrStartDate, startDate), attribute.String(attrEndDate, endDate))
+	defer func() { endSpan(span, err) }()
+
+	start, err := t.parseDate(startDate)
 	if err != nil {
-		return 0, ErrInvalidDate
+		return 0, err
 	}
 
-	end, err := time.Parse("2006-01-02", endDate)
+	end, err := t.parseDate(endDate)
 	if err != nil {
-		return 0, ErrInvalidDate
+		return 0, err
 	}
 
-	// 收集所有UV Set的Key
-	var keys []string
-	for current := start; !current.After(end); current = current.AddDate(0, 0, 1) {
-		dateStr := current.Format("2006-01-02")
-		keys = append(keys, t.buildUVKey(dateStr, ""))
+	config := t.getConfig()
+	strategy := config.GetUVStrategy()
+	store, err := getUVStore(strategy)
+	if err != nil {
+		return 0, err
 	}
 
-	// 使用SUNION合并并去重
-	members, err := t.redis.SUnion(ctx, keys...).Result()
-	if err != nil && err != redis.Nil {
+	// 收集所有UV的Key：启用汇总时优先使用周/月汇总，其余按天
+	plan, err := t.planRange(ctx, config, strategy, start, end)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(rollupAttrs(plan)...)
+	keys := make([]string, 0, len(plan.buckets)+len(plan.days))
+	for _, bucket := range plan.buckets {
+		keys = append(keys, t.buildRollupUVKey(strategy, bucket))
+	}
+	for _, date := range plan.days {
+		keys = append(keys, t.buildUVKey(strategy, date, ""))
+	}
+
+	// 合并并去重
+	var uv int64
+	err = t.guardRedis(func() error {
+		pipe := t.redis.Pipeline()
+		count, err := store.unionCount(ctx, pipe, keys)
+		if err != nil {
+			return err
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+		uv = count()
+		return nil
+	})
+	if err != nil {
 		return 0, err
 	}
 
-	return int64(len(members)), nil
+	return uv, nil
 }
 
 // GetPathStats 获取某天各路径的统计数据
@@ -254,43 +475,61 @@ func (t *Tracker) GetUniqueUVInRange(ctx context.Context, startDate, endDate str
 //
 // 注意:
 //   - 只有当EnablePathStats=true时才有数据
-func (t *Tracker) GetPathStats(ctx context.Context, date string) ([]PathStats, error) {
-	if !t.config.EnablePathStats {
+func (t *Tracker) GetPathStats(ctx context.Context, date string) (_ []PathStats, err error) {
+	ctx, span := t.startSpan(ctx, "stats.GetPathStats", attribute.String(attrDate, date))
+	defer func() { endSpan(span, err) }()
+
+	config := t.getConfig()
+	if !config.EnablePathStats {
 		return []PathStats{}, nil
 	}
+	strategy := config.GetUVStrategy()
+	store, err := getUVStore(strategy)
+	if err != nil {
+		return nil, err
+	}
 
 	// 验证日期格式
-	_, err := time.Parse("2006-01-02", date)
-	if err != nil {
-		return nil, ErrInvalidDate
+	if _, err = t.parseDate(date); err != nil {
+		return nil, err
 	}
 
 	// 获取路径列表
 	pathsKey := t.buildPathsKey(date)
-	paths, err := t.redis.SMembers(ctx, pathsKey).Result()
-	if err == redis.Nil {
-		return []PathStats{}, nil
-	} else if err != nil {
+	var paths []string
+	err = t.guardRedis(func() error {
+		var err error
+		paths, err = t.redis.SMembers(ctx, pathsKey).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
+	if len(paths) == 0 {
+		return []PathStats{}, nil
+	}
 
-	// 查询每个路径的PV和UV
-	var result []PathStats
-
+	// 批量查询每个路径的PV和UV
+	pvKeys := make([]string, 0, len(paths))
+	uvKeys := make([]string, 0, len(paths))
 	for _, path := range paths {
-		// 查询路径PV
-		pvKey := t.buildPVKey(date, path)
-		pvStr, _ := t.redis.Get(ctx, pvKey).Result()
-		pv, _ := strconv.ParseInt(pvStr, 10, 64)
-
-		// 查询路径UV
-		uvKey := t.buildUVKey(date, path)
-		uv, _ := t.redis.SCard(ctx, uvKey).Result()
+		pvKeys = append(pvKeys, t.buildPVKey(date, path))
+		uvKeys = append(uvKeys, t.buildUVKey(strategy, date, path))
+	}
+	pvs, uvs, err := t.readCounts(ctx, store, pvKeys, uvKeys)
+	if err != nil {
+		return nil, err
+	}
 
+	result := make([]PathStats, 0, len(paths))
+	for i, path := range paths {
 		result = append(result, PathStats{
 			Path: path,
-			PV:   pv,
-			UV:   uv,
+			PV:   pvs[i],
+			UV:   uvs[i],
 		})
 	}
 
@@ -301,47 +540,56 @@ func (t *Tracker) GetPathStats(ctx context.Context, date string) ([]PathStats, e
 // 该方法应该由定时任务调用（如每天凌晨执行）
 //
 // 功能:
-//   - 删除超过RetentionDays天的统计数据
+//   - 删除超过RetentionDays天的统计数据（其它时间粒度、周/月汇总按各自的保留时长）
 //   - 释放Redis内存
 //
 // 注意:
 //   - 由于设置了TTL，数据会自动过期
 //   - 此方法主要用于手动清理或修正过期时间
-func (t *Tracker) CleanExpiredData(ctx context.Context) error {
-	retentionDays := t.config.GetRetentionDays()
-	expireDate := time.Now().AddDate(0, 0, -retentionDays)
+func (t *Tracker) CleanExpiredData(ctx context.Context) (err error) {
+	ctx, span := t.startSpan(ctx, "stats.CleanExpiredData")
+	defer func() { endSpan(span, err) }()
+
+	config := t.getConfig()
+	now := t.clock()
 
 	var cursor uint64
 	var deletedCount int
 
 	// 使用SCAN遍历所有stats:*的Key
 	for {
-		keys, newCursor, err := t.redis.Scan(ctx, cursor, "stats:*", 100).Result()
+		var keys []string
+		var newCursor uint64
+		err := t.guardRedis(func() error {
+			var err error
+			keys, newCursor, err = t.redis.Scan(ctx, cursor, "stats:*", 100).Result()
+			return err
+		})
 		if err != nil {
 			return err
 		}
 
 		// 检查每个Key是否过期
+		var expired []string
 		for _, key := range keys {
-			// 从Key中提取日期
-			// stats:pv:2024-01-15 -> 2024-01-15
-			// stats:uv:2024-01-15 -> 2024-01-15
-			parts := strings.Split(key, ":")
-			if len(parts) < 3 {
+			granularity, bucketStart, ok := parseBucket(keyBucket(key), now.Location())
+			if !ok {
 				continue
 			}
 
-			dateStr := parts[2]
-			keyDate, err := time.Parse("2006-01-02", dateStr)
-			if err != nil {
-				continue
+			// 如果早于该粒度的保留时长，删除
+			if bucketStart.Before(now.Add(-config.GetGranularityRetention(granularity))) {
+				expired = append(expired, key)
 			}
-
-			// 如果早于过期日期，删除
-			if keyDate.Before(expireDate) {
-				t.redis.Del(ctx, key)
-				deletedCount++
+		}
+		if len(expired) > 0 {
+			err := t.guardRedis(func() error {
+				return t.redis.Del(ctx, expired...).Err()
+			})
+			if err != nil {
+				return err
 			}
+			deletedCount += len(expired)
 		}
 
 		cursor = newCursor
@@ -350,9 +598,93 @@ func (t *Tracker) CleanExpiredData(ctx context.Context) error {
 		}
 	}
 
+	t.log().InfoContext(ctx, "stats: expired data cleaned", slog.Int("deleted", deletedCount))
 	return nil
 }
 
+// keyBucket 从统计Key中提取bucket，不是按bucket存储的Key（如汇总的meta）返回的值无法解析
+// stats:pv:2024-01-15、stats:uv:2024-01-15T10:/api/users -> 第三段（路径中可能包含冒号）
+// stats:rollup:pv:2024-W03、stats:rollup:uv:2024-01 -> 最后一段
+func keyBucket(key string) string {
+	if strings.HasPrefix(key, rollupKeyPrefix) {
+		return key[strings.LastIndex(key, ":")+1:]
+	}
+	parts := strings.SplitN(key, ":", 4)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// maxBatchSize 批量查询时一个Pipeline最多包含的日期或路径数
+const maxBatchSize = 500
+
+// readCounts 批量查询PV和UV，返回值与pvKeys、uvKeys一一对应，key不存在时为0
+// 每maxBatchSize个key一个Pipeline（PV一条MGET，UV每个key一条计数命令），一批只有一次往返；
+// 分批避免范围很大或路径很多时单个请求过大、长时间阻塞Redis
+func (t *Tracker) readCounts(ctx context.Context, store uvStore, pvKeys, uvKeys []string) (pvs, uvs []int64, err error) {
+	pvs = make([]int64, len(pvKeys))
+	uvs = make([]int64, len(uvKeys))
+	for start := 0; start < len(pvKeys); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(pvKeys) {
+			end = len(pvKeys)
+		}
+
+		err := t.guardRedis(func() error {
+			pipe := t.redis.Pipeline()
+			pvCmd := pipe.MGet(ctx, pvKeys[start:end]...)
+			uvCmds := make([]*redis.IntCmd, 0, end-start)
+			for _, key := range uvKeys[start:end] {
+				uvCmds = append(uvCmds, store.count(ctx, pipe, key))
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+
+			for i, value := range pvCmd.Val() {
+				if s, ok := value.(string); ok {
+					pvs[start+i], _ = strconv.ParseInt(s, 10, 64)
+				}
+			}
+			for i, cmd := range uvCmds {
+				uvs[start+i] = cmd.Val()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return pvs, uvs, nil
+}
+
+// guardRedis 通过熔断器执行Redis调用
+// 熔断器打开时不执行fn，直接返回ErrCircuitOpen；只有连接、超时等Redis不可用的错误计为失败，
+// 调用方取消或超时（context.Canceled/DeadlineExceeded）和Redis返回的错误回复（如WRONGTYPE）不计入
+func (t *Tracker) guardRedis(fn func() error) error {
+	done, err := t.breaker.Allow()
+	if err != nil {
+		return ErrCircuitOpen
+	}
+	err = fn()
+	if redisUnavailable(err) {
+		done(err)
+	} else {
+		done(nil)
+	}
+	return err
+}
+
+// redisUnavailable 错误是否说明Redis不可用（计入熔断器的失败）
+func redisUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
+
 // Close 关闭追踪器，释放Redis连接
 func (t *Tracker) Close() error {
 	if t.redis != nil {
@@ -375,12 +707,22 @@ func (t *Tracker) buildPVKey(date, path string) string {
 }
 
 // buildUVKey 构建UV统计的Redis Key
-// 格式: stats:uv:YYYY-MM-DD 或 stats:uv:YYYY-MM-DD:/api/users
-func (t *Tracker) buildUVKey(date, path string) string {
+// 格式: stats:uv:YYYY-MM-DD 或 stats:uv:YYYY-MM-DD:/api/users（set策略）
+// 其它策略的类型不同，使用单独的前缀: stats:uv_hyperloglog:YYYY-MM-DD、stats:uv_hash:YYYY-MM-DD
+func (t *Tracker) buildUVKey(strategy, date, path string) string {
+	prefix := "stats:" + uvKeyName(strategy)
 	if path == "" {
-		return fmt.Sprintf("stats:uv:%s", date)
+		return fmt.Sprintf("%s:%s", prefix, date)
+	}
+	return fmt.Sprintf("%s:%s:%s", prefix, date, path)
+}
+
+// uvKeyName UV key中表示类型的部分：set策略为uv，其它策略为uv_<策略名>
+func uvKeyName(strategy string) string {
+	if strategy == UVStrategySet {
+		return "uv"
 	}
-	return fmt.Sprintf("stats:uv:%s:%s", date, path)
+	return "uv_" + strategy
 }
 
 // buildPathsKey 构建路径列表的Redis Key
@@ -390,7 +732,7 @@ func (t *Tracker) buildPathsKey(date string) string {
 }
 
 // getExpireTime 获取Key的过期时间（秒）
-func (t *Tracker) getExpireTime() time.Duration {
-	days := t.config.GetRetentionDays()
+func (t *Tracker) getExpireTime(config *Config) time.Duration {
+	days := config.GetRetentionDays()
 	return time.Duration(days) * 24 * time.Hour
 }
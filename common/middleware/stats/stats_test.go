@@ -1,13 +1,30 @@
 package stats
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"working-project/common/breaker"
+	"working-project/common/logging"
 )
 
 // setupTestRedis 创建测试用的Redis实例（使用miniredis模拟）
@@ -241,6 +258,102 @@ func TestTracker_CleanExpiredData(t *testing.T) {
 	_ = ctx
 }
 
+// TestTracker_CleanExpiredData_Rollup 测试按各粒度的保留时长清理按天的数据和周/月汇总
+func TestTracker_CleanExpiredData_Rollup(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{Enabled: true, Timezone: "UTC", RetentionDays: 7}
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	tracker := NewTracker(rdb, config, WithClock(func() time.Time { return now }), WithLogger(logger))
+	ctx := context.Background()
+
+	expired := []string{
+		tracker.buildPVKey("2024-02-01", ""),
+		tracker.buildPVKey("2024-02-01", "/users/:id"),
+		tracker.buildRollupPVKey("2021-12"),
+		tracker.buildRollupUVKey(UVStrategySet, "2023-W01"),
+	}
+	kept := []string{
+		tracker.buildPVKey("2024-02-28", ""),
+		tracker.buildPVKey("2024-02-28", "/users/:id"),
+		tracker.buildRollupPVKey("2024-01"),
+		tracker.buildRollupUVKey(UVStrategySet, "2024-W05"),
+		tracker.buildRollupMetaKey(UVStrategySet),
+	}
+	for _, key := range append(append([]string{}, expired...), kept...) {
+		if err := rdb.Set(ctx, key, 1, 0).Err(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := tracker.CleanExpiredData(ctx); err != nil {
+		t.Fatalf("expected no error on CleanExpiredData, got %v", err)
+	}
+	for _, key := range expired {
+		if cast.ToInt64(rdb.Exists(ctx, key).Val()) != 0 {
+			t.Errorf("expected %s to be deleted", key)
+		}
+	}
+	for _, key := range kept {
+		if cast.ToInt64(rdb.Exists(ctx, key).Val()) != 1 {
+			t.Errorf("expected %s to be kept", key)
+		}
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected 1 JSON log entry, got %q", logs.String())
+	}
+	if cast.ToInt(entry["deleted"]) != len(expired) {
+		t.Errorf("expected %d deleted keys in log, got %v", len(expired), entry["deleted"])
+	}
+}
+
+// TestMiddleware_TrackFailureMetrics 测试Track失败时不影响请求，并记录指标和日志
+func TestMiddleware_TrackFailureMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rdb := setupTestRedis(t)
+	metrics := NewMetrics(nil)
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	tracker := NewTracker(rdb, &Config{Enabled: true}, WithMetrics(metrics), WithLogger(logger))
+	_ = rdb.Close()
+
+	r := gin.New()
+	r.Use(Middleware(tracker))
+	r.GET("/api/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if cast.ToInt(w.Code) != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if got := testutil.ToFloat64(metrics.trackFailures); cast.ToInt(got) != 1 {
+		t.Errorf("expected 1 track failure, got %v", got)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected 1 JSON log entry, got %q", logs.String())
+	}
+	if cast.ToString(entry["key_hash"]) != logging.HashKey("Bearer secret-token") ||
+		cast.ToString(entry["path"]) != "/api/test" || cast.ToString(entry["request_id"]) != "req-1" {
+		t.Errorf("expected structured fields in log, got %v", entry)
+	}
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("expected visitor ID not to be logged, got %s", logs.String())
+	}
+}
+
 // TestConfig_IsExcludedPath 测试路径排除逻辑
 func TestConfig_IsExcludedPath(t *testing.T) {
 	config := &Config{
@@ -335,3 +448,897 @@ func BenchmarkTracker_Track(b *testing.B) {
 		_ = tracker.Track(ctx, "user1", "/api/test")
 	}
 }
+
+// TestTracker_Tracing 测试Track和查询方法的span
+func TestTracker_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	rdb := setupTestRedis(t)
+	tracker := NewTracker(rdb, &Config{Enabled: true, EnablePathStats: true}, WithTracerProvider(provider))
+	ctx := context.Background()
+	today := time.Now().Format("2006-01-02")
+
+	if err := tracker.Track(ctx, "user1", "/api/users"); err != nil {
+		t.Fatalf("expected no error on Track, got %v", err)
+	}
+	if _, err := tracker.GetRangeStats(ctx, today, today); err != nil {
+		t.Fatalf("expected no error on GetRangeStats, got %v", err)
+	}
+	if _, err := tracker.GetDailyStats(ctx, "bad-date"); err == nil {
+		t.Fatalf("expected error on invalid date")
+	}
+
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	// GetRangeStats批量查询，不再为每一天创建GetDailyStats子span
+	want := []string{"stats.Track", "stats.GetRangeStats", "stats.GetDailyStats"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected spans %v, got %v", want, names)
+	}
+	for _, kv := range spans[0].Attributes {
+		if string(kv.Key) == attrPath && cast.ToString(kv.Value.AsString()) != "/api/users" {
+			t.Errorf("expected path attribute /api/users, got %s", kv.Value.AsString())
+		}
+	}
+	if spans[2].Status.Code != codes.Error {
+		t.Errorf("expected invalid date to be recorded as span error, got %v", spans[2].Status)
+	}
+}
+
+// TestTracker_CircuitBreaker 测试Redis连续失败后Track直接返回ErrCircuitOpen
+func TestTracker_CircuitBreaker(t *testing.T) {
+	rdb := setupTestRedis(t)
+	_ = rdb.Close()
+	config := &Config{
+		Enabled:        true,
+		CircuitBreaker: breaker.Config{Enabled: true, FailureThreshold: 2, OpenTimeout: time.Minute},
+	}
+	tracker := NewTracker(rdb, config, WithLogger(logging.Discard()))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := tracker.Track(ctx, "user1", "/api/users"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected Redis error, got %v", err)
+		}
+	}
+	if err := tracker.Track(ctx, "user1", "/api/users"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if _, err := tracker.GetDailyStats(ctx, time.Now().Format("2006-01-02")); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("expected queries to fail fast with ErrRedisUnavailable, got %v", err)
+	}
+	// 参数错误不经过熔断器
+	if _, err := tracker.GetDailyStats(ctx, "bad-date"); !errors.Is(err, ErrInvalidDate) {
+		t.Errorf("expected ErrInvalidDate, got %v", err)
+	}
+}
+
+// TestTracker_CircuitBreaker_IgnoredErrors 测试调用方取消和Redis的错误回复不计入熔断器的失败
+func TestTracker_CircuitBreaker_IgnoredErrors(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled:        true,
+		CircuitBreaker: breaker.Config{Enabled: true, FailureThreshold: 2, OpenTimeout: time.Minute},
+	}
+	tracker := NewTracker(rdb, config, WithLogger(logging.Discard()))
+	ctx := context.Background()
+	today := time.Now().Format("2006-01-02")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for i := 0; i < 3; i++ {
+		if err := tracker.Track(canceled, "user1", "/api/users"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	}
+
+	// UV key类型错误，Redis返回WRONGTYPE
+	if err := rdb.Set(ctx, tracker.buildUVKey(config.GetUVStrategy(), today, ""), "x", 0).Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		err := tracker.Track(ctx, "user1", "/api/users")
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected WRONGTYPE error, got %v", err)
+		}
+	}
+
+	if _, err := tracker.GetDailyStats(ctx, time.Now().AddDate(0, 0, -1).Format("2006-01-02")); err != nil {
+		t.Errorf("expected breaker to stay closed, got %v", err)
+	}
+}
+
+// TestTracker_UVStrategies 测试各UV存储策略的单日UV、跨天去重和路径UV
+func TestTracker_UVStrategies(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range []string{UVStrategySet, UVStrategyHyperLogLog, UVStrategyHash} {
+		strategy := strategy
+		t.Run(strategy, func(t *testing.T) {
+			t.Parallel()
+
+			rdb := setupTestRedis(t)
+			config := &Config{Enabled: true, EnablePathStats: true, UVStrategy: strategy}
+			tracker := NewTracker(rdb, config)
+			ctx := context.Background()
+			today := time.Now().Format("2006-01-02")
+			yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+			for _, visitor := range []string{"user1", "user1", "user2"} {
+				if err := tracker.Track(ctx, visitor, "/api/users"); err != nil {
+					t.Fatalf("expected no error on Track, got %v", err)
+				}
+			}
+			// 前一天的访问直接写入
+			store, _ := getUVStore(strategy)
+			_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				store.add(ctx, pipe, tracker.buildUVKey(strategy, yesterday, ""), "user1")
+				store.add(ctx, pipe, tracker.buildUVKey(strategy, yesterday, ""), "user3")
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			daily, err := tracker.GetDailyStats(ctx, today)
+			if err != nil {
+				t.Fatalf("expected no error on GetDailyStats, got %v", err)
+			}
+			if cast.ToInt64(daily.PV) != 3 || cast.ToInt64(daily.UV) != 2 {
+				t.Errorf("expected PV=3 UV=2, got PV=%d UV=%d", daily.PV, daily.UV)
+			}
+
+			uv, err := tracker.GetUniqueUVInRange(ctx, yesterday, today)
+			if err != nil {
+				t.Fatalf("expected no error on GetUniqueUVInRange, got %v", err)
+			}
+			if cast.ToInt64(uv) != 3 {
+				t.Errorf("expected 3 unique visitors, got %d", uv)
+			}
+
+			paths, err := tracker.GetPathStats(ctx, today)
+			if err != nil {
+				t.Fatalf("expected no error on GetPathStats, got %v", err)
+			}
+			if len(paths) != 1 || cast.ToInt64(paths[0].PV) != 3 || cast.ToInt64(paths[0].UV) != 2 {
+				t.Errorf("expected /api/users PV=3 UV=2, got %+v", paths)
+			}
+
+			// 只有hash策略记录了每个访客的访问次数
+			count, err := tracker.GetVisitorCount(ctx, today, "user1")
+			if strategy != UVStrategyHash {
+				if !errors.Is(err, ErrUnsupportedStrategy) {
+					t.Errorf("expected ErrUnsupportedStrategy, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error on GetVisitorCount, got %v", err)
+			}
+			if cast.ToInt64(count) != 2 {
+				t.Errorf("expected user1 to visit 2 times, got %d", count)
+			}
+		})
+	}
+}
+
+// TestConfig_Validate_UVStrategy 测试未知的UV存储策略
+func TestConfig_Validate_UVStrategy(t *testing.T) {
+	config := &Config{UVStrategy: "bitmap"}
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+	config.UVStrategy = UVStrategyHyperLogLog
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+// TestTracker_GetSeries 测试按小时、分钟的时间序列连续且没有数据的bucket为0
+func TestTracker_GetSeries(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled:       true,
+		Granularities: map[Granularity]time.Duration{GranularityHour: 48 * time.Hour, GranularityMinute: 0},
+	}
+	tracker := NewTracker(rdb, config)
+	ctx := context.Background()
+
+	for _, visitor := range []string{"user1", "user1", "user2"} {
+		if err := tracker.Track(ctx, visitor, "/api/users"); err != nil {
+			t.Fatalf("expected no error on Track, got %v", err)
+		}
+	}
+	now := time.Now()
+
+	tests := []struct {
+		metric      string
+		granularity Granularity
+		from        time.Time
+		points      int
+		last        int64
+	}{
+		{MetricPV, GranularityHour, now.Add(-2 * time.Hour), 3, 3},
+		{MetricUV, GranularityHour, now.Add(-2 * time.Hour), 3, 2},
+		{MetricPV, GranularityMinute, now.Add(-9 * time.Minute), 10, 3},
+		{MetricUV, GranularityDay, now.AddDate(0, 0, -6), 7, 2},
+	}
+	for _, tt := range tests {
+		series, err := tracker.GetSeries(ctx, tt.metric, tt.granularity, tt.from, now)
+		if err != nil {
+			t.Fatalf("%s/%s: expected no error, got %v", tt.metric, tt.granularity, err)
+		}
+		if len(series.Points) != tt.points {
+			t.Fatalf("%s/%s: expected %d points, got %d", tt.metric, tt.granularity, tt.points, len(series.Points))
+		}
+		for i, point := range series.Points[:len(series.Points)-1] {
+			if cast.ToInt64(point.Value) != 0 {
+				t.Errorf("%s/%s: expected point %d to be 0, got %d", tt.metric, tt.granularity, i, point.Value)
+			}
+			if !tt.granularity.next(point.Time).Equal(series.Points[i+1].Time) {
+				t.Errorf("%s/%s: expected consecutive buckets, got %v then %v",
+					tt.metric, tt.granularity, point.Time, series.Points[i+1].Time)
+			}
+		}
+		if last := series.Points[len(series.Points)-1]; cast.ToInt64(last.Value) != tt.last ||
+			last.Bucket != tt.granularity.format(now) {
+			t.Errorf("%s/%s: expected last point %s=%d, got %s=%d",
+				tt.metric, tt.granularity, tt.granularity.format(now), tt.last, last.Bucket, last.Value)
+		}
+	}
+
+	// 未启用的粒度和未知的指标
+	if _, err := tracker.GetSeries(ctx, MetricPV, GranularityWeek, now, now); !errors.Is(err, ErrInvalidSeries) {
+		t.Errorf("expected ErrInvalidSeries for disabled granularity, got %v", err)
+	}
+	if _, err := tracker.GetSeries(ctx, "sessions", GranularityDay, now, now); !errors.Is(err, ErrInvalidSeries) {
+		t.Errorf("expected ErrInvalidSeries for unknown metric, got %v", err)
+	}
+	if _, err := tracker.GetSeries(ctx, MetricPV, GranularityMinute, now.AddDate(-1, 0, 0), now); !errors.Is(err, ErrInvalidSeries) {
+		t.Errorf("expected ErrInvalidSeries for too many points, got %v", err)
+	}
+	if _, err := tracker.GetSeries(ctx, MetricPV, GranularityDay, now, now.Add(-time.Hour)); !errors.Is(err, ErrStartDate) {
+		t.Errorf("expected ErrStartDate, got %v", err)
+	}
+
+	// 各粒度的key按各自的保留时长过期
+	if ttl := rdb.TTL(ctx, tracker.buildPVKey(GranularityHour.format(now), "")).Val(); ttl != 48*time.Hour {
+		t.Errorf("expected hour bucket TTL 48h, got %v", ttl)
+	}
+	if ttl := rdb.TTL(ctx, tracker.buildPVKey(GranularityMinute.format(now), "")).Val(); ttl != 24*time.Hour {
+		t.Errorf("expected minute bucket default TTL 24h, got %v", ttl)
+	}
+}
+
+// TestGranularity_Buckets 测试各粒度bucket的划分、名称和解析
+func TestGranularity_Buckets(t *testing.T) {
+	t.Parallel()
+
+	// 2024-01-03是周三
+	ts := time.Date(2024, time.January, 3, 10, 42, 30, 0, time.UTC)
+	tests := []struct {
+		granularity Granularity
+		start       time.Time
+		next        time.Time
+		bucket      string
+	}{
+		{GranularityMinute, time.Date(2024, 1, 3, 10, 42, 0, 0, time.UTC), time.Date(2024, 1, 3, 10, 43, 0, 0, time.UTC), "2024-01-03T1042"},
+		{GranularityHour, time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC), "2024-01-03T10"},
+		{GranularityDay, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), "2024-01-03"},
+		{GranularityWeek, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), "2024-W01"},
+		{GranularityMonth, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), "2024-01"},
+	}
+	for _, tt := range tests {
+		start := tt.granularity.truncate(ts)
+		if !start.Equal(tt.start) {
+			t.Errorf("%s: expected start %v, got %v", tt.granularity, tt.start, start)
+		}
+		if next := tt.granularity.next(start); !next.Equal(tt.next) {
+			t.Errorf("%s: expected next %v, got %v", tt.granularity, tt.next, next)
+		}
+		if bucket := tt.granularity.format(ts); cast.ToString(bucket) != tt.bucket {
+			t.Errorf("%s: expected bucket %s, got %s", tt.granularity, tt.bucket, bucket)
+		}
+		granularity, parsed, ok := parseBucket(tt.bucket, time.UTC)
+		if !ok || granularity != tt.granularity || !parsed.Equal(tt.start) {
+			t.Errorf("%s: expected to parse %s as %v, got %s %v %v", tt.granularity, tt.bucket, tt.start, granularity, parsed, ok)
+		}
+	}
+
+	// ISO周可能属于上一年
+	if bucket := GranularityWeek.format(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)); bucket != "2020-W53" {
+		t.Errorf("expected 2020-W53, got %s", bucket)
+	}
+	if _, _, ok := parseBucket("2024-W54", time.UTC); ok {
+		t.Errorf("expected invalid week to be rejected")
+	}
+}
+
+// TestConfig_Validate_Granularities 测试时间粒度配置校验
+func TestConfig_Validate_Granularities(t *testing.T) {
+	tests := []struct {
+		name          string
+		granularities map[Granularity]time.Duration
+		wantErr       bool
+	}{
+		{"valid", map[Granularity]time.Duration{GranularityHour: time.Hour, GranularityMonth: 0}, false},
+		{"unknown granularity", map[Granularity]time.Duration{"second": time.Hour}, true},
+		{"day uses retention_days", map[Granularity]time.Duration{GranularityDay: time.Hour}, true},
+		{"negative retention", map[Granularity]time.Duration{GranularityMinute: -time.Hour}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Granularities: tt.granularities}
+			err := config.Validate()
+			if cast.ToBool(err != nil) != tt.wantErr {
+				t.Errorf("expected error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestTracker_Timezone 测试按配置的时区划分天，与服务器所在时区无关
+func TestTracker_Timezone(t *testing.T) {
+	t.Parallel()
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	rdb := setupTestRedis(t)
+	config := &Config{Enabled: true, Timezone: "Asia/Shanghai"}
+	ctx := context.Background()
+
+	// 同一时刻，两个实例的本地时区不同：UTC为1月15日，上海为1月16日
+	instant := time.Date(2024, time.January, 15, 17, 30, 0, 0, time.UTC)
+	for _, loc := range []*time.Location{time.UTC, newYork} {
+		loc := loc
+		tracker := NewTracker(rdb, config, WithClock(func() time.Time { return instant.In(loc) }))
+		if err := tracker.Track(ctx, "user1", "/api/users"); err != nil {
+			t.Fatalf("expected no error on Track, got %v", err)
+		}
+	}
+
+	tracker := NewTracker(rdb, config)
+	daily, err := tracker.GetDailyStats(ctx, "2024-01-16")
+	if err != nil {
+		t.Fatalf("expected no error on GetDailyStats, got %v", err)
+	}
+	if cast.ToInt64(daily.PV) != 2 || cast.ToInt64(daily.UV) != 1 {
+		t.Errorf("expected both instances to write 2024-01-16 (PV=2 UV=1), got PV=%d UV=%d", daily.PV, daily.UV)
+	}
+
+	config = &Config{Timezone: "Mars/Olympus_Mons"}
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for unknown timezone, got %v", err)
+	}
+}
+
+// TestTracker_GetSeries_InTimezone 测试按报表时区查询，bucket不对齐时由按小时的数据汇总
+func TestTracker_GetSeries_InTimezone(t *testing.T) {
+	t.Parallel()
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled:       true,
+		Timezone:      "UTC",
+		Granularities: map[Granularity]time.Duration{GranularityHour: 48 * time.Hour},
+	}
+	now := time.Date(2024, time.January, 15, 15, 0, 0, 0, time.UTC)
+	tracker := NewTracker(rdb, config, WithClock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	// UTC 15:00（上海1月15日23:00）user1；UTC 17:00（上海1月16日01:00）user1、user2
+	track := func(visitor string) {
+		if err := tracker.Track(ctx, visitor, "/api/users"); err != nil {
+			t.Fatalf("expected no error on Track, got %v", err)
+		}
+	}
+	track("user1")
+	now = now.Add(2 * time.Hour)
+	track("user1")
+	track("user2")
+
+	from := time.Date(2024, time.January, 15, 0, 0, 0, 0, shanghai)
+	to := time.Date(2024, time.January, 16, 0, 0, 0, 0, shanghai)
+	for _, tt := range []struct {
+		metric string
+		want   []int64
+	}{
+		{MetricPV, []int64{1, 2}},
+		{MetricUV, []int64{1, 2}},
+	} {
+		series, err := tracker.GetSeries(ctx, tt.metric, GranularityDay, from, to, InTimezone(shanghai))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.metric, err)
+		}
+		if len(series.Points) != len(tt.want) {
+			t.Fatalf("%s: expected %d points, got %d", tt.metric, len(tt.want), len(series.Points))
+		}
+		for i, want := range tt.want {
+			point := series.Points[i]
+			if cast.ToInt64(point.Value) != want || point.Time.Location() != shanghai {
+				t.Errorf("%s: expected point %d to be %d in Asia/Shanghai, got %d at %v", tt.metric, i, want, point.Value, point.Time)
+			}
+		}
+		if series.Points[1].Bucket != "2024-01-16" {
+			t.Errorf("expected bucket 2024-01-16, got %s", series.Points[1].Bucket)
+		}
+	}
+
+	// 存储时区下仍是同一天（上海1月16日00:00是UTC 1月15日16:00）
+	series, err := tracker.GetSeries(ctx, MetricPV, GranularityDay, to, to)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if last := series.Points[len(series.Points)-1]; cast.ToInt64(last.Value) != 3 || last.Bucket != "2024-01-15" {
+		t.Errorf("expected 2024-01-15 PV=3 in UTC, got %s=%d", last.Bucket, last.Value)
+	}
+
+	// 没有更细的粒度可以汇总
+	config = &Config{Enabled: true, Timezone: "UTC"}
+	if err := tracker.UpdateConfig(config); err != nil {
+		t.Fatalf("expected no error on UpdateConfig, got %v", err)
+	}
+	if _, err := tracker.GetSeries(ctx, MetricPV, GranularityDay, from, to, InTimezone(shanghai)); !errors.Is(err, ErrInvalidSeries) {
+		t.Errorf("expected ErrInvalidSeries without hour buckets, got %v", err)
+	}
+}
+
+// TestTracker_GetSeries_BatchReads 测试由多个存储bucket汇总的序列分批读取，每批一次往返
+func TestTracker_GetSeries_BatchReads(t *testing.T) {
+	t.Parallel()
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	for _, strategy := range []string{UVStrategySet, UVStrategyHyperLogLog, UVStrategyHash} {
+		strategy := strategy
+		t.Run(strategy, func(t *testing.T) {
+			t.Parallel()
+
+			rdb := setupTestRedis(t)
+			counter := &roundTripCounter{}
+			rdb.AddHook(counter)
+			config := &Config{
+				Enabled:       true,
+				Timezone:      "UTC",
+				UVStrategy:    strategy,
+				Granularities: map[Granularity]time.Duration{GranularityHour: 0},
+			}
+			tracker := NewTracker(rdb, config)
+			ctx := context.Background()
+
+			// 每小时PV=1，访客都是user1
+			store, _ := getUVStore(strategy)
+			_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				start := time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC)
+				for hour := start; hour.Before(start.AddDate(0, 0, 33)); hour = hour.Add(time.Hour) {
+					bucket := GranularityHour.format(hour)
+					pipe.IncrBy(ctx, tracker.buildPVKey(bucket, ""), 1)
+					store.add(ctx, pipe, tracker.buildUVKey(strategy, bucket, ""), "user1")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// 31天×24小时=744个bucket，每批最多maxBatchSize个，分2批
+			from := time.Date(2024, time.January, 1, 0, 0, 0, 0, shanghai)
+			to := time.Date(2024, time.January, 31, 0, 0, 0, 0, shanghai)
+			for _, tt := range []struct {
+				metric string
+				want   int64
+			}{
+				{MetricPV, 24},
+				{MetricUV, 1},
+			} {
+				counter.count.Store(0)
+				series, err := tracker.GetSeries(ctx, tt.metric, GranularityDay, from, to, InTimezone(shanghai))
+				if err != nil {
+					t.Fatalf("%s: expected no error, got %v", tt.metric, err)
+				}
+				if got := counter.count.Load(); got != 2 {
+					t.Errorf("%s: expected 2 round trips, got %d", tt.metric, got)
+				}
+				if len(series.Points) != 31 {
+					t.Fatalf("%s: expected 31 points, got %d", tt.metric, len(series.Points))
+				}
+				for _, point := range series.Points {
+					if cast.ToInt64(point.Value) != tt.want {
+						t.Errorf("%s: expected %s to be %d, got %d", tt.metric, point.Bucket, tt.want, point.Value)
+					}
+				}
+			}
+
+			// 临时key已经删除
+			if keys := rdb.Keys(ctx, "stats:tmp:*").Val(); len(keys) != 0 {
+				t.Errorf("expected no temporary keys, got %v", keys)
+			}
+		})
+	}
+}
+
+// TestTracker_Rollup 测试周/月汇总，按天的数据被删除后范围查询仍由汇总得出
+func TestTracker_Rollup(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range []string{UVStrategySet, UVStrategyHyperLogLog, UVStrategyHash} {
+		strategy := strategy
+		t.Run(strategy, func(t *testing.T) {
+			t.Parallel()
+
+			rdb := setupTestRedis(t)
+			config := &Config{
+				Enabled:    true,
+				Timezone:   "UTC",
+				UVStrategy: strategy,
+				Rollup:     RollupConfig{Enabled: true},
+			}
+			// 2024-01-01是周一，每天有common和当天独有的访客各访问一次
+			now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+			tracker := NewTracker(rdb, config, WithClock(func() time.Time { return now }))
+			ctx := context.Background()
+			for day := 1; day <= 20; day++ {
+				for _, visitor := range []string{"common", "visitor" + cast.ToString(day)} {
+					if err := tracker.Track(ctx, visitor, "/api/users"); err != nil {
+						t.Fatalf("expected no error on Track, got %v", err)
+					}
+				}
+				now = now.AddDate(0, 0, 1)
+			}
+
+			// 1月21日汇总：retention_days（90天，从2023-10-23开始）内的周和月，1月和W03汇总到20日
+			if err := tracker.Rollup(ctx); err != nil {
+				t.Fatalf("expected no error on Rollup, got %v", err)
+			}
+			covered, err := rdb.HGetAll(ctx, tracker.buildRollupMetaKey(strategy)).Result()
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for bucket, want := range map[string]string{
+				"2024-01":  "2024-01-20",
+				"2024-W01": "2024-01-07",
+				"2024-W02": "2024-01-14",
+				"2024-W03": "2024-01-20",
+				"2023-11":  "2023-11-30",
+				"2023-W43": "2023-10-29",
+			} {
+				if covered[bucket] != want {
+					t.Errorf("expected %s to be covered through %s, got %q", bucket, want, covered[bucket])
+				}
+			}
+			// 10月从retention_days之前开始，按天的数据不完整
+			if _, ok := covered["2023-10"]; ok {
+				t.Errorf("expected 2023-10 not to be rolled up, got %v", covered)
+			}
+
+			// 删除被汇总覆盖的按天数据
+			for day := 8; day <= 20; day++ {
+				date := time.Date(2024, time.January, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+				if err := rdb.Del(ctx, tracker.buildPVKey(date, ""), tracker.buildUVKey(strategy, date, "")).Err(); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			tests := []struct {
+				start, end string
+				wantUV     int64
+			}{
+				{"2024-01-01", "2024-01-20", 21}, // 月汇总
+				{"2024-01-02", "2024-01-20", 20}, // 2日到7日按天，W02、W03汇总
+				{"2024-01-02", "2024-01-14", 14}, // 2日到7日按天，W02汇总
+			}
+			for _, tt := range tests {
+				uv, err := tracker.GetUniqueUVInRange(ctx, tt.start, tt.end)
+				if err != nil {
+					t.Fatalf("expected no error on GetUniqueUVInRange, got %v", err)
+				}
+				if cast.ToInt64(uv) != tt.wantUV {
+					t.Errorf("%s~%s: expected %d unique visitors, got %d", tt.start, tt.end, tt.wantUV, uv)
+				}
+			}
+
+			stats, err := tracker.GetRangeStats(ctx, "2024-01-02", "2024-01-20")
+			if err != nil {
+				t.Fatalf("expected no error on GetRangeStats, got %v", err)
+			}
+			if cast.ToInt64(stats.TotalPV) != 38 {
+				t.Errorf("expected total PV 38, got %d", stats.TotalPV)
+			}
+			// 每一天都在DailyStats中（8日之后按天的数据已删除，为0），W02、W03另外作为汇总返回
+			if len(stats.DailyStats) != 19 || len(stats.Rollups) != 2 ||
+				stats.Rollups[0].Bucket != "2024-W02" || stats.Rollups[1].Bucket != "2024-W03" {
+				t.Fatalf("expected 19 days and rollups W02, W03, got %d days and %+v", len(stats.DailyStats), stats.Rollups)
+			}
+			if daily := stats.DailyStats[0]; daily.Date != "2024-01-02" || cast.ToInt64(daily.PV) != 2 {
+				t.Errorf("expected 2024-01-02 PV=2, got %s PV=%d", daily.Date, daily.PV)
+			}
+			if daily := stats.DailyStats[18]; daily.Date != "2024-01-20" || cast.ToInt64(daily.PV) != 0 {
+				t.Errorf("expected 2024-01-20 PV=0 after deletion, got %s PV=%d", daily.Date, daily.PV)
+			}
+			if cast.ToInt64(stats.Rollups[0].UV) != 8 || cast.ToInt64(stats.Rollups[1].UV) != 7 {
+				t.Errorf("expected rollup UV 8 and 7, got %d and %d", stats.Rollups[0].UV, stats.Rollups[1].UV)
+			}
+
+			// 汇总没有完整覆盖的范围按天读取（W03只汇总到20日）
+			uv, err := tracker.GetUniqueUVInRange(ctx, "2024-01-15", "2024-01-19")
+			if err != nil {
+				t.Fatalf("expected no error on GetUniqueUVInRange, got %v", err)
+			}
+			if uv != 0 {
+				t.Errorf("expected 0 unique visitors from the deleted days, got %d", uv)
+			}
+		})
+	}
+}
+
+// TestTracker_RollupRange 测试修复按天的数据后重新汇总包含这些天的周和月
+func TestTracker_RollupRange(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{Enabled: true, Timezone: "UTC", Rollup: RollupConfig{Enabled: true}}
+	now := time.Date(2024, time.January, 21, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(rdb, config, WithClock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	if err := rdb.Set(ctx, tracker.buildPVKey("2024-01-10", ""), 5, 0).Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := tracker.Rollup(ctx); err != nil {
+		t.Fatalf("expected no error on Rollup, got %v", err)
+	}
+
+	// 修复1月10日的数据，Rollup认为已经汇总到最新，不重新计算
+	if err := rdb.Set(ctx, tracker.buildPVKey("2024-01-10", ""), 7, 0).Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := tracker.Rollup(ctx); err != nil {
+		t.Fatalf("expected no error on Rollup, got %v", err)
+	}
+	if pv := rdb.Get(ctx, tracker.buildRollupPVKey("2024-W02")).Val(); pv != "5" {
+		t.Errorf("expected 2024-W02 PV to stay 5, got %s", pv)
+	}
+
+	if err := tracker.RollupRange(ctx, "2024-01-10", "2024-01-10"); err != nil {
+		t.Fatalf("expected no error on RollupRange, got %v", err)
+	}
+	for _, bucket := range []string{"2024-W02", "2024-01"} {
+		if pv := rdb.Get(ctx, tracker.buildRollupPVKey(bucket)).Val(); pv != "7" {
+			t.Errorf("expected %s PV 7, got %s", bucket, pv)
+		}
+	}
+
+	if err := tracker.RollupRange(ctx, "2024-01-10", "2024-01-01"); !errors.Is(err, ErrInvalidDate) {
+		t.Errorf("expected ErrInvalidDate, got %v", err)
+	}
+}
+
+// TestRollupWorker 测试后台汇总任务的启动和停止
+func TestRollupWorker(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	config := &Config{
+		Enabled:  true,
+		Timezone: "UTC",
+		Rollup:   RollupConfig{Enabled: true, Interval: 10 * time.Millisecond},
+	}
+	now := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(rdb, config, WithClock(func() time.Time { return now }), WithLogger(logging.Discard()))
+	ctx := context.Background()
+	if err := tracker.Track(ctx, "user1", "/api/users"); err != nil {
+		t.Fatalf("expected no error on Track, got %v", err)
+	}
+
+	worker := NewRollupWorker(tracker)
+	worker.Start(ctx)
+	defer worker.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		through, _ := rdb.HGet(ctx, tracker.buildRollupMetaKey(UVStrategySet), "2024-W02").Result()
+		if through == "2024-01-14" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2024-W02 to be rolled up through 2024-01-14, got %q", through)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	worker.Stop()
+	worker.Stop() // 重复停止不阻塞
+}
+
+// TestConfig_Validate_Rollup 测试汇总间隔不能为负数
+func TestConfig_Validate_Rollup(t *testing.T) {
+	config := &Config{Rollup: RollupConfig{Interval: -time.Second}}
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+	if got := (&RollupConfig{}).GetInterval(); got != defaultRollupInterval {
+		t.Errorf("expected default interval %v, got %v", defaultRollupInterval, got)
+	}
+}
+
+// roundTripCounter 统计发往Redis的往返次数，一条命令或一个Pipeline各算一次
+type roundTripCounter struct {
+	count atomic.Int64
+}
+
+func (c *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (c *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.count.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.count.Add(1)
+		return next(ctx, cmds)
+	}
+}
+
+// setupBatchData 写入连续days天的全站数据和某一天的paths个路径的数据，每个key一个访客
+func setupBatchData(tb testing.TB, rdb *redis.Client, tracker *Tracker, day time.Time, days, paths int) {
+	tb.Helper()
+
+	ctx := context.Background()
+	store, _ := getUVStore(UVStrategySet)
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < days; i++ {
+			date := day.AddDate(0, 0, -i).Format("2006-01-02")
+			pipe.IncrBy(ctx, tracker.buildPVKey(date, ""), 2)
+			store.add(ctx, pipe, tracker.buildUVKey(UVStrategySet, date, ""), "user1")
+		}
+		date := day.Format("2006-01-02")
+		for i := 0; i < paths; i++ {
+			path := "/api/items/" + cast.ToString(i)
+			pipe.SAdd(ctx, tracker.buildPathsKey(date), path)
+			pipe.IncrBy(ctx, tracker.buildPVKey(date, path), 2)
+			store.add(ctx, pipe, tracker.buildUVKey(UVStrategySet, date, path), "user1")
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatalf("expected no error, got %v", err)
+	}
+}
+
+// TestTracker_BatchReads 测试范围和路径查询分批读取，往返次数与天数、路径数无关，Redis错误会返回给调用方
+func TestTracker_BatchReads(t *testing.T) {
+	t.Parallel()
+
+	rdb := setupTestRedis(t)
+	counter := &roundTripCounter{}
+	rdb.AddHook(counter)
+	tracker := NewTracker(rdb, &Config{Enabled: true, EnablePathStats: true, Timezone: "UTC"})
+	ctx := context.Background()
+	day := time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+	setupBatchData(t, rdb, tracker, day, 90, 2*maxBatchSize+1)
+	date := day.Format("2006-01-02")
+
+	counter.count.Store(0)
+	rangeStats, err := tracker.GetRangeStats(ctx, day.AddDate(0, 0, -89).Format("2006-01-02"), date)
+	if err != nil {
+		t.Fatalf("expected no error on GetRangeStats, got %v", err)
+	}
+	if got := counter.count.Load(); got != 1 {
+		t.Errorf("expected 1 round trip for 90 days, got %d", got)
+	}
+	if len(rangeStats.DailyStats) != 90 || cast.ToInt64(rangeStats.TotalPV) != 180 || cast.ToInt64(rangeStats.TotalUV) != 90 {
+		t.Errorf("expected 90 days with PV=180 UV=90, got %d days PV=%d UV=%d",
+			len(rangeStats.DailyStats), rangeStats.TotalPV, rangeStats.TotalUV)
+	}
+
+	counter.count.Store(0)
+	paths, err := tracker.GetPathStats(ctx, date)
+	if err != nil {
+		t.Fatalf("expected no error on GetPathStats, got %v", err)
+	}
+	// SMEMBERS一次，1001个路径分3批
+	if got := counter.count.Load(); got != 4 {
+		t.Errorf("expected 4 round trips for %d paths, got %d", len(paths), got)
+	}
+	if len(paths) != 2*maxBatchSize+1 {
+		t.Fatalf("expected %d paths, got %d", 2*maxBatchSize+1, len(paths))
+	}
+	for _, path := range paths {
+		if cast.ToInt64(path.PV) != 2 || cast.ToInt64(path.UV) != 1 {
+			t.Fatalf("expected %s PV=2 UV=1, got PV=%d UV=%d", path.Path, path.PV, path.UV)
+		}
+	}
+
+	// 路径的UV key类型错误时返回错误，而不是把该路径计为0
+	if err := rdb.Set(ctx, tracker.buildUVKey(UVStrategySet, date, "/api/items/7"), "oops", 0).Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := tracker.GetPathStats(ctx, date); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("expected WRONGTYPE error, got %v", err)
+	}
+}
+
+// BenchmarkTracker_GetRangeStats 查询90天的范围统计，round_trips/op为每次查询的Redis往返次数
+func BenchmarkTracker_GetRangeStats(b *testing.B) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("expected no error on miniredis.Run, got %v", err)
+	}
+	b.Cleanup(mr.Close)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	counter := &roundTripCounter{}
+	rdb.AddHook(counter)
+	tracker := NewTracker(rdb, &Config{Enabled: true, Timezone: "UTC"})
+	day := time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)
+	setupBatchData(b, rdb, tracker, day, 90, 0)
+	ctx := context.Background()
+	startDate, endDate := day.AddDate(0, 0, -89).Format("2006-01-02"), day.Format("2006-01-02")
+
+	counter.count.Store(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tracker.GetRangeStats(ctx, startDate, endDate); err != nil {
+			b.Fatalf("expected no error, got %v", err)
+		}
+	}
+	b.ReportMetric(float64(counter.count.Load())/float64(b.N), "round_trips/op")
+}
+
+// BenchmarkTracker_GetPathStats 查询有1000个路径的一天，round_trips/op为每次查询的Redis往返次数
+func BenchmarkTracker_GetPathStats(b *testing.B) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("expected no error on miniredis.Run, got %v", err)
+	}
+	b.Cleanup(mr.Close)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	counter := &roundTripCounter{}
+	rdb.AddHook(counter)
+	tracker := NewTracker(rdb, &Config{Enabled: true, EnablePathStats: true, Timezone: "UTC"})
+	day := time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)
+	setupBatchData(b, rdb, tracker, day, 1, 1000)
+	ctx := context.Background()
+	date := day.Format("2006-01-02")
+
+	counter.count.Store(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tracker.GetPathStats(ctx, date); err != nil {
+			b.Fatalf("expected no error, got %v", err)
+		}
+	}
+	b.ReportMetric(float64(counter.count.Load())/float64(b.N), "round_trips/op")
+}
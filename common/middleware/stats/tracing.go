@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName 统计追踪器的instrumentation名称
+const tracerName = "working-project/common/middleware/stats"
+
+// span属性名
+const (
+	attrPath        = "stats.path"        // 访问路径
+	attrDate        = "stats.date"        // 查询的日期
+	attrStartDate   = "stats.start_date"  // 查询范围的开始日期
+	attrEndDate     = "stats.end_date"    // 查询范围的结束日期
+	attrMetric      = "stats.metric"      // 时间序列的指标
+	attrGranularity = "stats.granularity" // 时间序列的粒度
+	attrRollups     = "stats.rollups"     // 范围查询使用的周/月汇总数
+	attrDays        = "stats.days"        // 范围查询按天读取的天数
+)
+
+// WithTracerProvider 设置OpenTelemetry的TracerProvider，为Track和各查询方法创建span
+// 未设置时使用otel.GetTracerProvider()（未注册全局provider时不记录）
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *Tracker) {
+		t.tracerProvider = provider
+	}
+}
+
+// startSpan 创建span
+func (t *Tracker) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	provider := t.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan 结束span，出错时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
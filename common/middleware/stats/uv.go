@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// UV存储策略
+const (
+	UVStrategySet         = "set"         // Set：精确去重，内存随访客数增长（默认）
+	UVStrategyHyperLogLog = "hyperloglog" // HyperLogLog：每个key最多约12KB，标准误差约0.81%，适合访客量大的站点
+	UVStrategyHash        = "hash"        // Hash：访客→访问次数，精确去重，并可查询单个访客的访问次数
+)
+
+// uvStore UV存储策略
+// Track在同一个Pipeline中写入PV和UV，所以add只往Pipeline里追加命令
+type uvStore interface {
+	// add 记录访客的一次访问
+	add(ctx context.Context, pipe redis.Pipeliner, key, visitorID string)
+
+	// count 查询单个key的UV
+	count(ctx context.Context, rdb redis.Cmdable, key string) *redis.IntCmd
+
+	// unionCount 往Pipeline里追加查询多个key合并去重后UV的命令，Pipeline执行后调用返回的函数取得UV
+	unionCount(ctx context.Context, pipe redis.Pipeliner, keys []string) (func() int64, error)
+
+	// merge 把多个key合并写入dest（dest需要事先删除），用于周/月汇总
+	merge(ctx context.Context, pipe redis.Pipeliner, dest string, keys []string)
+}
+
+// uvStores 已注册的UV存储策略
+var uvStores = map[string]uvStore{
+	UVStrategySet:         setUVStore{},
+	UVStrategyHyperLogLog: hllUVStore{},
+	UVStrategyHash:        hashUVStore{},
+}
+
+// getUVStore 根据名称获取UV存储策略
+func getUVStore(name string) (uvStore, error) {
+	store, ok := uvStores[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown uv_strategy %q", ErrInvalidConfig, name)
+	}
+	return store, nil
+}
+
+// setUVStore 每天一个Set，成员为访客标识
+type setUVStore struct{}
+
+func (setUVStore) add(ctx context.Context, pipe redis.Pipeliner, key, visitorID string) {
+	pipe.SAdd(ctx, key, visitorID)
+}
+
+func (setUVStore) count(ctx context.Context, rdb redis.Cmdable, key string) *redis.IntCmd {
+	return rdb.SCard(ctx, key)
+}
+
+// unionCount 使用SUNION合并并去重，数据量大时较慢
+func (setUVStore) unionCount(ctx context.Context, pipe redis.Pipeliner, keys []string) (func() int64, error) {
+	members := pipe.SUnion(ctx, keys...)
+	return func() int64 { return int64(len(members.Val())) }, nil
+}
+
+func (setUVStore) merge(ctx context.Context, pipe redis.Pipeliner, dest string, keys []string) {
+	pipe.SUnionStore(ctx, dest, keys...)
+}
+
+// hllUVStore 每天一个HyperLogLog
+type hllUVStore struct{}
+
+func (hllUVStore) add(ctx context.Context, pipe redis.Pipeliner, key, visitorID string) {
+	pipe.PFAdd(ctx, key, visitorID)
+}
+
+func (hllUVStore) count(ctx context.Context, rdb redis.Cmdable, key string) *redis.IntCmd {
+	return rdb.PFCount(ctx, key)
+}
+
+// unionCount 用PFMERGE把各天的HyperLogLog合并到临时key，PFCOUNT后删除
+// 临时key设置了过期时间，Pipeline中途断开没有执行DEL时也会自动删除
+func (hllUVStore) unionCount(ctx context.Context, pipe redis.Pipeliner, keys []string) (func() int64, error) {
+	tmpKey, err := tempKey("stats:tmp:uv_hyperloglog:")
+	if err != nil {
+		return nil, err
+	}
+
+	pipe.PFMerge(ctx, tmpKey, keys...)
+	pipe.Expire(ctx, tmpKey, tempKeyTTL)
+	count := pipe.PFCount(ctx, tmpKey)
+	pipe.Del(ctx, tmpKey)
+	return count.Val, nil
+}
+
+func (hllUVStore) merge(ctx context.Context, pipe redis.Pipeliner, dest string, keys []string) {
+	pipe.PFMerge(ctx, dest, keys...)
+}
+
+// hashMergeScript 把多个Hash的访问次数累加到KEYS[1]
+// KEYS[1]: 目标key
+// KEYS[2..]: 源key
+var hashMergeScript = redis.NewScript(`
+	for i = 2, #KEYS do
+		local fields = redis.call('HGETALL', KEYS[i])
+		for j = 1, #fields, 2 do
+			redis.call('HINCRBY', KEYS[1], fields[j], fields[j + 1])
+		end
+	end
+	return 1
+`)
+
+// hashUVStore 每天一个Hash，field为访客标识，value为访问次数
+type hashUVStore struct{}
+
+func (hashUVStore) add(ctx context.Context, pipe redis.Pipeliner, key, visitorID string) {
+	pipe.HIncrBy(ctx, key, visitorID, 1)
+}
+
+func (hashUVStore) count(ctx context.Context, rdb redis.Cmdable, key string) *redis.IntCmd {
+	return rdb.HLen(ctx, key)
+}
+
+// unionCount Redis没有合并Hash的命令，取出各key的访客后在程序中去重
+func (hashUVStore) unionCount(ctx context.Context, pipe redis.Pipeliner, keys []string) (func() int64, error) {
+	cmds := make([]*redis.StringSliceCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.HKeys(ctx, key))
+	}
+	return func() int64 {
+		visitors := make(map[string]struct{})
+		for _, cmd := range cmds {
+			for _, visitor := range cmd.Val() {
+				visitors[visitor] = struct{}{}
+			}
+		}
+		return int64(len(visitors))
+	}, nil
+}
+
+// merge 累加各key中每个访客的访问次数，汇总后仍可以按访客查询访问次数
+// 在Pipeline中执行，无法在NOSCRIPT时重试，所以直接用EVAL发送脚本（汇总任务很少执行）
+func (hashUVStore) merge(ctx context.Context, pipe redis.Pipeliner, dest string, keys []string) {
+	hashMergeScript.Eval(ctx, pipe, append([]string{dest}, keys...))
+}
+
+// GetVisitorCount 获取访客某一天的访问次数（全站），只有uv_strategy为hash时支持
+// 参数:
+//   - ctx: 上下文
+//   - date: 日期，格式：YYYY-MM-DD
+//   - visitorID: 访客唯一标识
+func (t *Tracker) GetVisitorCount(ctx context.Context, date, visitorID string) (_ int64, err error) {
+	ctx, span := t.startSpan(ctx, "stats.GetVisitorCount", attribute.String(attrDate, date))
+	defer func() { endSpan(span, err) }()
+
+	config := t.getConfig()
+	if config.GetUVStrategy() != UVStrategyHash {
+		return 0, fmt.Errorf("%w: uv_strategy %q", ErrUnsupportedStrategy, config.GetUVStrategy())
+	}
+
+	if _, err = t.parseDate(date); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = t.guardRedis(func() error {
+		var err error
+		count, err = t.redis.HGet(ctx, t.buildUVKey(UVStrategyHash, date, ""), visitorID).Int64()
+		if err == redis.Nil {
+			count = 0
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// tempKeyTTL 临时key的过期时间
+const tempKeyTTL = time.Minute
+
+// tempKey 生成带随机后缀的临时key
+func tempKey(prefix string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
@@ -34,6 +34,13 @@ func (l *Loader) LoadFromFile(ctx context.Context, filePath string, config inter
 		return fmt.Errorf("failed to read config file %s: %w", filePath, err)
 	}
 
+	return l.Load(ctx, data, config)
+}
+
+// Load 解析YAML内容，并自动解密KMS加密的字段
+// data: YAML内容
+// config: 配置结构体指针
+func (l *Loader) Load(ctx context.Context, data []byte, config interface{}) error {
 	// 解析YAML
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return fmt.Errorf("failed to parse yaml: %w", err)
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"working-project/common/logging"
+)
+
+// defaultReloadInterval 默认的配置文件轮询间隔
+const defaultReloadInterval = 5 * time.Second
+
+// ReloadHandler 配置重载处理器
+// 一次重载会先调用所有处理器的Validate，全部通过后再依次调用Apply，
+// 保证错误的配置文件不会替换任何一个组件正在使用的配置
+type ReloadHandler struct {
+	// Name 处理器名称，用于错误信息
+	Name string
+
+	// Validate 校验新配置，返回错误则放弃本次重载（可为nil）
+	Validate func(config interface{}) error
+
+	// Apply 应用新配置
+	// 校验应该在Validate中完成，Apply只应因为极少数的运行时原因失败；某个处理器Apply失败时，
+	// 排在它前面的处理器已经使用新配置，后面的仍是旧配置，热加载器会在下次轮询时重新应用所有处理器，
+	// 所以Apply需要可以用同一份配置重复调用
+	Apply func(config interface{}) error
+}
+
+// Reloader 配置热加载器
+// 定期轮询配置文件，内容变化时重新解析YAML、解密KMS字段，并通知各处理器替换配置
+//
+// 使用方式:
+//
+//	reloader := config.NewReloader(loader, "config/app.yml", func() interface{} { return &AppConfig{} })
+//	reloader.Register(config.ReloadHandler{
+//		Name:     "ratelimit",
+//		Validate: func(c interface{}) error { return c.(*AppConfig).Middleware.RateLimit.Validate() },
+//		Apply:    func(c interface{}) error { return limiter.UpdateConfig(&c.(*AppConfig).Middleware.RateLimit) },
+//	})
+//	reloader.Start(ctx)
+//	defer reloader.Stop()
+type Reloader struct {
+	loader    *Loader
+	filePath  string
+	newConfig func() interface{} // 每次重载创建一个新的配置结构体，避免修改正在使用的配置
+	interval  time.Duration
+
+	// OnError 重载失败时的回调，默认通过slog.Default()记录错误日志
+	OnError func(err error)
+
+	mu       sync.Mutex
+	handlers []ReloadHandler
+	lastHash []byte
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewReloader 创建配置热加载器
+// 参数：
+//   - loader: 配置加载器（负责KMS解密）
+//   - filePath: 配置文件路径
+//   - newConfig: 创建空配置结构体指针的函数
+func NewReloader(loader *Loader, filePath string, newConfig func() interface{}) *Reloader {
+	return &Reloader{
+		loader:    loader,
+		filePath:  filePath,
+		newConfig: newConfig,
+		interval:  defaultReloadInterval,
+		OnError: func(err error) {
+			slog.Error("config: reload failed", logging.Err(err), slog.String("file", filePath))
+		},
+	}
+}
+
+// SetInterval 设置轮询间隔，需在Start之前调用
+func (r *Reloader) SetInterval(interval time.Duration) {
+	if interval > 0 {
+		r.interval = interval
+	}
+}
+
+// Register 注册配置重载处理器
+func (r *Reloader) Register(handler ReloadHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers = append(r.handlers, handler)
+}
+
+// Start 启动后台轮询
+// 启动时记录当前文件内容的指纹，之后只有内容变化才会触发重载
+func (r *Reloader) Start(ctx context.Context) {
+	r.mu.Lock()
+	if r.stopCh != nil {
+		r.mu.Unlock()
+		return
+	}
+	if data, err := os.ReadFile(r.filePath); err == nil {
+		r.lastHash = hashContent(data)
+	}
+	r.stopCh = make(chan struct{})
+	r.doneCh = make(chan struct{})
+	stopCh, doneCh := r.stopCh, r.doneCh
+	r.mu.Unlock()
+
+	go func() {
+		defer close(doneCh)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				if _, err := r.reloadIfChanged(ctx); err != nil && r.OnError != nil {
+					r.OnError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止后台轮询并等待其退出
+func (r *Reloader) Stop() {
+	r.mu.Lock()
+	stopCh, doneCh := r.stopCh, r.doneCh
+	r.stopCh, r.doneCh = nil, nil
+	r.mu.Unlock()
+
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+// Reload 立即重新加载配置（不论文件内容是否变化）
+// 可用于响应SIGHUP信号或管理接口
+func (r *Reloader) Reload(ctx context.Context) error {
+	data, err := os.ReadFile(r.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", r.filePath, err)
+	}
+	return r.apply(ctx, data)
+}
+
+// reloadIfChanged 文件内容变化时重新加载配置
+// 返回: changed(文件是否变化), error
+func (r *Reloader) reloadIfChanged(ctx context.Context) (bool, error) {
+	data, err := os.ReadFile(r.filePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config file %s: %w", r.filePath, err)
+	}
+
+	r.mu.Lock()
+	unchanged := r.lastHash != nil && bytes.Equal(r.lastHash, hashContent(data))
+	r.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	return true, r.apply(ctx, data)
+}
+
+// apply 解析、校验并应用配置
+// 只有校验失败（同一份文件的结果不会变化）或应用成功时才记录文件指纹：
+// 解析、解密失败可能是读到了正在写入的文件或KMS暂时不可用，应用失败会让组件的配置不一致，
+// 这些情况都不记录指纹，下次轮询时重试
+func (r *Reloader) apply(ctx context.Context, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := hashContent(data)
+
+	config := r.newConfig()
+	if err := r.loader.Load(ctx, data, config); err != nil {
+		return err
+	}
+
+	// 先全部校验，任一失败则整体放弃
+	for _, handler := range r.handlers {
+		if handler.Validate == nil {
+			continue
+		}
+		if err := handler.Validate(config); err != nil {
+			r.lastHash = hash
+			return fmt.Errorf("invalid config for %s: %w", handler.Name, err)
+		}
+	}
+
+	for _, handler := range r.handlers {
+		if err := handler.Apply(config); err != nil {
+			return fmt.Errorf("failed to apply config for %s: %w", handler.Name, err)
+		}
+	}
+
+	r.lastHash = hash
+	return nil
+}
+
+// hashContent 计算文件内容的指纹
+func hashContent(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"working-project/common/kms"
+	"working-project/common/middleware/ratelimit"
+	"working-project/common/middleware/stats"
+
+	"github.com/spf13/cast"
+)
+
+// reloadTestConfig 热加载测试使用的配置结构
+type reloadTestConfig struct {
+	Secret     string `yaml:"secret"`
+	Middleware struct {
+		RateLimit ratelimit.Config `yaml:"ratelimit"`
+		Stats     stats.Config     `yaml:"stats"`
+	} `yaml:"middleware"`
+}
+
+// reloadTestYAML 生成测试配置文件内容
+func reloadTestYAML(limit string, excludePath string) string {
+	return `
+secret: "kms://terces"
+middleware:
+  ratelimit:
+    enabled: true
+    backend: local
+    rules:
+      - path: "/api/*"
+        limit_per_minute: ` + limit + `
+        burst_size: ` + limit + `
+  stats:
+    enabled: true
+    exclude_paths:
+      - "` + excludePath + `"
+`
+}
+
+// setupReloader 创建限流器、统计追踪器，并注册到热加载器
+func setupReloader(t *testing.T, configPath string) (*Reloader, *ratelimit.Limiter, *stats.Tracker, *atomic.Value) {
+	kmsManager := kms.NewManager(kms.NewMockProvider(), "kms://")
+	loader := NewLoader(kmsManager)
+
+	var initial reloadTestConfig
+	if err := loader.LoadFromFile(context.Background(), configPath, &initial); err != nil {
+		t.Fatalf("expected no error on LoadFromFile, got %v", err)
+	}
+
+	limiter := ratelimit.NewLimiter(nil, &initial.Middleware.RateLimit)
+	tracker := stats.NewTracker(nil, &initial.Middleware.Stats)
+	secret := &atomic.Value{}
+	secret.Store(initial.Secret)
+
+	reloader := NewReloader(loader, configPath, func() interface{} { return &reloadTestConfig{} })
+	reloader.Register(ReloadHandler{
+		Name: "ratelimit",
+		Validate: func(c interface{}) error {
+			return c.(*reloadTestConfig).Middleware.RateLimit.Validate()
+		},
+		Apply: func(c interface{}) error {
+			return limiter.UpdateConfig(&c.(*reloadTestConfig).Middleware.RateLimit)
+		},
+	})
+	reloader.Register(ReloadHandler{
+		Name: "stats",
+		Validate: func(c interface{}) error {
+			return c.(*reloadTestConfig).Middleware.Stats.Validate()
+		},
+		Apply: func(c interface{}) error {
+			secret.Store(c.(*reloadTestConfig).Secret)
+			return tracker.UpdateConfig(&c.(*reloadTestConfig).Middleware.Stats)
+		},
+	})
+
+	return reloader, limiter, tracker, secret
+}
+
+// countAllowed 统计连续请求中被允许的次数
+func countAllowed(limiter *ratelimit.Limiter, key string, n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if allowed, _, _, _ := limiter.Allow(context.Background(), key, "/api/test"); allowed {
+			count++
+		}
+	}
+	return count
+}
+
+// TestReloader_Reload 测试重载后新规则生效，并且KMS字段被重新解密
+func TestReloader_Reload(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "app.yml")
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("1", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+
+	reloader, limiter, _, secret := setupReloader(t, configPath)
+
+	if got := countAllowed(limiter, "ip1", 5); got != 1 {
+		t.Errorf("expected 1 allowed request before reload, got %d", got)
+	}
+
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("3", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+	if err := reloader.Reload(context.Background()); err != nil {
+		t.Fatalf("expected no error on Reload, got %v", err)
+	}
+
+	if got := countAllowed(limiter, "ip2", 5); got != 3 {
+		t.Errorf("expected 3 allowed requests after reload, got %d", got)
+	}
+	if cast.ToString(secret.Load()) != "secret" {
+		t.Errorf("expected decrypted secret 'secret', got %v", secret.Load())
+	}
+}
+
+// TestReloader_InvalidConfigKeepsOld 测试错误的配置不会替换任何组件的配置
+func TestReloader_InvalidConfigKeepsOld(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "app.yml")
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("1", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+
+	reloader, limiter, tracker, _ := setupReloader(t, configPath)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "invalid yaml",
+			content: "middleware: [invalid yaml",
+			wantErr: "failed to parse yaml",
+		},
+		{
+			name:    "invalid ratelimit rule",
+			content: reloadTestYAML("-1", "/metrics"),
+			wantErr: "invalid config for ratelimit",
+		},
+		{
+			name:    "invalid stats config",
+			content: reloadTestYAML("5", ""),
+			wantErr: "invalid config for stats",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("expected no error on WriteFile, got %v", err)
+			}
+
+			err := reloader.Reload(context.Background())
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// 旧配置仍然生效
+	if got := countAllowed(limiter, "ip1", 5); got != 1 {
+		t.Errorf("expected old rule to allow 1 request, got %d", got)
+	}
+	if err := tracker.Track(context.Background(), "visitor", "/health"); err != nil {
+		t.Errorf("expected excluded path to be skipped without redis, got %v", err)
+	}
+}
+
+// TestReloader_Start 测试后台轮询发现文件变化后自动重载
+func TestReloader_Start(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "app.yml")
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("1", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+
+	reloader, limiter, _, _ := setupReloader(t, configPath)
+
+	var applied int32
+	reloader.Register(ReloadHandler{
+		Name: "counter",
+		Apply: func(c interface{}) error {
+			atomic.AddInt32(&applied, 1)
+			return nil
+		},
+	})
+	var lastErr atomic.Value
+	reloader.OnError = func(err error) { lastErr.Store(err) }
+
+	reloader.SetInterval(10 * time.Millisecond)
+	reloader.Start(context.Background())
+	defer reloader.Stop()
+
+	// 文件未变化时不会重载
+	time.Sleep(50 * time.Millisecond)
+	if cast.ToInt32(atomic.LoadInt32(&applied)) != 0 {
+		t.Fatalf("expected no reload before file changes, got %d", applied)
+	}
+
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("4", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&applied) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cast.ToInt32(atomic.LoadInt32(&applied)) != 1 {
+		t.Fatalf("expected 1 reload after file changes, got %d", applied)
+	}
+	if err, _ := lastErr.Load().(error); err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("expected no reload error, got %v", err)
+	}
+
+	if got := countAllowed(limiter, "ip1", 5); got != 4 {
+		t.Errorf("expected 4 allowed requests after reload, got %d", got)
+	}
+}
+
+// TestReloader_RetryTransientFailure 测试解析或应用失败时下次轮询重试，校验失败的同一份文件不再重试
+func TestReloader_RetryTransientFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "app.yml")
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("1", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+
+	reloader, _, _, _ := setupReloader(t, configPath)
+	var validated, applied int32
+	var failApply atomic.Bool
+	reloader.Register(ReloadHandler{
+		Name: "counter",
+		Validate: func(c interface{}) error {
+			atomic.AddInt32(&validated, 1)
+			return nil
+		},
+		Apply: func(c interface{}) error {
+			if failApply.Load() {
+				return errors.New("temporary failure")
+			}
+			atomic.AddInt32(&applied, 1)
+			return nil
+		},
+	})
+	ctx := context.Background()
+
+	// 读到写了一半的文件：下次轮询时读到完整的文件
+	if err := os.WriteFile(configPath, []byte("middleware: [invalid yaml"), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+	if _, err := reloader.reloadIfChanged(ctx); err == nil {
+		t.Fatalf("expected parse error, got nil")
+	}
+	if changed, _ := reloader.reloadIfChanged(ctx); !changed {
+		t.Errorf("expected unparsable file to be retried")
+	}
+
+	// 应用失败后重试，成功后不再重载
+	failApply.Store(true)
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("2", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+	if _, err := reloader.reloadIfChanged(ctx); err == nil {
+		t.Fatalf("expected apply error, got nil")
+	}
+	failApply.Store(false)
+	if changed, err := reloader.reloadIfChanged(ctx); !changed || err != nil {
+		t.Fatalf("expected retry to succeed, got changed=%v err=%v", changed, err)
+	}
+	if changed, _ := reloader.reloadIfChanged(ctx); changed {
+		t.Errorf("expected applied file not to be reloaded again")
+	}
+	if cast.ToInt32(atomic.LoadInt32(&applied)) != 1 {
+		t.Errorf("expected 1 successful apply, got %d", applied)
+	}
+
+	// 校验失败的文件不会反复重试
+	if err := os.WriteFile(configPath, []byte(reloadTestYAML("-1", "/health")), 0644); err != nil {
+		t.Fatalf("expected no error on WriteFile, got %v", err)
+	}
+	if _, err := reloader.reloadIfChanged(ctx); err == nil {
+		t.Fatalf("expected validation error, got nil")
+	}
+	if changed, _ := reloader.reloadIfChanged(ctx); changed {
+		t.Errorf("expected invalid file not to be retried")
+	}
+}
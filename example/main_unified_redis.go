@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"working-project/common/infrastructure/redis"
 	"working-project/common/kms"
@@ -37,8 +41,11 @@ func main() {
 
 	// 使用MockProvider（仅用于开发测试）
 	// 生产环境请替换为真实的KMS Provider（阿里云KMS、腾讯云KMS、AWS KMS等）
+	// 各组件的结构化日志（拒绝、放行的错误、KMS解密失败等），不设置时使用slog.Default()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
 	kmsProvider := kms.NewMockProvider()
-	kmsManager := kms.NewManager(kmsProvider, "kms://")
+	kmsManager := kms.NewManager(kmsProvider, "kms://", kms.WithLogger(logger))
 	log.Println("✅ KMS管理器初始化成功 (使用MockProvider)")
 
 	// ============================================================
@@ -91,7 +98,10 @@ func main() {
 	// ============================================================
 	log.Println("\n[4/6] 初始化限流中间件...")
 
-	limiter, err := ratelimit.NewLimiterFromManager(redisManager, &appConfig.Middleware.RateLimit)
+	// Prometheus指标，通过 /metrics 暴露
+	limiter, err := ratelimit.NewLimiterFromManager(redisManager, &appConfig.Middleware.RateLimit,
+		ratelimit.WithMetrics(ratelimit.NewMetrics(prometheus.DefaultRegisterer)),
+		ratelimit.WithLogger(logger))
 	if err != nil {
 		log.Fatalf("❌ 创建限流器失败: %v", err)
 	}
@@ -102,12 +112,39 @@ func main() {
 	// ============================================================
 	log.Println("\n[5/6] 初始化统计中间件...")
 
-	tracker, err := stats.NewTrackerFromManager(redisManager, &appConfig.Middleware.Stats)
+	tracker, err := stats.NewTrackerFromManager(redisManager, &appConfig.Middleware.Stats,
+		stats.WithMetrics(stats.NewMetrics(prometheus.DefaultRegisterer)),
+		stats.WithLogger(logger))
 	if err != nil {
 		log.Fatalf("❌ 创建统计追踪器失败: %v", err)
 	}
 	log.Printf("✅ 统计追踪器初始化成功 (Redis: %s)", appConfig.Middleware.Stats.RedisName)
 
+	// 配置热加载：修改config/app.yml中的限流规则、统计配置后自动生效
+	// 新配置校验失败时保留旧配置
+	reloader := config.NewReloader(configLoader, "config/app.yml", func() interface{} { return &AppConfig{} })
+	reloader.Register(config.ReloadHandler{
+		Name: "ratelimit",
+		Validate: func(c interface{}) error {
+			return c.(*AppConfig).Middleware.RateLimit.Validate()
+		},
+		Apply: func(c interface{}) error {
+			return limiter.UpdateConfig(&c.(*AppConfig).Middleware.RateLimit)
+		},
+	})
+	reloader.Register(config.ReloadHandler{
+		Name: "stats",
+		Validate: func(c interface{}) error {
+			return c.(*AppConfig).Middleware.Stats.Validate()
+		},
+		Apply: func(c interface{}) error {
+			return tracker.UpdateConfig(&c.(*AppConfig).Middleware.Stats)
+		},
+	})
+	reloader.Start(ctx)
+	defer reloader.Stop()
+	log.Println("✅ 配置热加载已启动 (config/app.yml)")
+
 	// ============================================================
 	// 第6步：初始化Gin路由并应用中间件
 	// ============================================================
@@ -197,6 +234,9 @@ func main() {
 		})
 	}
 
+	// Prometheus指标（已在stats的exclude_paths中排除）
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
 	// 健康检查接口
 	r.GET("/health", func(c *gin.Context) {
 		// 检查所有Redis连接的健康状态